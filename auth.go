@@ -0,0 +1,188 @@
+package main
+
+import (
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/session"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Roles de usuario soportados
+const (
+	RoleAdmin  = "admin"
+	RoleWaiter = "waiter"
+	RoleCook   = "cook"
+)
+
+// sessionStore guarda las sesiones de los usuarios autenticados (en memoria)
+var sessionStore = session.New(session.Config{
+	Expiration:     12 * time.Hour,
+	KeyLookup:      "cookie:resto_session",
+	CookieHTTPOnly: true,
+	CookieSameSite: "Lax",
+})
+
+// hashPassword genera el hash bcrypt de una contraseña
+func hashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// checkPassword compara una contraseña con su hash bcrypt
+func checkPassword(hash, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+// homeForRole devuelve la página inicial de cada rol
+func homeForRole(role string) string {
+	switch role {
+	case RoleCook:
+		return "/kitchen"
+	case RoleWaiter:
+		return "/orders"
+	default:
+		return "/"
+	}
+}
+
+// currentUser devuelve el usuario autenticado de la petición, o nil
+func currentUser(c *fiber.Ctx) *User {
+	user, _ := c.Locals("CurrentUser").(*User)
+	return user
+}
+
+// currentUserID devuelve el ID del usuario autenticado para registrar quién realizó una acción
+func currentUserID(c *fiber.Ctx) *uint {
+	if user := currentUser(c); user != nil {
+		id := user.ID
+		return &id
+	}
+	return nil
+}
+
+// LoginPage muestra el formulario de inicio de sesión
+func LoginPage(c *fiber.Ctx) error {
+	sess, err := sessionStore.Get(c)
+	if err == nil {
+		if userID, ok := sess.Get("user_id").(uint); ok {
+			var user User
+			if db.Where("active = ?", true).First(&user, userID).Error == nil {
+				return c.Redirect(homeForRole(user.Role))
+			}
+		}
+	}
+
+	return c.Render("login", fiber.Map{
+		"Title": "Iniciar sesión",
+	}, "")
+}
+
+// Login valida las credenciales y crea la sesión del usuario
+func Login(c *fiber.Ctx) error {
+	username := strings.TrimSpace(c.FormValue("username"))
+	password := c.FormValue("password")
+
+	var user User
+	if err := db.Where("username = ? AND active = ?", username, true).First(&user).Error; err != nil || !checkPassword(user.Password, password) {
+		log.Printf("Intento de inicio de sesión fallido para %q", username)
+		return c.Status(fiber.StatusUnauthorized).Render("login", fiber.Map{
+			"Title":    "Iniciar sesión",
+			"Error":    "Usuario o contraseña incorrectos",
+			"Username": username,
+		}, "")
+	}
+
+	sess, err := sessionStore.Get(c)
+	if err != nil {
+		log.Printf("Error al obtener la sesión: %v", err)
+		return c.Status(fiber.StatusInternalServerError).SendString("Error al iniciar sesión")
+	}
+	// Regenerar el ID de sesión para evitar fijación de sesión
+	if err := sess.Regenerate(); err != nil {
+		log.Printf("Error al regenerar la sesión: %v", err)
+		return c.Status(fiber.StatusInternalServerError).SendString("Error al iniciar sesión")
+	}
+	sess.Set("user_id", user.ID)
+	if err := sess.Save(); err != nil {
+		log.Printf("Error al guardar la sesión: %v", err)
+		return c.Status(fiber.StatusInternalServerError).SendString("Error al iniciar sesión")
+	}
+
+	db.Model(&user).Update("last_login", time.Now())
+	log.Printf("Usuario %s (%s) inició sesión", user.Username, user.Role)
+
+	return c.Redirect(homeForRole(user.Role))
+}
+
+// Logout cierra la sesión del usuario
+func Logout(c *fiber.Ctx) error {
+	if sess, err := sessionStore.Get(c); err == nil {
+		sess.Destroy()
+	}
+
+	if c.Get("HX-Request") == "true" {
+		c.Set("HX-Redirect", "/login")
+		return c.SendString("Sesión cerrada")
+	}
+	return c.Redirect("/login")
+}
+
+// redirectToLogin envía al usuario a la página de inicio de sesión
+func redirectToLogin(c *fiber.Ctx) error {
+	if c.Get("HX-Request") == "true" {
+		c.Set("HX-Redirect", "/login")
+		return c.Status(fiber.StatusUnauthorized).SendString("Sesión expirada")
+	}
+	return c.Redirect("/login")
+}
+
+// RequireAuth exige una sesión válida y carga el usuario en c.Locals("CurrentUser")
+func RequireAuth(c *fiber.Ctx) error {
+	sess, err := sessionStore.Get(c)
+	if err != nil {
+		return redirectToLogin(c)
+	}
+
+	userID, ok := sess.Get("user_id").(uint)
+	if !ok {
+		return redirectToLogin(c)
+	}
+
+	var user User
+	if err := db.Where("active = ?", true).First(&user, userID).Error; err != nil {
+		// El usuario fue eliminado o desactivado
+		sess.Destroy()
+		return redirectToLogin(c)
+	}
+
+	c.Locals("CurrentUser", &user)
+	return c.Next()
+}
+
+// RequireRole limita el acceso a los roles indicados; los administradores siempre tienen acceso
+func RequireRole(roles ...string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		user := currentUser(c)
+		if user == nil {
+			return redirectToLogin(c)
+		}
+
+		if user.Role == RoleAdmin {
+			return c.Next()
+		}
+		for _, role := range roles {
+			if user.Role == role {
+				return c.Next()
+			}
+		}
+
+		log.Printf("Acceso denegado a %s para %s (%s)", c.Path(), user.Username, user.Role)
+		return Error(c, "No tiene permisos para acceder a esta sección", fiber.StatusForbidden)
+	}
+}
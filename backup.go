@@ -0,0 +1,408 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Directorio de los archivos de respaldo
+const backupDir = "./backups"
+
+// Identificación y versión del formato de respaldo
+const (
+	backupFormat  = "go-clean-menu-backup"
+	backupVersion = 1
+)
+
+// backupModels son los modelos que se respaldan, en orden de dependencias: las claves foráneas
+// apuntan siempre a modelos anteriores. Backup no se incluye porque describe los propios archivos.
+var backupModels = []interface{}{
+	&User{}, &Settings{}, &Category{}, &Product{}, &ModifierGroup{}, &ModifierOption{}, &ComboSlot{}, &Table{},
+	&Order{}, &OrderCheck{}, &OrderItem{}, &OrderItemModifier{}, &Payment{}, &OrderEvent{},
+	&CategoryPrinter{}, &EmailDelivery{}, &Ingredient{}, &RecipeLine{}, &Supplier{}, &PurchaseOrder{},
+	&PurchaseOrderLine{}, &StockMovement{},
+}
+
+// backupFile es el contenido de un respaldo: un JSON comprimido con gzip cuyo encabezado
+// guarda el SHA-256 del JSON para validarlo al restaurar
+type backupFile struct {
+	Format     string        `json:"format"`
+	Version    int           `json:"version"`
+	CreatedAt  time.Time     `json:"created_at"`
+	Migrations []string      `json:"migrations"`
+	Tables     []backupTable `json:"tables"`
+}
+
+// backupTable son las filas de una tabla, con los valores en el orden de Columns
+type backupTable struct {
+	Name    string          `json:"name"`
+	Columns []string        `json:"columns"`
+	Rows    [][]interface{} `json:"rows"`
+}
+
+// backupTableNames devuelve el nombre de tabla de cada modelo respaldado
+func backupTableNames() ([]string, error) {
+	names := make([]string, 0, len(backupModels))
+	for _, model := range backupModels {
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(model); err != nil {
+			return nil, err
+		}
+		names = append(names, stmt.Schema.Table)
+	}
+	return names, nil
+}
+
+// appliedMigrations devuelve los IDs de las migraciones aplicadas, ordenados
+func appliedMigrations(tx *gorm.DB) ([]string, error) {
+	var ids []string
+	err := tx.Model(&SchemaMigration{}).Order("id").Pluck("id", &ids).Error
+	return ids, err
+}
+
+// createBackup vuelca todas las tablas de backupModels en un archivo .json.gz y registra el Backup.
+// Lee en una transacción de solo lectura REPEATABLE READ para que el respaldo sea consistente.
+func createBackup(automatic bool) (Backup, error) {
+	tables, err := backupTableNames()
+	if err != nil {
+		return Backup{}, err
+	}
+
+	dump := backupFile{Format: backupFormat, Version: backupVersion, CreatedAt: time.Now()}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		migrations, err := appliedMigrations(tx)
+		if err != nil {
+			return err
+		}
+		dump.Migrations = migrations
+		for _, table := range tables {
+			data, err := dumpTable(tx, table)
+			if err != nil {
+				return fmt.Errorf("respaldar %s: %w", table, err)
+			}
+			dump.Tables = append(dump.Tables, data)
+		}
+		return nil
+	}, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return Backup{}, err
+	}
+
+	payload, err := json.Marshal(dump)
+	if err != nil {
+		return Backup{}, err
+	}
+	payloadSum := sha256.Sum256(payload)
+
+	if err := os.MkdirAll(backupDir, os.ModePerm); err != nil {
+		return Backup{}, err
+	}
+	// Dos respaldos en el mismo segundo (por ejemplo el previo a una restauración) no se pisan
+	base := "backup-" + dump.CreatedAt.Format("20060102-150405")
+	filename := base + ".json.gz"
+	filePath := filepath.Join(backupDir, filename)
+	file, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	for n := 2; errors.Is(err, os.ErrExist); n++ {
+		filename = fmt.Sprintf("%s-%d.json.gz", base, n)
+		filePath = filepath.Join(backupDir, filename)
+		file, err = os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	}
+	if err != nil {
+		return Backup{}, err
+	}
+	fileHash := sha256.New()
+	zw := gzip.NewWriter(io.MultiWriter(file, fileHash))
+	zw.Name = strings.TrimSuffix(filename, ".gz")
+	zw.ModTime = dump.CreatedAt
+	zw.Comment = "sha256:" + hex.EncodeToString(payloadSum[:])
+	_, err = zw.Write(payload)
+	if closeErr := zw.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(filePath)
+		return Backup{}, err
+	}
+
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return Backup{}, err
+	}
+	backup := Backup{
+		FileName:  filename,
+		FilePath:  filePath,
+		Size:      info.Size(),
+		Checksum:  hex.EncodeToString(fileHash.Sum(nil)),
+		Automatic: automatic,
+	}
+	if err := db.Create(&backup).Error; err != nil {
+		os.Remove(filePath)
+		return Backup{}, err
+	}
+	return backup, nil
+}
+
+// dumpTable lee todas las filas de una tabla, incluidas las eliminadas lógicamente
+func dumpTable(tx *gorm.DB, table string) (backupTable, error) {
+	rows, err := tx.Table(table).Order("id").Rows()
+	if err != nil {
+		return backupTable{}, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return backupTable{}, err
+	}
+	data := backupTable{Name: table, Columns: columns, Rows: [][]interface{}{}}
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		pointers := make([]interface{}, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			return backupTable{}, err
+		}
+		data.Rows = append(data.Rows, values)
+	}
+	return data, rows.Err()
+}
+
+// verifyBackupChecksum comprueba que el archivo no cambió desde que se creó el respaldo
+func verifyBackupChecksum(backup Backup) error {
+	file, err := os.Open(backup.FilePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return err
+	}
+	if backup.Checksum == "" || hex.EncodeToString(hash.Sum(nil)) != backup.Checksum {
+		return errors.New("la suma de verificación del archivo no coincide")
+	}
+	return nil
+}
+
+// readBackupFile lee y valida un archivo de respaldo: formato, versión, SHA-256 del contenido,
+// migraciones iguales a las de la base actual y solo tablas conocidas
+func readBackupFile(path string) (backupFile, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return backupFile{}, err
+	}
+	defer file.Close()
+
+	zr, err := gzip.NewReader(file)
+	if err != nil {
+		return backupFile{}, errors.New("no es un respaldo comprimido válido")
+	}
+	expected, ok := strings.CutPrefix(zr.Comment, "sha256:")
+	if !ok {
+		return backupFile{}, errors.New("el respaldo no tiene suma de verificación")
+	}
+	payload, err := io.ReadAll(zr)
+	if err != nil {
+		return backupFile{}, fmt.Errorf("respaldo dañado: %w", err)
+	}
+	sum := sha256.Sum256(payload)
+	if hex.EncodeToString(sum[:]) != expected {
+		return backupFile{}, errors.New("la suma de verificación del contenido no coincide")
+	}
+
+	var dump backupFile
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+	if err := decoder.Decode(&dump); err != nil {
+		return backupFile{}, fmt.Errorf("contenido inválido: %w", err)
+	}
+	if dump.Format != backupFormat || dump.Version != backupVersion {
+		return backupFile{}, fmt.Errorf("formato de respaldo no admitido (%s v%d)", dump.Format, dump.Version)
+	}
+
+	current, err := appliedMigrations(db)
+	if err != nil {
+		return backupFile{}, err
+	}
+	if !slices.Equal(dump.Migrations, current) {
+		return backupFile{}, errors.New("el respaldo es de otra versión del esquema")
+	}
+
+	known, err := backupTableNames()
+	if err != nil {
+		return backupFile{}, err
+	}
+	for _, table := range dump.Tables {
+		if !slices.Contains(known, table.Name) {
+			return backupFile{}, fmt.Errorf("tabla desconocida en el respaldo: %s", table.Name)
+		}
+		for _, row := range table.Rows {
+			if len(row) != len(table.Columns) {
+				return backupFile{}, fmt.Errorf("fila incompleta en la tabla %s", table.Name)
+			}
+		}
+	}
+	return dump, nil
+}
+
+// restoreBackup reemplaza los datos actuales por los del respaldo. Las filas se cargan primero en
+// un esquema nuevo con las mismas tablas (tipos, índices únicos) y, si todo es válido, se copian
+// al esquema actual. Todo ocurre en una transacción: ante cualquier error no cambia nada.
+// Al terminar se cierran todas las sesiones, porque los IDs de usuario pueden ser de otras cuentas.
+func restoreBackup(path string) error {
+	dump, err := readBackupFile(path)
+	if err != nil {
+		return err
+	}
+	tables, err := backupTableNames()
+	if err != nil {
+		return err
+	}
+	byName := make(map[string]backupTable, len(dump.Tables))
+	for _, table := range dump.Tables {
+		byName[table.Name] = table
+	}
+
+	staging := fmt.Sprintf("restore_%d", time.Now().UnixNano())
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var live string
+		if err := tx.Raw("SELECT current_schema()").Scan(&live).Error; err != nil {
+			return err
+		}
+		if err := tx.Exec("CREATE SCHEMA " + staging).Error; err != nil {
+			return err
+		}
+
+		// Cargar y validar el respaldo en el esquema nuevo
+		columnsByTable := make(map[string][]string, len(tables))
+		for _, table := range tables {
+			if err := tx.Exec(fmt.Sprintf(`CREATE TABLE %s.%s (LIKE %s.%s INCLUDING ALL)`, staging, table, live, table)).Error; err != nil {
+				return err
+			}
+			data, ok := byName[table]
+			if !ok {
+				continue
+			}
+			columns, err := restoreColumns(tx, live, table, data.Columns)
+			if err != nil {
+				return err
+			}
+			columnsByTable[table] = columns
+			if err := loadBackupRows(tx, staging+"."+table, data, columns); err != nil {
+				return fmt.Errorf("restaurar %s: %w", table, err)
+			}
+		}
+
+		// Reemplazar los datos actuales. TRUNCATE no dispara el trigger de solo inserción de order_events.
+		quoted := make([]string, len(tables))
+		for i, table := range tables {
+			quoted[i] = live + "." + table
+		}
+		if err := tx.Exec("TRUNCATE " + strings.Join(quoted, ", ") + " RESTART IDENTITY CASCADE").Error; err != nil {
+			return err
+		}
+		for _, table := range tables {
+			columns := columnsByTable[table]
+			if len(columns) == 0 {
+				continue
+			}
+			list := `"` + strings.Join(columns, `", "`) + `"`
+			copySQL := fmt.Sprintf(`INSERT INTO %s.%s (%s) SELECT %s FROM %s.%s`, live, table, list, list, staging, table)
+			if err := tx.Exec(copySQL).Error; err != nil {
+				return fmt.Errorf("restaurar %s: %w", table, err)
+			}
+			// Continuar la secuencia de IDs después del último restaurado
+			resetSQL := fmt.Sprintf(`SELECT setval(pg_get_serial_sequence('%s.%s', 'id'), COALESCE(MAX(id), 1), MAX(id) IS NOT NULL) FROM %s.%s`, live, table, live, table)
+			if err := tx.Exec(resetSQL).Error; err != nil {
+				return err
+			}
+		}
+
+		return tx.Exec("DROP SCHEMA " + staging + " CASCADE").Error
+	})
+	if err != nil {
+		return err
+	}
+	if err := sessionStore.Reset(); err != nil {
+		log.Printf("No se pudieron cerrar las sesiones tras restaurar: %v", err)
+	}
+	return nil
+}
+
+// restoreColumns devuelve las columnas del respaldo que siguen existiendo en la tabla actual;
+// las columnas nuevas quedan con su valor por defecto
+func restoreColumns(tx *gorm.DB, schema, table string, backupColumns []string) ([]string, error) {
+	var existing []string
+	err := tx.Raw(`
+        SELECT column_name FROM information_schema.columns
+        WHERE table_schema = ? AND table_name = ?
+    `, schema, table).Scan(&existing).Error
+	if err != nil {
+		return nil, err
+	}
+	var columns []string
+	for _, column := range backupColumns {
+		if slices.Contains(existing, column) {
+			columns = append(columns, column)
+		}
+	}
+	return columns, nil
+}
+
+// loadBackupRows inserta las filas de una tabla del respaldo en target (esquema.tabla).
+// Los valores se envían como texto y PostgreSQL los convierte al tipo de cada columna.
+func loadBackupRows(tx *gorm.DB, target string, data backupTable, columns []string) error {
+	index := make(map[string]int, len(data.Columns))
+	for i, column := range data.Columns {
+		index[column] = i
+	}
+
+	const batchSize = 500
+	for start := 0; start < len(data.Rows); start += batchSize {
+		end := min(start+batchSize, len(data.Rows))
+		batch := make([]map[string]interface{}, 0, end-start)
+		for _, row := range data.Rows[start:end] {
+			values := make(map[string]interface{}, len(columns))
+			for _, column := range columns {
+				value := row[index[column]]
+				if number, ok := value.(json.Number); ok {
+					value = number.String()
+				}
+				values[column] = value
+			}
+			batch = append(batch, values)
+		}
+		if err := tx.Table(target).Create(&batch).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteBackup elimina el archivo de un respaldo y su registro
+func deleteBackup(backup Backup) error {
+	if err := os.Remove(backup.FilePath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return db.Delete(&backup).Error
+}
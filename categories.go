@@ -0,0 +1,266 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+var (
+	categoryColorPattern = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+	categoryIconPattern  = regexp.MustCompile(`^[a-z0-9-]+$`)
+)
+
+// loadCategories devuelve las categorías en el orden del menú
+func loadCategories(tx *gorm.DB, activeOnly bool) []Category {
+	var categories []Category
+	query := tx.Order("position, name")
+	if activeOnly {
+		query = query.Where("active = ?", true)
+	}
+	query.Find(&categories)
+	return categories
+}
+
+// findOrCreateCategory busca una categoría por nombre y la crea al final del menú si no existe
+func findOrCreateCategory(tx *gorm.DB, name string) (Category, error) {
+	var category Category
+	if err := tx.Where("name = ?", name).First(&category).Error; err == nil {
+		return category, nil
+	}
+	category = Category{Name: name, Position: nextCategoryPosition(tx), Active: true}
+	return category, tx.Create(&category).Error
+}
+
+func nextCategoryPosition(tx *gorm.DB) int {
+	var position int
+	tx.Model(&Category{}).Select("COALESCE(MAX(position), 0)").Scan(&position)
+	return position + 1
+}
+
+// categorySidebarData arma los datos del panel de categorías del menú
+func categorySidebarData(selected string) fiber.Map {
+	var counts []struct {
+		CategoryID uint
+		Count      int64
+	}
+	db.Model(&Product{}).Select("category_id, COUNT(*) AS count").Where("category_id IS NOT NULL").Group("category_id").Scan(&counts)
+	productCounts := make(map[uint]int64, len(counts))
+	for _, row := range counts {
+		productCounts[row.CategoryID] = row.Count
+	}
+
+	var productCount int64
+	db.Model(&Product{}).Count(&productCount)
+
+	if selected == "" {
+		selected = "all"
+	}
+	return fiber.Map{
+		"Categories":    loadCategories(db, false),
+		"ProductCount":  productCount,
+		"ProductCounts": productCounts,
+		"Filters": fiber.Map{
+			"Category": selected,
+		},
+	}
+}
+
+// GetCategoryList devuelve la lista de categorías para actualizar el panel lateral
+func GetCategoryList(c *fiber.Ctx) error {
+	return c.Render("partials/category_sidebar", categorySidebarData(c.Query("category")), "")
+}
+
+// GetCategoryForm muestra el formulario para añadir una categoría
+func GetCategoryForm(c *fiber.Ctx) error {
+	return c.Render("partials/category_form", fiber.Map{
+		"Categories": loadCategories(db, false),
+		"IsNew":      true,
+	}, "")
+}
+
+// GetCategoryEditForm muestra el formulario para editar una categoría
+func GetCategoryEditForm(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).SendString("ID inválido")
+	}
+
+	var category Category
+	if result := db.First(&category, id); result.Error != nil {
+		return c.Status(fiber.StatusNotFound).SendString("Categoría no encontrada")
+	}
+
+	return c.Render("partials/category_form", fiber.Map{
+		"Category": category,
+		"IsNew":    false,
+	}, "")
+}
+
+// categoryFromForm lee y valida los campos del formulario de categoría
+func categoryFromForm(c *fiber.Ctx, category *Category) string {
+	category.Name = strings.TrimSpace(c.FormValue("name"))
+	category.Description = strings.TrimSpace(c.FormValue("description"))
+	category.Color = strings.TrimSpace(c.FormValue("color"))
+	category.Icon = strings.TrimPrefix(strings.TrimSpace(c.FormValue("icon")), "bi-")
+
+	switch {
+	case category.Name == "":
+		return "El nombre de la categoría no puede estar vacío"
+	case category.Color != "" && !categoryColorPattern.MatchString(category.Color):
+		return "El color debe tener el formato #rrggbb"
+	case category.Icon != "" && !categoryIconPattern.MatchString(category.Icon):
+		return "El ícono debe ser un nombre de Bootstrap Icons, por ejemplo cup-hot"
+	}
+	return ""
+}
+
+// CreateCategory crea una nueva categoría al final del menú
+func CreateCategory(c *fiber.Ctx) error {
+	var category Category
+	if msg := categoryFromForm(c, &category); msg != "" {
+		return Error(c, msg, fiber.StatusBadRequest)
+	}
+	category.Active = true
+
+	// Verificar si la categoría ya existe
+	var count int64
+	db.Model(&Category{}).Where("LOWER(name) = LOWER(?)", category.Name).Count(&count)
+	if count > 0 {
+		return Error(c, "Esta categoría ya existe", fiber.StatusBadRequest)
+	}
+
+	category.Position = nextCategoryPosition(db)
+	if result := db.Create(&category); result.Error != nil {
+		return Error(c, "Error al crear la categoría", fiber.StatusInternalServerError)
+	}
+
+	setTrigger(c, fiber.Map{"showToast": "Categoría '" + category.Name + "' creada con éxito", "refreshCategories": true, "closeModal": true})
+	return c.Render("partials/category_sidebar", categorySidebarData("all"), "")
+}
+
+// UpdateCategory guarda los cambios de una categoría. Al renombrarla se actualiza el nombre
+// guardado en sus productos y en su impresora de comandas; las órdenes conservan el anterior.
+func UpdateCategory(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return Error(c, "ID de categoría inválido", fiber.StatusBadRequest)
+	}
+
+	var category Category
+	if result := db.First(&category, id); result.Error != nil {
+		return Error(c, "Categoría no encontrada", fiber.StatusNotFound)
+	}
+	oldName := category.Name
+
+	if msg := categoryFromForm(c, &category); msg != "" {
+		return Error(c, msg, fiber.StatusBadRequest)
+	}
+	category.Active = c.FormValue("active") == "on"
+
+	var count int64
+	db.Model(&Category{}).Where("LOWER(name) = LOWER(?) AND id <> ?", category.Name, category.ID).Count(&count)
+	if count > 0 {
+		return Error(c, "Ya existe otra categoría con ese nombre", fiber.StatusBadRequest)
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&category).Error; err != nil {
+			return err
+		}
+		if category.Name == oldName {
+			return nil
+		}
+		return tx.Model(&Product{}).Where("category_id = ?", category.ID).Update("category", category.Name).Error
+	})
+	if err != nil {
+		return Error(c, "Error al actualizar la categoría", fiber.StatusInternalServerError)
+	}
+
+	setTrigger(c, fiber.Map{"showToast": "Categoría '" + category.Name + "' actualizada", "refreshCategories": true, "closeModal": true})
+	return c.Render("partials/category_sidebar", categorySidebarData("all"), "")
+}
+
+// DeleteCategory elimina una categoría sin productos
+func DeleteCategory(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return Error(c, "ID de categoría inválido", fiber.StatusBadRequest)
+	}
+
+	var category Category
+	if result := db.First(&category, id); result.Error != nil {
+		return Error(c, "Categoría no encontrada", fiber.StatusNotFound)
+	}
+
+	var count int64
+	db.Model(&Product{}).Where("category_id = ?", category.ID).Count(&count)
+	if count > 0 {
+		return Error(c, "La categoría tiene productos: muévelos o elimínalos primero", fiber.StatusConflict)
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("category_id = ?", category.ID).Delete(&CategoryPrinter{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&category).Error
+	})
+	if err != nil {
+		return Error(c, "Error al eliminar la categoría", fiber.StatusInternalServerError)
+	}
+
+	setTrigger(c, fiber.Map{"showToast": "Categoría '" + category.Name + "' eliminada", "refreshCategories": true})
+	return c.Render("partials/category_sidebar", categorySidebarData("all"), "")
+}
+
+// MoveCategory sube o baja una categoría en el orden del menú (direction=up|down)
+func MoveCategory(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return Error(c, "ID de categoría inválido", fiber.StatusBadRequest)
+	}
+	direction := c.FormValue("direction")
+	if direction != "up" && direction != "down" {
+		return Error(c, "Dirección inválida", fiber.StatusBadRequest)
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		categories := loadCategories(tx, false)
+		index := -1
+		for i, category := range categories {
+			if category.ID == uint(id) {
+				index = i
+			}
+		}
+		if index < 0 {
+			return gorm.ErrRecordNotFound
+		}
+		other := index - 1
+		if direction == "down" {
+			other = index + 1
+		}
+		if other < 0 || other >= len(categories) {
+			return nil
+		}
+		categories[index], categories[other] = categories[other], categories[index]
+
+		// Se renumeran todas para corregir posiciones repetidas
+		for i, category := range categories {
+			if category.Position == i+1 {
+				continue
+			}
+			if err := tx.Model(&Category{}).Where("id = ?", category.ID).Update("position", i+1).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return Error(c, "Error al ordenar las categorías", fiber.StatusInternalServerError)
+	}
+
+	return c.Render("partials/category_sidebar", categorySidebarData(c.Query("category")), "")
+}
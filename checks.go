@@ -0,0 +1,343 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// Límite de cuentas en que se puede dividir una orden
+const maxOrderChecks = 20
+
+// CheckSummary es el estado de cobro de una cuenta separada
+type CheckSummary struct {
+	Check   OrderCheck
+	Items   []OrderItem // Ítems asignados a esta cuenta
+	Shared  bool        // Incluye una parte de los ítems sin asignar
+	Total   Money
+	Paid    Money
+	Balance Money
+}
+
+// splitChecks calcula el importe, lo pagado y el saldo de cada cuenta de una orden
+// con Items, Checks y Payments cargados. Cada cuenta paga sus ítems más una parte igual
+// de los ítems sin asignar; impuestos, cargo por servicio y propina se reparten en la
+// misma proporción y la última cuenta absorbe el redondeo para que la suma sea el total.
+func splitChecks(order Order) []CheckSummary {
+	n := len(order.Checks)
+	if n == 0 {
+		return nil
+	}
+
+	summaries := make([]CheckSummary, n)
+	index := make(map[uint]int, n)
+	for i, check := range order.Checks {
+		summaries[i].Check = check
+		index[check.ID] = i
+	}
+
+	assigned := make([]int64, n)
+	var shared int64
+	for _, item := range order.Items {
+		gross := int64(item.UnitPrice.Mul(item.Quantity))
+		if item.CheckID != nil {
+			if i, ok := index[*item.CheckID]; ok {
+				assigned[i] += gross
+				summaries[i].Items = append(summaries[i].Items, item)
+				continue
+			}
+		}
+		shared += gross
+	}
+
+	// Peso de cada cuenta multiplicado por n para repartir lo compartido sin decimales
+	var totalWeight int64
+	weights := make([]int64, n)
+	for i := range weights {
+		weights[i] = assigned[i]*int64(n) + shared
+		totalWeight += weights[i]
+		summaries[i].Shared = shared > 0
+	}
+
+	remaining := order.Total
+	for i := range summaries {
+		switch {
+		case i == n-1:
+			summaries[i].Total = remaining
+		case totalWeight == 0:
+			summaries[i].Total = order.Total / Money(n)
+		default:
+			summaries[i].Total = Money(int64(order.Total) * weights[i] / totalWeight)
+		}
+		remaining -= summaries[i].Total
+	}
+
+	for _, payment := range order.Payments {
+		if payment.CheckID == nil {
+			continue
+		}
+		if i, ok := index[*payment.CheckID]; ok {
+			summaries[i].Paid += payment.Amount
+		}
+	}
+	for i := range summaries {
+		summaries[i].Balance = summaries[i].Total - summaries[i].Paid
+	}
+	return summaries
+}
+
+// loadOrderForBilling carga en tx los ítems que ve el cliente, las cuentas y los pagos de la orden
+func loadOrderForBilling(tx *gorm.DB, order *Order) error {
+	if err := orderLines(tx).Where("order_id = ?", order.ID).Order("id ASC").Find(&order.Items).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("order_id = ?", order.ID).Order("number ASC").Find(&order.Checks).Error; err != nil {
+		return err
+	}
+	return tx.Where("order_id = ?", order.ID).Order("id ASC").Find(&order.Payments).Error
+}
+
+// GetOrderSplit muestra el editor para dividir la cuenta de una orden
+func GetOrderSplit(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).SendString("ID inválido")
+	}
+
+	var order Order
+	if err := db.First(&order, id).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).SendString("Orden no encontrada")
+	}
+	if err := loadOrderForBilling(db, &order); err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString("Error al cargar la orden")
+	}
+
+	// Cantidad de columnas: las cuentas actuales o las pedidas con ?checks=N
+	count := len(order.Checks)
+	if requested, err := strconv.Atoi(c.Query("checks")); err == nil {
+		count = requested
+	}
+	if count < 2 {
+		count = 2
+	}
+	if count > maxOrderChecks {
+		count = maxOrderChecks
+	}
+
+	// Cuenta (1..N) de cada ítem; 0 = compartido
+	numbers := make(map[uint]int, len(order.Checks))
+	for _, check := range order.Checks {
+		numbers[check.ID] = check.Number
+	}
+	assignments := make(map[uint]int, len(order.Items))
+	for _, item := range order.Items {
+		if item.CheckID != nil {
+			assignments[item.ID] = numbers[*item.CheckID]
+		}
+	}
+	columns := make([]int, count)
+	for i := range columns {
+		columns[i] = i + 1
+	}
+
+	return c.Render("order_split", fiber.Map{
+		"Title":       "Dividir cuenta - Orden #" + strconv.Itoa(id),
+		"ActivePage":  "orders",
+		"Order":       order,
+		"OrderID":     order.ID,
+		"Count":       count,
+		"Columns":     columns,
+		"Assignments": assignments,
+		"Checks":      splitChecks(order),
+		"Editable":    isOrderEditable(order.Status) && len(order.Payments) == 0,
+	})
+}
+
+// SplitOrder divide la orden en cuentas separadas.
+// mode puede ser:
+//   - "items": cada ítem va a la cuenta indicada en check_<id> (0 = compartido)
+//   - "seats": una cuenta por asiento; los ítems sin asiento se comparten
+//   - "even": count cuentas que se reparten el total en partes iguales
+//
+// En todos los casos se guardan los asientos enviados en seat_<id>.
+func SplitOrder(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).SendString("ID inválido")
+	}
+
+	mode := c.FormValue("mode")
+	count, _ := strconv.Atoi(c.FormValue("count"))
+	if mode != "seats" && (count < 2 || count > maxOrderChecks) {
+		return Error(c, "La cantidad de cuentas debe estar entre 2 y "+strconv.Itoa(maxOrderChecks), fiber.StatusBadRequest)
+	}
+
+	var order Order
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := lockOrder(tx, uint(id), &order); err != nil {
+			return err
+		}
+		if !isOrderEditable(order.Status) {
+			return errOrderClosed(order)
+		}
+		if err := loadOrderForBilling(tx, &order); err != nil {
+			return err
+		}
+		if len(order.Payments) > 0 {
+			return fiber.NewError(fiber.StatusBadRequest, "La orden ya tiene pagos registrados y no se puede dividir")
+		}
+
+		// Asientos indicados en el formulario
+		for i := range order.Items {
+			value := strings.TrimSpace(c.FormValue("seat_" + strconv.FormatUint(uint64(order.Items[i].ID), 10)))
+			if value == "" {
+				continue
+			}
+			seat, err := strconv.Atoi(value)
+			if err != nil || seat < 0 {
+				return fiber.NewError(fiber.StatusBadRequest, "Asiento inválido")
+			}
+			order.Items[i].Seat = seat
+		}
+
+		// Números de cuenta de cada ítem y etiquetas de cada cuenta
+		labels := map[int]string{}
+		assignments := make([]int, len(order.Items))
+		switch mode {
+		case "items":
+			for i, item := range order.Items {
+				number, err := strconv.Atoi(c.FormValue("check_"+strconv.FormatUint(uint64(item.ID), 10), "0"))
+				if err != nil || number < 0 || number > count {
+					return fiber.NewError(fiber.StatusBadRequest, "Cuenta inválida para "+item.ProductName)
+				}
+				assignments[i] = number
+			}
+		case "seats":
+			seats := []int{}
+			seen := map[int]bool{}
+			for _, item := range order.Items {
+				if item.Seat > 0 && !seen[item.Seat] {
+					seen[item.Seat] = true
+					seats = append(seats, item.Seat)
+				}
+			}
+			if len(seats) < 2 {
+				return fiber.NewError(fiber.StatusBadRequest, "Asigna al menos dos asientos distintos para dividir por asiento")
+			}
+			if len(seats) > maxOrderChecks {
+				return fiber.NewError(fiber.StatusBadRequest, "Demasiados asientos para dividir la cuenta")
+			}
+			sort.Ints(seats)
+			numberBySeat := make(map[int]int, len(seats))
+			for i, seat := range seats {
+				numberBySeat[seat] = i + 1
+				labels[i+1] = "Asiento " + strconv.Itoa(seat)
+			}
+			for i, item := range order.Items {
+				assignments[i] = numberBySeat[item.Seat]
+			}
+			count = len(seats)
+		case "even":
+			// Ningún ítem asignado: todas las cuentas pagan lo mismo
+		default:
+			return fiber.NewError(fiber.StatusBadRequest, "Modo de división inválido")
+		}
+
+		if mode == "items" {
+			used := make(map[int]bool, count)
+			shared := false
+			for _, number := range assignments {
+				used[number] = true
+				shared = shared || number == 0
+			}
+			for number := 1; number <= count && !shared; number++ {
+				if !used[number] {
+					return fiber.NewError(fiber.StatusBadRequest, "La cuenta "+strconv.Itoa(number)+" no tiene productos")
+				}
+			}
+		}
+
+		// Reemplazar la división anterior
+		if err := clearOrderChecks(tx, order.ID); err != nil {
+			return err
+		}
+		checkIDs := make(map[int]uint, count)
+		for number := 1; number <= count; number++ {
+			check := OrderCheck{OrderID: order.ID, Number: number, Label: labels[number]}
+			if err := tx.Create(&check).Error; err != nil {
+				return err
+			}
+			checkIDs[number] = check.ID
+		}
+		for i := range order.Items {
+			order.Items[i].CheckID = nil
+			if checkID, ok := checkIDs[assignments[i]]; ok {
+				order.Items[i].CheckID = &checkID
+			}
+			if err := tx.Model(&order.Items[i]).Select("seat", "check_id").Updates(&order.Items[i]).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return orderErrorResponse(c, err)
+	}
+	broadcastOrderUpdate(order)
+
+	c.Set("HX-Trigger", `{"showToast": "Cuenta dividida"}`)
+	c.Set("HX-Redirect", "/order/"+strconv.Itoa(id))
+	return c.SendString("Cuenta dividida")
+}
+
+// UnsplitOrder vuelve a juntar las cuentas de una orden mientras no tengan pagos
+func UnsplitOrder(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).SendString("ID inválido")
+	}
+
+	var order Order
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := lockOrder(tx, uint(id), &order); err != nil {
+			return err
+		}
+		if !isOrderEditable(order.Status) {
+			return errOrderClosed(order)
+		}
+		paid, err := hasPaidChecks(tx, order.ID)
+		if err != nil {
+			return err
+		}
+		if paid {
+			return fiber.NewError(fiber.StatusBadRequest, "Hay cuentas con pagos registrados y no se pueden juntar")
+		}
+		return clearOrderChecks(tx, order.ID)
+	})
+	if err != nil {
+		return orderErrorResponse(c, err)
+	}
+	broadcastOrderUpdate(order)
+
+	c.Set("HX-Trigger", `{"showToast": "Cuentas unidas"}`)
+	c.Set("HX-Redirect", "/order/"+strconv.Itoa(id))
+	return c.SendString("Cuentas unidas")
+}
+
+// hasPaidChecks indica si alguna cuenta separada de la orden ya tiene pagos o reembolsos registrados
+func hasPaidChecks(tx *gorm.DB, orderID uint) (bool, error) {
+	var count int64
+	err := tx.Model(&Payment{}).Where("order_id = ? AND check_id IS NOT NULL", orderID).Count(&count).Error
+	return count > 0, err
+}
+
+// clearOrderChecks borra las cuentas de la orden y desasigna sus ítems
+func clearOrderChecks(tx *gorm.DB, orderID uint) error {
+	if err := tx.Model(&OrderItem{}).Where("order_id = ?", orderID).Update("check_id", nil).Error; err != nil {
+		return err
+	}
+	return tx.Where("order_id = ?", orderID).Delete(&OrderCheck{}).Error
+}
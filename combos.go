@@ -0,0 +1,348 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// errComboComponent se devuelve al intentar cambiar por separado un componente de un combo
+var errComboComponent = fiber.NewError(fiber.StatusBadRequest, "El producto es parte de un combo: modifica el combo completo")
+
+// orderLines limita los ítems de una orden a las líneas que ve el cliente: los combos sin sus componentes
+func orderLines(tx *gorm.DB) *gorm.DB {
+	return tx.Where("parent_item_id IS NULL")
+}
+
+// kitchenLines limita los ítems de una orden a los que prepara cocina: los componentes en lugar del combo
+func kitchenLines(tx *gorm.DB) *gorm.DB {
+	return tx.Where("is_combo = ?", false)
+}
+
+// loadComboSlots devuelve los componentes del combo en orden; no tiene si el producto no es un combo
+func loadComboSlots(tx *gorm.DB, productID uint) []ComboSlot {
+	var slots []ComboSlot
+	tx.Where("combo_id = ?", productID).Order("position, id").Find(&slots)
+	return slots
+}
+
+// isCombo indica si el producto tiene componentes
+func isCombo(tx *gorm.DB, productID uint) bool {
+	var count int64
+	tx.Model(&ComboSlot{}).Where("combo_id = ?", productID).Count(&count)
+	return count > 0
+}
+
+// comboChoices devuelve los productos que se pueden elegir en un componente: los disponibles
+// de su categoría que no son combos
+func comboChoices(tx *gorm.DB, slot ComboSlot) []Product {
+	var products []Product
+	if slot.CategoryID == nil {
+		return products
+	}
+	tx.Where("category_id = ? AND is_available = ?", *slot.CategoryID, true).
+		Where("id NOT IN (?)", tx.Model(&ComboSlot{}).Select("combo_id")).
+		Order("name").Find(&products)
+	return products
+}
+
+// formComboChoices lee los productos elegidos en el formulario de agregar producto, combo_slot_<id>
+func formComboChoices(c *fiber.Ctx) map[uint]uint {
+	choices := map[uint]uint{}
+	c.Request().PostArgs().VisitAll(func(key, value []byte) {
+		slotID, err := strconv.ParseUint(strings.TrimPrefix(string(key), "combo_slot_"), 10, 64)
+		if err != nil || !strings.HasPrefix(string(key), "combo_slot_") {
+			return
+		}
+		if productID, err := strconv.ParseUint(string(value), 10, 64); err == nil && productID > 0 {
+			choices[uint(slotID)] = uint(productID)
+		}
+	})
+	return choices
+}
+
+// resolveCombo valida lo elegido en cada componente y devuelve los ítems que prepara cocina,
+// uno por componente, con la copia del producto y precio cero
+func resolveCombo(tx *gorm.DB, slots []ComboSlot, choices map[uint]uint) ([]OrderItem, error) {
+	components := make([]OrderItem, 0, len(slots))
+	for _, slot := range slots {
+		productID := choices[slot.ID]
+		if slot.ProductID != nil {
+			productID = *slot.ProductID
+		}
+		if productID == 0 {
+			return nil, fmt.Errorf("elige una opción de %s", slot.Name)
+		}
+
+		var product Product
+		if err := tx.First(&product, productID).Error; err != nil {
+			return nil, fmt.Errorf("el producto de %s ya no existe", slot.Name)
+		}
+		if !product.IsAvailable {
+			return nil, fmt.Errorf("%s no está disponible", product.Name)
+		}
+		if slot.ProductID == nil && (product.CategoryID == nil || slot.CategoryID == nil || *product.CategoryID != *slot.CategoryID) {
+			return nil, fmt.Errorf("%s no es una opción de %s", product.Name, slot.Name)
+		}
+		if isCombo(tx, product.ID) {
+			return nil, fmt.Errorf("%s es un combo y no puede ser parte de otro", product.Name)
+		}
+
+		component := OrderItem{ProductID: product.ID}
+		component.snapshotProduct(product)
+		component.UnitPrice = 0
+		components = append(components, component)
+	}
+	return components, nil
+}
+
+// applyCombo marca el ítem como combo y agrega los componentes elegidos a la clave que evita
+// unir ítems distintos y a los nombres que se muestran al cliente. Va después de applyModifiers.
+func (item *OrderItem) applyCombo(components []OrderItem) {
+	ids := make([]string, 0, len(components))
+	names := make([]string, 0, len(components)+1)
+	for _, component := range components {
+		ids = append(ids, strconv.FormatUint(uint64(component.ProductID), 10))
+		names = append(names, component.ProductName)
+	}
+	if item.ModifierNames != "" {
+		names = append(names, item.ModifierNames)
+	}
+	item.IsCombo = true
+	item.ModifierKey = strings.Join(ids, "+") + "|" + item.ModifierKey
+	item.ModifierNames = strings.Join(names, ", ")
+}
+
+// createComponents guarda los componentes de un combo recién creado con su cantidad, notas,
+// asiento e inicio de preparación
+func createComponents(tx *gorm.DB, parent OrderItem, components []OrderItem) error {
+	for _, component := range components {
+		component.ID = 0
+		component.OrderID = parent.OrderID
+		component.ParentItemID = &parent.ID
+		component.Quantity = parent.Quantity
+		component.Notes = parent.Notes
+		component.Seat = parent.Seat
+		component.AddedByID = parent.AddedByID
+		component.CookingStarted = parent.CookingStarted
+		if err := tx.Create(&component).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// syncComponents copia a los componentes la cantidad y las notas del combo
+func syncComponents(tx *gorm.DB, parent OrderItem) error {
+	if !parent.IsCombo {
+		return nil
+	}
+	return tx.Model(&OrderItem{}).Where("parent_item_id = ?", parent.ID).
+		Updates(map[string]interface{}{"quantity": parent.Quantity, "notes": parent.Notes}).Error
+}
+
+// syncComboReady marca el combo como listo cuando cocina terminó todos sus componentes. El tiempo
+// de cocción queda solo en los componentes para no contarlo dos veces en las estadísticas.
+func syncComboReady(tx *gorm.DB, parentID uint) error {
+	var components []OrderItem
+	if err := tx.Where("parent_item_id = ?", parentID).Find(&components).Error; err != nil {
+		return err
+	}
+	ready := true
+	var finished *time.Time
+	for _, component := range components {
+		if !component.IsReady {
+			ready, finished = false, nil
+			break
+		}
+		if component.CookingFinished != nil && (finished == nil || component.CookingFinished.After(*finished)) {
+			finished = component.CookingFinished
+		}
+	}
+	return tx.Model(&OrderItem{}).Where("id = ?", parentID).Updates(map[string]interface{}{
+		"is_ready": ready, "cooking_finished": finished, "delivered_at": finished,
+	}).Error
+}
+
+// comboTicketItems devuelve las líneas de comanda de lo agregado: el ítem, o los componentes
+// del combo con la cantidad agregada
+func comboTicketItems(tx *gorm.DB, added OrderItem) ([]OrderItem, error) {
+	if !added.IsCombo {
+		return []OrderItem{added}, nil
+	}
+	var components []OrderItem
+	if err := tx.Where("parent_item_id = ?", added.ID).Order("id ASC").Find(&components).Error; err != nil {
+		return nil, err
+	}
+	for i := range components {
+		components[i].Quantity = added.Quantity
+	}
+	return components, nil
+}
+
+// comboPickerSlot es un componente en el formulario de agregar producto
+type comboPickerSlot struct {
+	Slot    ComboSlot
+	Product *Product  // Producto fijo
+	Choices []Product // Productos a elegir
+}
+
+// comboPickerSlots arma los componentes del combo para elegirlos al agregarlo a una orden
+func comboPickerSlots(tx *gorm.DB, productID uint) []comboPickerSlot {
+	var picker []comboPickerSlot
+	for _, slot := range loadComboSlots(tx, productID) {
+		entry := comboPickerSlot{Slot: slot}
+		if slot.ProductID != nil {
+			var product Product
+			if tx.First(&product, *slot.ProductID).Error == nil {
+				entry.Product = &product
+			}
+		} else {
+			entry.Choices = comboChoices(tx, slot)
+		}
+		picker = append(picker, entry)
+	}
+	return picker
+}
+
+// comboSlotView es un componente en la administración del combo, con el nombre de su origen
+type comboSlotView struct {
+	ComboSlot
+	Source string
+}
+
+// productComboData arma los datos del partial de administración de componentes
+func productComboData(productID uint) (fiber.Map, error) {
+	var product Product
+	if err := db.First(&product, productID).Error; err != nil {
+		return nil, err
+	}
+
+	var slots []comboSlotView
+	for _, slot := range loadComboSlots(db, productID) {
+		view := comboSlotView{ComboSlot: slot, Source: "(eliminado)"}
+		if slot.ProductID != nil {
+			var component Product
+			if db.First(&component, *slot.ProductID).Error == nil {
+				view.Source = component.Name
+			}
+		} else if slot.CategoryID != nil {
+			var category Category
+			if db.First(&category, *slot.CategoryID).Error == nil {
+				view.Source = "A elegir de " + category.Name
+			}
+		}
+		slots = append(slots, view)
+	}
+
+	var products []Product
+	db.Where("id <> ?", productID).Where("id NOT IN (?)", db.Model(&ComboSlot{}).Select("combo_id")).
+		Order("name").Find(&products)
+
+	return fiber.Map{
+		"Product":    product,
+		"Slots":      slots,
+		"Categories": loadCategories(db, false),
+		"Products":   products,
+	}, nil
+}
+
+// renderProductCombo responde con la administración de componentes del combo
+func renderProductCombo(c *fiber.Ctx, productID uint) error {
+	data, err := productComboData(productID)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).SendString("Producto no encontrado")
+	}
+	return c.Render("partials/product_combo", data, "")
+}
+
+// GetProductCombo muestra los componentes de un producto para convertirlo en combo o editarlo
+func GetProductCombo(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).SendString("ID inválido")
+	}
+	return renderProductCombo(c, uint(id))
+}
+
+// CreateComboSlot agrega un componente al final del combo. source indica de dónde sale:
+// category:<id> para elegir entre los productos de una categoría o product:<id> para uno fijo.
+func CreateComboSlot(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return Error(c, "ID de producto inválido", fiber.StatusBadRequest)
+	}
+	var product Product
+	if result := db.First(&product, id); result.Error != nil {
+		return Error(c, "Producto no encontrado", fiber.StatusNotFound)
+	}
+
+	slot := ComboSlot{ComboID: product.ID, Name: strings.TrimSpace(c.FormValue("name"))}
+	kind, value, _ := strings.Cut(c.FormValue("source"), ":")
+	sourceID, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return Error(c, "Elige un producto o una categoría", fiber.StatusBadRequest)
+	}
+	switch kind {
+	case "category":
+		var category Category
+		if result := db.First(&category, sourceID); result.Error != nil {
+			return Error(c, "Categoría no encontrada", fiber.StatusNotFound)
+		}
+		slot.CategoryID = &category.ID
+		if slot.Name == "" {
+			slot.Name = category.Name
+		}
+	case "product":
+		var component Product
+		if result := db.First(&component, sourceID); result.Error != nil {
+			return Error(c, "Producto no encontrado", fiber.StatusNotFound)
+		}
+		if component.ID == product.ID || isCombo(db, component.ID) {
+			return Error(c, "Un combo no puede contener otro combo", fiber.StatusBadRequest)
+		}
+		slot.ProductID = &component.ID
+		if slot.Name == "" {
+			slot.Name = component.Name
+		}
+	default:
+		return Error(c, "Elige un producto o una categoría", fiber.StatusBadRequest)
+	}
+
+	// Un producto que ya es parte de un combo no puede pasar a ser combo
+	var used int64
+	db.Model(&ComboSlot{}).Where("product_id = ?", product.ID).Count(&used)
+	if used > 0 {
+		return Error(c, "El producto es parte de otro combo", fiber.StatusBadRequest)
+	}
+
+	db.Model(&ComboSlot{}).Where("combo_id = ?", product.ID).Select("COALESCE(MAX(position), 0) + 1").Scan(&slot.Position)
+	if err := db.Create(&slot).Error; err != nil {
+		return Error(c, "Error al agregar el componente", fiber.StatusInternalServerError)
+	}
+
+	c.Set("HX-Trigger", `{"showToast": "Componente agregado", "toastType": "success"}`)
+	return renderProductCombo(c, product.ID)
+}
+
+// DeleteComboSlot quita un componente del combo; sin componentes vuelve a ser un producto simple
+func DeleteComboSlot(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return Error(c, "ID de componente inválido", fiber.StatusBadRequest)
+	}
+	var slot ComboSlot
+	if result := db.First(&slot, id); result.Error != nil {
+		return Error(c, "Componente no encontrado", fiber.StatusNotFound)
+	}
+	if err := db.Delete(&slot).Error; err != nil {
+		return Error(c, "Error al quitar el componente", fiber.StatusInternalServerError)
+	}
+
+	c.Set("HX-Trigger", `{"showToast": "Componente quitado", "toastType": "success"}`)
+	return renderProductCombo(c, slot.ComboID)
+}
@@ -0,0 +1,1056 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// Identificación y versión del formato de exportación de la configuración
+const (
+	exportFormat  = "go-clean-menu-export"
+	exportVersion = 1
+)
+
+// Nombre del JSON dentro del zip exportado; las imágenes van con su ruta pública sin la "/" inicial
+const exportManifest = "restaurant.json"
+
+// Límites de un archivo a importar: su tamaño, y en un zip la cantidad de entradas y el total
+// descomprimido, para no agotar la memoria con un zip malicioso
+const (
+	maxImportSize         = 50 << 20
+	maxImportEntries      = 5000
+	maxImportUncompressed = 4 * maxImportSize
+)
+
+// Modos de importación: merge agrega lo que falta y conserva lo local ante un conflicto;
+// replace deja la configuración igual a la importada
+const (
+	ImportMerge   = "merge"
+	ImportReplace = "replace"
+)
+
+// configExport es la configuración portable del restaurante: menú, mesas, ajustes y personal.
+// No incluye órdenes, contraseñas ni datos propios del servidor (respaldos, envíos de correo).
+type configExport struct {
+	Format     string           `json:"format"`
+	Version    int              `json:"version"`
+	ExportedAt time.Time        `json:"exported_at"`
+	Settings   exportSettings   `json:"settings"`
+	Categories []exportCategory `json:"categories"`
+	Products   []exportProduct  `json:"products"`
+	Tables     []exportTable    `json:"tables"`
+	Users      []exportUser     `json:"users"`
+}
+
+type exportSettings struct {
+	RestaurantName    string  `json:"restaurant_name"`
+	Address           string  `json:"address"`
+	Phone             string  `json:"phone"`
+	Email             string  `json:"email"`
+	LogoPath          string  `json:"logo_path"`
+	DefaultPrinter    string  `json:"default_printer"`
+	KitchenPrinter    string  `json:"kitchen_printer"`
+	AutoPrint         bool    `json:"auto_print"`
+	DarkMode          bool    `json:"dark_mode"`
+	AutoRefresh       bool    `json:"auto_refresh"`
+	Language          string  `json:"language"`
+	TaxRate           float64 `json:"tax_rate"`
+	PricesIncludeTax  bool    `json:"prices_include_tax"`
+	ServiceChargeRate float64 `json:"service_charge_rate"`
+	CurrencySymbol    string  `json:"currency_symbol"`
+	SMTPHost          string  `json:"smtp_host"`
+	SMTPPort          int     `json:"smtp_port"`
+	SMTPUsername      string  `json:"smtp_username"`
+	SMTPFrom          string  `json:"smtp_from"`
+	BackupSchedule    string  `json:"backup_schedule"`
+	BackupKeepDaily   int     `json:"backup_keep_daily"`
+	BackupKeepWeekly  int     `json:"backup_keep_weekly"`
+}
+
+// exportCategory incluye la impresora de comandas de la categoría, si tiene (ver CategoryPrinter).
+// Las categorías se exportan en el orden del menú.
+type exportCategory struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Color       string `json:"color"`
+	Icon        string `json:"icon"`
+	Active      bool   `json:"active"`
+	Station     string `json:"station,omitempty"`
+	Printer     string `json:"printer,omitempty"`
+}
+
+type exportProduct struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Price       Money  `json:"price"`
+	Category    string `json:"category"`
+	IsAvailable bool   `json:"is_available"`
+	ImagePath   string `json:"image_path"`
+
+	Modifiers []exportModifierGroup `json:"modifiers,omitempty"`
+
+	// Componentes si es un combo
+	Combo []exportComboSlot `json:"combo,omitempty"`
+}
+
+type exportModifierGroup struct {
+	Name     string                 `json:"name"`
+	Required bool                   `json:"required"`
+	Multiple bool                   `json:"multiple"`
+	Options  []exportModifierOption `json:"options"`
+}
+
+type exportModifierOption struct {
+	Name       string `json:"name"`
+	PriceDelta Money  `json:"price_delta"`
+}
+
+// modifiersForExport convierte los grupos de modificadores de un producto
+func modifiersForExport(groups []ModifierGroup) []exportModifierGroup {
+	var exported []exportModifierGroup
+	for _, group := range groups {
+		g := exportModifierGroup{Name: group.Name, Required: group.Required, Multiple: group.Multiple, Options: []exportModifierOption{}}
+		for _, option := range group.Options {
+			g.Options = append(g.Options, exportModifierOption{Name: option.Name, PriceDelta: option.PriceDelta})
+		}
+		exported = append(exported, g)
+	}
+	return exported
+}
+
+// groupsFromExport arma los grupos importados como modelos, sin guardarlos
+func groupsFromExport(groups []exportModifierGroup) []ModifierGroup {
+	var models []ModifierGroup
+	for _, g := range groups {
+		group := ModifierGroup{Name: g.Name, Required: g.Required, Multiple: g.Multiple}
+		for _, o := range g.Options {
+			group.Options = append(group.Options, ModifierOption{Name: o.Name, PriceDelta: o.PriceDelta})
+		}
+		models = append(models, group)
+	}
+	return models
+}
+
+// replaceModifiers reemplaza los grupos de modificadores de un producto por los importados
+func replaceModifiers(tx *gorm.DB, productID uint, groups []exportModifierGroup) error {
+	if err := tx.Where("product_id = ?", productID).Delete(&ModifierGroup{}).Error; err != nil {
+		return err
+	}
+	for i, group := range groupsFromExport(groups) {
+		group.ProductID, group.Position = productID, i+1
+		for j := range group.Options {
+			group.Options[j].Position = j + 1
+		}
+		if err := tx.Create(&group).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// exportComboSlot es un componente de un combo: Product para uno fijo o Category para elegir
+type exportComboSlot struct {
+	Name     string `json:"name"`
+	Product  string `json:"product,omitempty"`
+	Category string `json:"category,omitempty"`
+}
+
+// comboForExport convierte los componentes de un combo, con los nombres de sus productos y categorías
+func comboForExport(tx *gorm.DB, productID uint) []exportComboSlot {
+	var exported []exportComboSlot
+	for _, slot := range loadComboSlots(tx, productID) {
+		s := exportComboSlot{Name: slot.Name}
+		if slot.ProductID != nil {
+			var product Product
+			if tx.First(&product, *slot.ProductID).Error != nil {
+				continue
+			}
+			s.Product = product.Name
+		} else if slot.CategoryID != nil {
+			var category Category
+			if tx.First(&category, *slot.CategoryID).Error != nil {
+				continue
+			}
+			s.Category = category.Name
+		}
+		exported = append(exported, s)
+	}
+	return exported
+}
+
+// replaceCombo reemplaza los componentes de un combo por los importados; los que nombran un
+// producto o categoría que no existe se omiten y se devuelven como notas
+func replaceCombo(tx *gorm.DB, product *Product, slots []exportComboSlot, products map[string]*Product, categories map[string]*Category) ([]string, error) {
+	if err := tx.Where("combo_id = ?", product.ID).Delete(&ComboSlot{}).Error; err != nil {
+		return nil, err
+	}
+	var notes []string
+	for i, s := range slots {
+		slot := ComboSlot{ComboID: product.ID, Name: s.Name, Position: i + 1}
+		if component := products[strings.ToLower(strings.TrimSpace(s.Product))]; s.Product != "" && component != nil && component.ID != product.ID {
+			slot.ProductID = &component.ID
+		} else if category := categories[strings.ToLower(strings.TrimSpace(s.Category))]; s.Product == "" && category != nil {
+			slot.CategoryID = &category.ID
+		} else {
+			notes = append(notes, "Se omite el componente "+s.Name+" de "+product.Name+": no se encontró su producto o categoría")
+			continue
+		}
+		if err := tx.Create(&slot).Error; err != nil {
+			return nil, err
+		}
+	}
+	return notes, nil
+}
+
+type exportTable struct {
+	Number   int `json:"number"`
+	Capacity int `json:"capacity"`
+}
+
+type exportUser struct {
+	Username string `json:"username"`
+	FullName string `json:"full_name"`
+	Email    string `json:"email"`
+	Role     string `json:"role"`
+	Active   bool   `json:"active"`
+}
+
+func settingsForExport(s Settings) exportSettings {
+	return exportSettings{
+		RestaurantName: s.RestaurantName, Address: s.Address, Phone: s.Phone, Email: s.Email, LogoPath: s.LogoPath,
+		DefaultPrinter: s.DefaultPrinter, KitchenPrinter: s.KitchenPrinter, AutoPrint: s.AutoPrint,
+		DarkMode: s.DarkMode, AutoRefresh: s.AutoRefresh, Language: s.Language,
+		TaxRate: s.TaxRate, PricesIncludeTax: s.PricesIncludeTax, ServiceChargeRate: s.ServiceChargeRate,
+		CurrencySymbol: s.CurrencySymbol,
+		SMTPHost:       s.SMTPHost, SMTPPort: s.SMTPPort, SMTPUsername: s.SMTPUsername, SMTPFrom: s.SMTPFrom,
+		BackupSchedule: s.BackupSchedule, BackupKeepDaily: s.BackupKeepDaily, BackupKeepWeekly: s.BackupKeepWeekly,
+	}
+}
+
+// applyTo copia los ajustes exportados en la configuración local
+func (e exportSettings) applyTo(s *Settings) {
+	s.RestaurantName, s.Address, s.Phone, s.Email, s.LogoPath = e.RestaurantName, e.Address, e.Phone, e.Email, e.LogoPath
+	s.DefaultPrinter, s.KitchenPrinter, s.AutoPrint = e.DefaultPrinter, e.KitchenPrinter, e.AutoPrint
+	s.DarkMode, s.AutoRefresh, s.Language = e.DarkMode, e.AutoRefresh, e.Language
+	s.TaxRate, s.PricesIncludeTax, s.ServiceChargeRate = e.TaxRate, e.PricesIncludeTax, e.ServiceChargeRate
+	s.CurrencySymbol = e.CurrencySymbol
+	s.SMTPHost, s.SMTPPort, s.SMTPUsername, s.SMTPFrom = e.SMTPHost, e.SMTPPort, e.SMTPUsername, e.SMTPFrom
+	s.BackupSchedule, s.BackupKeepDaily, s.BackupKeepWeekly = e.BackupSchedule, e.BackupKeepDaily, e.BackupKeepWeekly
+}
+
+// buildConfigExport lee la configuración actual
+func buildConfigExport() configExport {
+	export := configExport{
+		Format:     exportFormat,
+		Version:    exportVersion,
+		ExportedAt: time.Now(),
+		Settings:   settingsForExport(loadSettings(db)),
+		Categories: []exportCategory{},
+		Products:   []exportProduct{},
+		Tables:     []exportTable{},
+		Users:      []exportUser{},
+	}
+
+	var printers []CategoryPrinter
+	db.Find(&printers)
+	printerByCategory := map[uint]CategoryPrinter{}
+	for _, route := range printers {
+		printerByCategory[route.CategoryID] = route
+	}
+	for _, category := range loadCategories(db, false) {
+		route := printerByCategory[category.ID]
+		export.Categories = append(export.Categories, exportCategory{
+			Name: category.Name, Description: category.Description, Color: category.Color, Icon: category.Icon,
+			Active: category.Active, Station: route.Station, Printer: route.Printer,
+		})
+	}
+
+	var products []Product
+	db.Order("name").Find(&products)
+	for _, p := range products {
+		export.Products = append(export.Products, exportProduct{
+			Name: p.Name, Description: p.Description, Price: p.Price, Category: p.Category,
+			IsAvailable: p.IsAvailable, ImagePath: p.ImagePath,
+			Modifiers: modifiersForExport(loadModifierGroups(db, p.ID)),
+			Combo:     comboForExport(db, p.ID),
+		})
+	}
+
+	var tables []Table
+	db.Order("number").Find(&tables)
+	for _, t := range tables {
+		export.Tables = append(export.Tables, exportTable{Number: t.Number, Capacity: t.Capacity})
+	}
+
+	var users []User
+	db.Order("username").Find(&users)
+	for _, u := range users {
+		export.Users = append(export.Users, exportUser{
+			Username: u.Username, FullName: u.FullName, Email: u.Email, Role: u.Role, Active: u.Active,
+		})
+	}
+	return export
+}
+
+// exportImagePaths devuelve las imágenes subidas (logo y productos, con sus miniaturas) que usa la exportación
+func exportImagePaths(export configExport) []string {
+	seen := map[string]bool{}
+	var paths []string
+	add := func(publicPath string) {
+		if isUploadPath(publicPath) && !seen[publicPath] {
+			seen[publicPath] = true
+			paths = append(paths, publicPath)
+		}
+	}
+	add(export.Settings.LogoPath)
+	for _, p := range export.Products {
+		add(p.ImagePath)
+		add(thumbnailPath(p.ImagePath))
+	}
+	return paths
+}
+
+// isUploadPath indica si una ruta pública apunta a un archivo subido, sin salir de /static/uploads
+func isUploadPath(publicPath string) bool {
+	return publicPath != "" && path.Clean(publicPath) == publicPath && strings.HasPrefix(publicPath, uploadPublicPath)
+}
+
+// ExportConfiguration descarga un zip con la configuración en JSON y las imágenes subidas
+func ExportConfiguration(c *fiber.Ctx) error {
+	export := buildConfigExport()
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	manifest, err := zw.Create(exportManifest)
+	if err == nil {
+		encoder := json.NewEncoder(manifest)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(export)
+	}
+	for _, publicPath := range exportImagePaths(export) {
+		if err != nil {
+			break
+		}
+		data, readErr := os.ReadFile("." + publicPath)
+		if readErr != nil {
+			log.Printf("Exportación: no se incluye la imagen %s: %v", publicPath, readErr)
+			continue
+		}
+		var w io.Writer
+		if w, err = zw.Create(strings.TrimPrefix(publicPath, "/")); err == nil {
+			_, err = w.Write(data)
+		}
+	}
+	if closeErr := zw.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		log.Printf("Error al exportar la configuración: %v", err)
+		return Error(c, "Error al exportar la configuración", fiber.StatusInternalServerError)
+	}
+
+	filename := "configuracion-" + export.ExportedAt.Format("20060102-150405") + ".zip"
+	c.Set(fiber.HeaderContentType, "application/zip")
+	c.Set(fiber.HeaderContentDisposition, `attachment; filename="`+filename+`"`)
+	return c.Send(buf.Bytes())
+}
+
+// importedImage es una imagen del zip importado, ya validada, con los tamaños que se generan de ella
+type importedImage struct {
+	Data     []byte
+	Variants []imageVariant
+}
+
+// readConfigImport lee un archivo exportado: el zip (JSON e imágenes) o solo el JSON. Del zip
+// solo se leen el logo y las imágenes de los productos; las miniaturas se vuelven a generar.
+func readConfigImport(data []byte) (configExport, map[string]importedImage, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		export, err := parseConfigManifest(data)
+		return export, nil, err
+	}
+	if len(zr.File) > maxImportEntries {
+		return configExport{}, nil, fmt.Errorf("el zip tiene más de %d archivos", maxImportEntries)
+	}
+
+	remaining := int64(maxImportUncompressed)
+	var manifest []byte
+	for _, file := range zr.File {
+		if file.Name == exportManifest {
+			if manifest, err = readZipFile(file, maxImportSize, &remaining); err != nil {
+				return configExport{}, nil, fmt.Errorf("%s: %w", file.Name, err)
+			}
+			break
+		}
+	}
+	if manifest == nil {
+		return configExport{}, nil, errors.New("el zip no contiene " + exportManifest)
+	}
+	export, err := parseConfigManifest(manifest)
+	if err != nil {
+		return configExport{}, nil, err
+	}
+
+	variants := importImageVariants(export)
+	images := map[string]importedImage{}
+	for _, file := range zr.File {
+		publicPath := "/" + file.Name
+		if variants[publicPath] == nil || file.FileInfo().IsDir() {
+			continue
+		}
+		content, err := readZipFile(file, maxImageSize, &remaining)
+		if err == nil {
+			err = validateImportedImage(publicPath, content)
+		}
+		if err != nil {
+			return configExport{}, nil, fmt.Errorf("%s: %w", file.Name, err)
+		}
+		images[publicPath] = importedImage{Data: content, Variants: variants[publicPath]}
+	}
+	return export, images, nil
+}
+
+// parseConfigManifest lee el JSON de una exportación y valida su formato y sus impresoras
+func parseConfigManifest(manifest []byte) (configExport, error) {
+	var export configExport
+	if err := json.Unmarshal(manifest, &export); err != nil {
+		return configExport{}, fmt.Errorf("JSON inválido: %w", err)
+	}
+	if export.Format != exportFormat {
+		return configExport{}, errors.New("no es una exportación de configuración")
+	}
+	if export.Version < 1 || export.Version > exportVersion {
+		return configExport{}, fmt.Errorf("versión %d no admitida", export.Version)
+	}
+	// Las impresoras se validan como en la configuración: solo red o dispositivos de impresión
+	printers := []string{export.Settings.DefaultPrinter, export.Settings.KitchenPrinter}
+	for _, category := range export.Categories {
+		printers = append(printers, category.Printer)
+	}
+	for _, printer := range printers {
+		if err := validatePrinterTarget(printer); err != nil {
+			return configExport{}, err
+		}
+	}
+	return export, nil
+}
+
+// importImageVariants devuelve las imágenes que usa la exportación con los tamaños en que se
+// guardan, como al subirlas: el logo en un tamaño y los productos con miniatura
+func importImageVariants(export configExport) map[string][]imageVariant {
+	variants := map[string][]imageVariant{}
+	if isUploadPath(export.Settings.LogoPath) {
+		variants[export.Settings.LogoPath] = logoImageVariants
+	}
+	for _, p := range export.Products {
+		if isUploadPath(p.ImagePath) {
+			variants[p.ImagePath] = productImageVariants
+		}
+	}
+	return variants
+}
+
+// validateImportedImage comprueba que una imagen importada se pueda decodificar como las subidas
+// y que su extensión sea de un formato en que se vuelve a codificar
+func validateImportedImage(publicPath string, data []byte) error {
+	switch filepath.Ext(publicPath) {
+	case ".jpg", ".jpeg", ".png":
+	default:
+		return errors.New("la imagen debe tener extensión .jpg o .png")
+	}
+	_, err := decodeImage(data)
+	return err
+}
+
+// readZipFile lee una entrada del zip de hasta limit bytes y los descuenta de remaining, lo que
+// queda del total descomprimido permitido
+func readZipFile(file *zip.File, limit int64, remaining *int64) ([]byte, error) {
+	limit = min(limit, *remaining)
+	if file.UncompressedSize64 > uint64(limit) {
+		return nil, errors.New("el archivo descomprimido es demasiado grande")
+	}
+	rc, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(io.LimitReader(rc, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, errors.New("el archivo descomprimido es demasiado grande")
+	}
+	*remaining -= int64(len(data))
+	return data, nil
+}
+
+// importSection resume lo que la importación hace con un tipo de dato
+type importSection struct {
+	Name      string
+	Created   int
+	Updated   int
+	Unchanged int
+	Removed   int
+	Conflicts []string // Diferencias con lo local (en merge se conserva lo local)
+	Notes     []string
+}
+
+// importReport es el resultado (o la simulación) de una importación
+type importReport struct {
+	Mode     string
+	DryRun   bool
+	Sections []*importSection
+	Images   int
+}
+
+// errImportDryRun deshace la transacción de una simulación
+var errImportDryRun = errors.New("simulación")
+
+// importConfig aplica la configuración importada en tx según el modo
+func importConfig(tx *gorm.DB, export configExport, mode string, currentUserID *uint) (*importReport, error) {
+	report := &importReport{Mode: mode}
+	replace := mode == ImportReplace
+
+	// Ajustes
+	section := &importSection{Name: "Configuración"}
+	report.Sections = append(report.Sections, section)
+	settings := loadSettings(tx)
+	imported := settings
+	export.Settings.applyTo(&imported)
+	switch {
+	case settingsForExport(imported) == settingsForExport(settings):
+		section.Unchanged++
+	case replace:
+		if err := tx.Save(&imported).Error; err != nil {
+			return nil, err
+		}
+		settings = imported
+		section.Updated++
+	default:
+		section.Conflicts = append(section.Conflicts, "Los ajustes son distintos; se conservan los actuales")
+	}
+
+	// Categorías, por nombre; en replace quedan en el orden de la importación
+	section = &importSection{Name: "Categorías"}
+	report.Sections = append(report.Sections, section)
+	categorySection := section
+	categoryByName := map[string]*Category{}
+	for _, category := range loadCategories(tx, false) {
+		categoryByName[strings.ToLower(category.Name)] = &category
+	}
+	var printers []CategoryPrinter
+	tx.Find(&printers)
+	printerByCategory := map[uint]*CategoryPrinter{}
+	for i := range printers {
+		printerByCategory[printers[i].CategoryID] = &printers[i]
+	}
+	importedCategories := map[string]bool{}
+	for position, category := range export.Categories {
+		name := strings.TrimSpace(category.Name)
+		key := strings.ToLower(name)
+		if name == "" || importedCategories[key] {
+			continue
+		}
+		importedCategories[key] = true
+
+		existing := categoryByName[key]
+		if existing == nil {
+			created := Category{
+				Name: name, Description: category.Description, Color: category.Color, Icon: category.Icon,
+				Position: nextCategoryPosition(tx), Active: category.Active,
+			}
+			if replace {
+				created.Position = position + 1
+			}
+			if err := tx.Create(&created).Error; err != nil {
+				return nil, err
+			}
+			// Active tiene default:true: se guarda aparte para respetar las inactivas
+			if err := tx.Model(&created).Update("active", category.Active).Error; err != nil {
+				return nil, err
+			}
+			categoryByName[key] = &created
+			section.Created++
+		} else {
+			var diffs []string
+			if existing.Description != category.Description {
+				diffs = append(diffs, "descripción")
+			}
+			if existing.Color != category.Color || existing.Icon != category.Icon {
+				diffs = append(diffs, "color o ícono")
+			}
+			if existing.Active != category.Active {
+				diffs = append(diffs, "activa")
+			}
+			if replace && existing.Position != position+1 {
+				diffs = append(diffs, "orden")
+			}
+			switch {
+			case len(diffs) == 0:
+				section.Unchanged++
+			case replace:
+				existing.Description, existing.Color, existing.Icon = category.Description, category.Color, category.Icon
+				existing.Active, existing.Position = category.Active, position+1
+				if err := tx.Save(existing).Error; err != nil {
+					return nil, err
+				}
+				section.Updated++
+			default:
+				section.Conflicts = append(section.Conflicts, existing.Name+": "+strings.Join(diffs, ", "))
+			}
+		}
+
+		// Impresora de comandas de la categoría
+		if category.Printer == "" {
+			continue
+		}
+		categoryID := categoryByName[key].ID
+		route := printerByCategory[categoryID]
+		switch {
+		case route == nil:
+			route = &CategoryPrinter{CategoryID: categoryID, Station: category.Station, Printer: category.Printer}
+			if err := tx.Create(route).Error; err != nil {
+				return nil, err
+			}
+			printerByCategory[categoryID] = route
+		case route.Station == category.Station && route.Printer == category.Printer:
+		case replace:
+			if err := tx.Model(route).Updates(map[string]interface{}{"station": category.Station, "printer": category.Printer}).Error; err != nil {
+				return nil, err
+			}
+		default:
+			section.Conflicts = append(section.Conflicts, name+": impresora "+route.Printer+" → "+category.Printer)
+		}
+	}
+
+	// Productos, por nombre
+	section = &importSection{Name: "Productos"}
+	report.Sections = append(report.Sections, section)
+	var products []Product
+	tx.Find(&products)
+	byName := map[string]*Product{}
+	for i := range products {
+		byName[strings.ToLower(strings.TrimSpace(products[i].Name))] = &products[i]
+	}
+	importedProducts := map[string]bool{}
+	// Los combos se arman al final, cuando ya existen todos sus componentes
+	type pendingCombo struct {
+		product *Product
+		slots   []exportComboSlot
+	}
+	var combos []pendingCombo
+	for _, p := range export.Products {
+		name := strings.TrimSpace(p.Name)
+		key := strings.ToLower(name)
+		if name == "" || importedProducts[key] {
+			continue
+		}
+		importedProducts[key] = true
+		if p.Price < 0 || strings.TrimSpace(p.Category) == "" {
+			section.Notes = append(section.Notes, "Se omite "+name+": precio o categoría inválidos")
+			continue
+		}
+
+		// Las categorías que faltan en la lista de categorías se crean al final del menú
+		category := categoryByName[strings.ToLower(strings.TrimSpace(p.Category))]
+		if category == nil {
+			created, err := findOrCreateCategory(tx, strings.TrimSpace(p.Category))
+			if err != nil {
+				return nil, err
+			}
+			category = &created
+			categoryByName[strings.ToLower(created.Name)] = category
+			importedCategories[strings.ToLower(created.Name)] = true
+			categorySection.Created++
+		}
+
+		existing := byName[key]
+		if existing == nil {
+			product := Product{
+				Name: name, Description: p.Description, Price: p.Price, Category: category.Name, CategoryID: &category.ID,
+				IsAvailable: p.IsAvailable, ImagePath: p.ImagePath,
+			}
+			if err := tx.Create(&product).Error; err != nil {
+				return nil, err
+			}
+			if err := replaceModifiers(tx, product.ID, p.Modifiers); err != nil {
+				return nil, err
+			}
+			byName[key] = &product
+			if len(p.Combo) > 0 {
+				combos = append(combos, pendingCombo{&product, p.Combo})
+			}
+			section.Created++
+			continue
+		}
+		var diffs []string
+		if existing.Description != p.Description {
+			diffs = append(diffs, "descripción")
+		}
+		if existing.Price != p.Price {
+			diffs = append(diffs, "precio "+existing.Price.String()+" → "+p.Price.String())
+		}
+		if existing.Category != category.Name {
+			diffs = append(diffs, "categoría "+existing.Category+" → "+category.Name)
+		}
+		if existing.IsAvailable != p.IsAvailable {
+			diffs = append(diffs, "disponibilidad")
+		}
+		if existing.ImagePath != p.ImagePath {
+			diffs = append(diffs, "imagen")
+		}
+		currentModifiers, _ := json.Marshal(modifiersForExport(loadModifierGroups(tx, existing.ID)))
+		importedModifiers, _ := json.Marshal(modifiersForExport(groupsFromExport(p.Modifiers)))
+		modifiersChanged := !bytes.Equal(currentModifiers, importedModifiers)
+		if modifiersChanged {
+			diffs = append(diffs, "modificadores")
+		}
+		currentCombo, _ := json.Marshal(comboForExport(tx, existing.ID))
+		importedCombo, _ := json.Marshal(p.Combo)
+		comboChanged := !bytes.Equal(currentCombo, importedCombo)
+		if comboChanged {
+			diffs = append(diffs, "combo")
+		}
+		switch {
+		case len(diffs) == 0:
+			section.Unchanged++
+		case replace:
+			existing.Description, existing.Price = p.Description, p.Price
+			existing.Category, existing.CategoryID = category.Name, &category.ID
+			existing.IsAvailable, existing.ImagePath = p.IsAvailable, p.ImagePath
+			if err := tx.Save(existing).Error; err != nil {
+				return nil, err
+			}
+			if modifiersChanged {
+				if err := replaceModifiers(tx, existing.ID, p.Modifiers); err != nil {
+					return nil, err
+				}
+			}
+			if comboChanged {
+				combos = append(combos, pendingCombo{existing, p.Combo})
+			}
+			section.Updated++
+		default:
+			section.Conflicts = append(section.Conflicts, existing.Name+": "+strings.Join(diffs, ", "))
+		}
+	}
+	for _, combo := range combos {
+		notes, err := replaceCombo(tx, combo.product, combo.slots, byName, categoryByName)
+		if err != nil {
+			return nil, err
+		}
+		section.Notes = append(section.Notes, notes...)
+	}
+	if replace {
+		// Los productos que no vienen en la importación se eliminan, o se desactivan si ya se vendieron
+		for key, product := range byName {
+			if importedProducts[key] {
+				continue
+			}
+			var used int64
+			tx.Model(&OrderItem{}).Where("product_id = ?", product.ID).Count(&used)
+			if used > 0 {
+				if product.IsAvailable {
+					if err := tx.Model(product).Update("is_available", false).Error; err != nil {
+						return nil, err
+					}
+					section.Notes = append(section.Notes, product.Name+" se desactiva porque tiene ventas")
+					section.Removed++
+				}
+				continue
+			}
+			if err := tx.Delete(product).Error; err != nil {
+				return nil, err
+			}
+			section.Removed++
+		}
+
+		// Las categorías que no vienen se eliminan si ya no tienen productos
+		for key, category := range categoryByName {
+			if importedCategories[key] {
+				continue
+			}
+			var count int64
+			tx.Model(&Product{}).Where("category_id = ?", category.ID).Count(&count)
+			if count > 0 {
+				categorySection.Notes = append(categorySection.Notes, category.Name+" se conserva porque tiene productos")
+				continue
+			}
+			if err := tx.Where("category_id = ?", category.ID).Delete(&CategoryPrinter{}).Error; err != nil {
+				return nil, err
+			}
+			if err := tx.Delete(category).Error; err != nil {
+				return nil, err
+			}
+			categorySection.Removed++
+		}
+	}
+
+	// Mesas, por número
+	section = &importSection{Name: "Mesas"}
+	report.Sections = append(report.Sections, section)
+	var tables []Table
+	tx.Find(&tables)
+	byNumber := map[int]*Table{}
+	for i := range tables {
+		byNumber[tables[i].Number] = &tables[i]
+	}
+	importedTables := map[int]bool{}
+	for _, t := range export.Tables {
+		if t.Number <= 0 || importedTables[t.Number] {
+			continue
+		}
+		importedTables[t.Number] = true
+		capacity := t.Capacity
+		if capacity <= 0 {
+			capacity = 4
+		}
+		existing := byNumber[t.Number]
+		switch {
+		case existing == nil:
+			if err := tx.Create(&Table{Number: t.Number, Capacity: capacity}).Error; err != nil {
+				return nil, err
+			}
+			section.Created++
+		case existing.Capacity == capacity:
+			section.Unchanged++
+		case replace:
+			if err := tx.Model(existing).Update("capacity", capacity).Error; err != nil {
+				return nil, err
+			}
+			section.Updated++
+		default:
+			section.Conflicts = append(section.Conflicts, fmt.Sprintf("Mesa %d: capacidad %d → %d", t.Number, existing.Capacity, capacity))
+		}
+	}
+	if replace {
+		for number, table := range byNumber {
+			if importedTables[number] {
+				continue
+			}
+			if table.Occupied {
+				section.Notes = append(section.Notes, fmt.Sprintf("La mesa %d se conserva porque está ocupada", number))
+				continue
+			}
+			if err := tx.Delete(table).Error; err != nil {
+				return nil, err
+			}
+			section.Removed++
+		}
+	}
+	// La cantidad de mesas de la configuración debe coincidir con las mesas (ver SettingsHandler)
+	var tableCount int64
+	tx.Model(&Table{}).Count(&tableCount)
+	if err := tx.Model(&Settings{}).Where("id = ?", settings.ID).Update("table_count", tableCount).Error; err != nil {
+		return nil, err
+	}
+
+	// Personal, por nombre de usuario. Las cuentas nuevas quedan inactivas hasta asignarles contraseña.
+	section = &importSection{Name: "Personal"}
+	report.Sections = append(report.Sections, section)
+	var users []User
+	tx.Unscoped().Find(&users)
+	byUsername := map[string]*User{}
+	for i := range users {
+		byUsername[strings.ToLower(users[i].Username)] = &users[i]
+	}
+	importedUsers := map[string]bool{}
+	for _, u := range export.Users {
+		username := strings.TrimSpace(u.Username)
+		key := strings.ToLower(username)
+		if username == "" || importedUsers[key] {
+			continue
+		}
+		importedUsers[key] = true
+		if u.Role != RoleAdmin && u.Role != RoleWaiter && u.Role != RoleCook {
+			section.Notes = append(section.Notes, "Se omite "+username+": rol desconocido")
+			continue
+		}
+
+		existing := byUsername[key]
+		if existing == nil {
+			password, err := randomPasswordHash()
+			if err != nil {
+				return nil, err
+			}
+			user := User{Username: username, FullName: u.FullName, Email: u.Email, Role: u.Role, Password: password}
+			if err := tx.Create(&user).Error; err != nil {
+				return nil, err
+			}
+			// Active tiene default:true: se desactiva después de crearla
+			if err := tx.Model(&user).Update("active", false).Error; err != nil {
+				return nil, err
+			}
+			section.Created++
+			section.Notes = append(section.Notes, username+" se crea inactivo: asígnale una contraseña en Usuarios")
+			continue
+		}
+		if existing.DeletedAt.Valid {
+			section.Conflicts = append(section.Conflicts, existing.Username+": existe un usuario eliminado con ese nombre")
+			continue
+		}
+		var diffs []string
+		if existing.FullName != u.FullName {
+			diffs = append(diffs, "nombre")
+		}
+		if existing.Email != u.Email {
+			diffs = append(diffs, "correo")
+		}
+		if existing.Role != u.Role {
+			diffs = append(diffs, "rol "+existing.Role+" → "+u.Role)
+		}
+		if existing.Active != u.Active {
+			diffs = append(diffs, "activo")
+		}
+		isCurrent := currentUserID != nil && *currentUserID == existing.ID
+		switch {
+		case len(diffs) == 0:
+			section.Unchanged++
+		case replace && !isCurrent:
+			if err := tx.Model(existing).Updates(map[string]interface{}{
+				"full_name": u.FullName, "email": u.Email, "role": u.Role, "active": u.Active,
+			}).Error; err != nil {
+				return nil, err
+			}
+			section.Updated++
+		case replace:
+			section.Notes = append(section.Notes, existing.Username+" es tu usuario: no se modifica")
+		default:
+			section.Conflicts = append(section.Conflicts, existing.Username+": "+strings.Join(diffs, ", "))
+		}
+	}
+	if replace {
+		// El personal que no viene en la importación se desactiva (nunca se borra: firma eventos y pagos)
+		for key, user := range byUsername {
+			if importedUsers[key] || user.DeletedAt.Valid || !user.Active {
+				continue
+			}
+			if currentUserID != nil && *currentUserID == user.ID {
+				continue
+			}
+			if err := tx.Model(user).Update("active", false).Error; err != nil {
+				return nil, err
+			}
+			section.Removed++
+			section.Notes = append(section.Notes, user.Username+" se desactiva")
+		}
+	}
+
+	for _, s := range report.Sections {
+		sort.Strings(s.Conflicts)
+		sort.Strings(s.Notes)
+	}
+	return report, nil
+}
+
+// randomPasswordHash genera el hash de una contraseña aleatoria que nadie conoce
+func randomPasswordHash() (string, error) {
+	secret := make([]byte, 24)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return hashPassword(hex.EncodeToString(secret))
+}
+
+// saveImportedImages vuelve a codificar y guarda las imágenes importadas que no existen
+// localmente, en los mismos tamaños que las subidas
+func saveImportedImages(images map[string]importedImage) int {
+	saved := 0
+	for publicPath, imported := range images {
+		target := "." + publicPath
+		if _, err := os.Stat(target); err == nil {
+			continue
+		}
+		img, err := decodeImage(imported.Data)
+		if err != nil {
+			log.Printf("Importación: imagen %s no válida: %v", publicPath, err)
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(target), os.ModePerm); err != nil {
+			log.Printf("Importación: no se pudo crear %s: %v", filepath.Dir(target), err)
+			continue
+		}
+		ext := filepath.Ext(target)
+		if err := writeImageVariants(img, strings.TrimSuffix(target, ext), strings.TrimPrefix(ext, "."), imported.Variants); err != nil {
+			log.Printf("Importación: no se pudo guardar %s: %v", publicPath, err)
+			continue
+		}
+		saved++
+	}
+	return saved
+}
+
+// ImportConfiguration importa una configuración exportada. Con dry_run muestra lo que haría sin
+// guardar nada; mode elige entre merge (por defecto) y replace.
+func ImportConfiguration(c *fiber.Ctx) error {
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		return Error(c, "Selecciona el archivo a importar", fiber.StatusBadRequest)
+	}
+	if fileHeader.Size > maxImportSize {
+		return Error(c, "El archivo es demasiado grande", fiber.StatusRequestEntityTooLarge)
+	}
+	file, err := fileHeader.Open()
+	if err != nil {
+		return Error(c, "No se pudo leer el archivo", fiber.StatusBadRequest)
+	}
+	data, err := io.ReadAll(io.LimitReader(file, maxImportSize))
+	file.Close()
+	if err != nil {
+		return Error(c, "No se pudo leer el archivo", fiber.StatusBadRequest)
+	}
+
+	export, images, err := readConfigImport(data)
+	if err != nil {
+		return Error(c, "Archivo inválido: "+err.Error(), fiber.StatusUnprocessableEntity)
+	}
+
+	mode := c.FormValue("mode", ImportMerge)
+	if mode != ImportMerge && mode != ImportReplace {
+		return Error(c, "Modo de importación inválido", fiber.StatusBadRequest)
+	}
+	dryRun := c.FormValue("dry_run") == "on"
+
+	var report *importReport
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if report, err = importConfig(tx, export, mode, currentUserID(c)); err != nil {
+			return err
+		}
+		if dryRun {
+			return errImportDryRun
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errImportDryRun) {
+		log.Printf("Error al importar la configuración: %v", err)
+		return Error(c, "Error al importar la configuración", fiber.StatusInternalServerError)
+	}
+	report.DryRun = dryRun
+
+	if dryRun {
+		c.Set("HX-Trigger", `{"showToast": "Simulación lista: revisa los cambios antes de importar", "toastType": "success"}`)
+	} else {
+		report.Images = saveImportedImages(images)
+		log.Printf("Configuración importada (modo %s)", mode)
+		c.Set("HX-Trigger", `{"showToast": "Configuración importada", "toastType": "success"}`)
+	}
+	return c.Render("partials/import_result", fiber.Map{"Report": report}, "")
+}
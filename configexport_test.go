@@ -0,0 +1,167 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"strings"
+	"testing"
+)
+
+// testPNG codifica una imagen de width × height en PNG
+func testPNG(t *testing.T, width, height int) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		img.Set(x, x%height, color.RGBA{R: 200, A: 255})
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// testImportZip arma un zip de exportación con el manifiesto y los archivos dados
+func testImportZip(t *testing.T, export configExport, files map[string][]byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	manifest, err := json.Marshal(export)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files[exportManifest] = manifest
+	for name, data := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(data)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func testExport() configExport {
+	return configExport{
+		Format:   exportFormat,
+		Version:  exportVersion,
+		Settings: exportSettings{RestaurantName: "Cafetería", LogoPath: "/static/uploads/logo-1.png"},
+		Products: []exportProduct{{Name: "Café", ImagePath: "/static/uploads/product-1.jpg"}},
+	}
+}
+
+func TestReadConfigImportImages(t *testing.T) {
+	data := testImportZip(t, testExport(), map[string][]byte{
+		"static/uploads/logo-1.png":          testPNG(t, 600, 300),
+		"static/uploads/product-1.jpg":       testPNG(t, 1600, 900),
+		"static/uploads/product-1-thumb.jpg": []byte("no es una imagen"),
+		"static/uploads/otro.png":            []byte("no es una imagen"),
+	})
+	export, images, err := readConfigImport(data)
+	if err != nil {
+		t.Fatalf("readConfigImport: %v", err)
+	}
+	if export.Settings.RestaurantName != "Cafetería" {
+		t.Errorf("nombre = %q", export.Settings.RestaurantName)
+	}
+	// Las miniaturas y los archivos que no usa la exportación no se leen
+	if len(images) != 2 {
+		t.Fatalf("imágenes = %d, se esperaban 2", len(images))
+	}
+	if got := images["/static/uploads/product-1.jpg"].Variants; len(got) != len(productImageVariants) {
+		t.Errorf("la imagen del producto se guarda en %d tamaños", len(got))
+	}
+
+	// Se guardan vueltas a codificar, con miniatura para los productos
+	t.Chdir(t.TempDir())
+	if saved := saveImportedImages(images); saved != 2 {
+		t.Fatalf("imágenes guardadas = %d, se esperaban 2", saved)
+	}
+	for path, want := range map[string]string{
+		"static/uploads/logo-1.png":          "png",
+		"static/uploads/product-1.jpg":       "jpeg",
+		"static/uploads/product-1-thumb.jpg": "jpeg",
+	} {
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatalf("falta %s: %v", path, err)
+		}
+		config, format, err := image.DecodeConfig(f)
+		f.Close()
+		if err != nil || format != want {
+			t.Errorf("%s: formato %q (%v), se esperaba %s", path, format, err, want)
+		}
+		if config.Width > 1200 {
+			t.Errorf("%s: ancho %d, no se redujo", path, config.Width)
+		}
+	}
+}
+
+func TestReadConfigImportRejectsInvalidImages(t *testing.T) {
+	tests := map[string][]byte{
+		"no es una imagen":             []byte("<script>alert(1)</script>"),
+		"html con extensión de imagen": []byte("<html><body>hola</body></html>"),
+		"imagen truncada":              testPNG(t, 50, 50)[:60],
+	}
+	for name, content := range tests {
+		data := testImportZip(t, testExport(), map[string][]byte{"static/uploads/product-1.jpg": content})
+		if _, _, err := readConfigImport(data); err == nil {
+			t.Errorf("%s: se esperaba un error", name)
+		}
+	}
+
+	export := testExport()
+	export.Products[0].ImagePath = "/static/uploads/product-1.svg"
+	data := testImportZip(t, export, map[string][]byte{"static/uploads/product-1.svg": testPNG(t, 10, 10)})
+	if _, _, err := readConfigImport(data); err == nil {
+		t.Error("extensión .svg: se esperaba un error")
+	}
+}
+
+func TestReadConfigImportLimits(t *testing.T) {
+	files := map[string][]byte{}
+	for i := 0; i < maxImportEntries; i++ {
+		files[fmt.Sprintf("static/uploads/extra-%d.png", i)] = nil
+	}
+	if _, _, err := readConfigImport(testImportZip(t, testExport(), files)); err == nil || !strings.Contains(err.Error(), "archivos") {
+		t.Errorf("zip con demasiadas entradas: error %v", err)
+	}
+
+	// Una imagen que se descomprime por encima del límite no se lee entera
+	big := bytes.Repeat([]byte{0}, maxImageSize+1)
+	data := testImportZip(t, testExport(), map[string][]byte{"static/uploads/product-1.jpg": big})
+	if len(data) > maxImageSize/100 {
+		t.Fatalf("el zip de prueba debería comprimir bien: %d bytes", len(data))
+	}
+	if _, _, err := readConfigImport(data); err == nil || !strings.Contains(err.Error(), "demasiado grande") {
+		t.Errorf("imagen demasiado grande: error %v", err)
+	}
+
+	// El total descomprimido se descuenta entre entradas
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var manifest *zip.File
+	for _, file := range zr.File {
+		if file.Name == exportManifest {
+			manifest = file
+		}
+	}
+	remaining := int64(manifest.UncompressedSize64)
+	if _, err := readZipFile(manifest, maxImportSize, &remaining); err != nil || remaining != 0 {
+		t.Fatalf("readZipFile: error %v, quedan %d", err, remaining)
+	}
+	if _, err := readZipFile(manifest, maxImportSize, &remaining); err == nil {
+		t.Error("se esperaba un error al superar el total descomprimido")
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// Tipos de eventos del historial de una orden
+const (
+	EventStatus       = "status"
+	EventItemAdded    = "item_added"
+	EventItemRemoved  = "item_removed"
+	EventItemQuantity = "item_quantity"
+	EventTransfer     = "table_transfer"
+	EventMerge        = "order_merge"
+)
+
+// orderEventsAppendOnlySQL impide modificar o borrar eventos ya registrados
+const orderEventsAppendOnlySQL = `
+CREATE OR REPLACE FUNCTION order_events_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'order_events es de solo inserción';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS order_events_append_only ON order_events;
+CREATE TRIGGER order_events_append_only
+    BEFORE UPDATE OR DELETE ON order_events
+    FOR EACH ROW EXECUTE FUNCTION order_events_append_only();
+`
+
+// recordStatusChange registra una transición de estado de la orden
+func recordStatusChange(tx *gorm.DB, orderID uint, from, to string, actorID *uint, reason string) error {
+	return tx.Create(&OrderEvent{
+		OrderID:    orderID,
+		Type:       EventStatus,
+		FromStatus: from,
+		ToStatus:   to,
+		ActorID:    actorID,
+		Reason:     reason,
+	}).Error
+}
+
+// recordItemEvent registra que un ítem fue agregado, eliminado o cambió de cantidad
+func recordItemEvent(tx *gorm.DB, eventType string, item OrderItem, oldQty, newQty int, actorID *uint, reason string) error {
+	itemID := item.ID
+	productID := item.ProductID
+	return tx.Create(&OrderEvent{
+		OrderID:     item.OrderID,
+		Type:        eventType,
+		ItemID:      &itemID,
+		ProductID:   &productID,
+		ProductName: item.ProductName,
+		OldQuantity: oldQty,
+		NewQuantity: newQty,
+		ActorID:     actorID,
+		Reason:      reason,
+	}).Error
+}
+
+// recordTableTransfer registra que la orden pasó de una mesa a otra
+func recordTableTransfer(tx *gorm.DB, orderID uint, fromTable, toTable int, actorID *uint) error {
+	return tx.Create(&OrderEvent{
+		OrderID:   orderID,
+		Type:      EventTransfer,
+		FromTable: fromTable,
+		ToTable:   toTable,
+		ActorID:   actorID,
+	}).Error
+}
+
+// recordOrderMerge registra en ambas órdenes que source se unió a target
+func recordOrderMerge(tx *gorm.DB, target, source Order, actorID *uint) error {
+	targetID, sourceID := target.ID, source.ID
+	events := []OrderEvent{
+		{OrderID: target.ID, Type: EventMerge, RelatedOrderID: &sourceID, FromTable: source.TableNum, ToTable: target.TableNum, ActorID: actorID},
+		{OrderID: source.ID, Type: EventMerge, RelatedOrderID: &targetID, FromTable: source.TableNum, ToTable: target.TableNum, ActorID: actorID},
+	}
+	return tx.Create(&events).Error
+}
+
+// logEventError deja constancia en el log cuando no se pudo registrar un evento
+func logEventError(orderID uint, err error) {
+	if err != nil {
+		log.Printf("Error al registrar evento de la orden #%d: %v", orderID, err)
+	}
+}
+
+// actionReason obtiene el motivo indicado por el usuario (campo "reason" o prompt de HTMX)
+func actionReason(c *fiber.Ctx) string {
+	if reason := strings.TrimSpace(c.FormValue("reason")); reason != "" {
+		return reason
+	}
+	return strings.TrimSpace(c.Get("HX-Prompt"))
+}
+
+// getOrderEvents devuelve el historial de eventos de una orden en orden cronológico
+func getOrderEvents(orderID uint) []OrderEvent {
+	var events []OrderEvent
+	db.Where("order_id = ?", orderID).
+		Order("created_at asc, id asc").
+		Preload("Actor", unscopedUsers).
+		Find(&events)
+	return events
+}
+
+// getOrdersEvents devuelve en una sola consulta el historial de varias órdenes, agrupado por orden
+func getOrdersEvents(orderIDs []uint) map[uint][]OrderEvent {
+	byOrder := make(map[uint][]OrderEvent, len(orderIDs))
+	if len(orderIDs) == 0 {
+		return byOrder
+	}
+	var events []OrderEvent
+	db.Where("order_id IN ?", orderIDs).
+		Order("order_id asc, created_at asc, id asc").
+		Preload("Actor", unscopedUsers).
+		Find(&events)
+	for _, event := range events {
+		byOrder[event.OrderID] = append(byOrder[event.OrderID], event)
+	}
+	return byOrder
+}
+
+// GetOrderTimeline muestra la línea de tiempo de eventos de una orden
+func GetOrderTimeline(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).SendString("ID inválido")
+	}
+
+	var order Order
+	if result := db.First(&order, id); result.Error != nil {
+		return c.Status(fiber.StatusNotFound).SendString("Orden no encontrada")
+	}
+
+	return c.Render("partials/order_timeline", fiber.Map{
+		"OrderID": order.ID,
+		"Events":  getOrderEvents(order.ID),
+	}, "")
+}
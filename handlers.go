@@ -15,7 +15,7 @@ func HomeHandler(c *fiber.Ctx) error {
 
 func getRecentOrders(limit int) []fiber.Map {
 	var orders []Order
-	db.Preload("Items").Order("created_at desc").Limit(limit).Find(&orders)
+	db.Preload("Items", orderLines).Order("created_at desc").Limit(limit).Find(&orders)
 
 	result := make([]fiber.Map, 0)
 	for _, order := range orders {
@@ -47,6 +47,7 @@ func DashboardHandler(c *fiber.Ctx) error {
 		"PopularProducts": popularProducts,
 		"ChartLabels":     chartLabels,
 		"ChartValues":     chartValues,
+		"LowStock":        lowStockIngredients(),
 	})
 }
 
@@ -57,10 +58,10 @@ func getDashboardStats() fiber.Map {
 
 	// Calcular ventas del día
 	today := time.Now().Truncate(24 * time.Hour)
-	var todaySales float64
+	var todaySales Money
 	db.Model(&Order{}).
 		Where("status = ? AND created_at >= ?", "completed", today).
-		Select("COALESCE(SUM(total), 0)").
+		Select("COALESCE(SUM(total - refunded), 0)::bigint").
 		Scan(&todaySales)
 
 	// Categoría más popular
@@ -69,12 +70,11 @@ func getDashboardStats() fiber.Map {
 		Count    int64
 	}
 
-	db.Raw(`SELECT p.category, COUNT(oi.id) as count 
-            FROM products p 
-            JOIN order_items oi ON p.id = oi.product_id 
+	db.Raw(`SELECT oi.category, COUNT(oi.id) as count 
+            FROM order_items oi 
             JOIN orders o ON oi.order_id = o.id
             WHERE o.created_at >= ?
-            GROUP BY p.category 
+            GROUP BY oi.category 
             ORDER BY count DESC LIMIT 1`, time.Now().AddDate(0, 0, -30)).
 		Scan(&topCategory)
 
@@ -105,18 +105,17 @@ func getPopularProducts(limit int) []fiber.Map {
 		ID         uint
 		Name       string
 		OrderCount int64
-		Revenue    float64
+		Revenue    Money
 	}
 
 	var popularProducts []PopularProduct
-	db.Raw(`SELECT p.id, p.name, 
+	db.Raw(`SELECT oi.product_id as id, oi.product_name as name, 
                COUNT(oi.id) as order_count,
-               SUM(p.price * oi.quantity) as revenue
-            FROM products p 
-            JOIN order_items oi ON p.id = oi.product_id 
+               SUM(oi.unit_price * oi.quantity)::bigint as revenue
+            FROM order_items oi 
             JOIN orders o ON oi.order_id = o.id
             WHERE o.status = 'completed'
-            GROUP BY p.id, p.name 
+            GROUP BY oi.product_id, oi.product_name 
             ORDER BY order_count DESC, revenue DESC
             LIMIT ?`, limit).
 		Scan(&popularProducts)
@@ -134,19 +133,19 @@ func getPopularProducts(limit int) []fiber.Map {
 	return result
 }
 
-func getSalesChartData(days int) ([]string, []float64) {
+func getSalesChartData(days int) ([]string, []Money) {
 	labels := make([]string, days)
-	values := make([]float64, days)
+	values := make([]Money, days)
 
 	for i := days - 1; i >= 0; i-- {
 		date := time.Now().AddDate(0, 0, -i)
 		dayStart := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.Local)
 		dayEnd := dayStart.AddDate(0, 0, 1)
 
-		var dayTotal float64
+		var dayTotal Money
 		db.Model(&Order{}).
 			Where("status = ? AND created_at BETWEEN ? AND ?", "completed", dayStart, dayEnd).
-			Select("COALESCE(SUM(total), 0)").
+			Select("COALESCE(SUM(total - refunded), 0)::bigint").
 			Scan(&dayTotal)
 
 		labels[days-1-i] = dayStart.Format("02/01")
@@ -176,7 +175,7 @@ func GetProductsByCategory(c *fiber.Ctx) error {
 func GetOrderMetrics(c *fiber.Ctx) error {
 	// Órdenes completadas en el último mes
 	var orders []Order
-	db.Preload("Items").Where("status = ? AND completed_at IS NOT NULL", "completed").Order("completed_at desc").Limit(50).Find(&orders)
+	db.Preload("Items", orderLines).Where("status = ? AND completed_at IS NOT NULL", "completed").Order("completed_at desc").Limit(50).Find(&orders)
 
 	// Métricas globales
 	totalOrders := len(orders)
@@ -197,8 +196,8 @@ func GetOrderMetrics(c *fiber.Ctx) error {
 			sumTotal += o.CompletedAt.Sub(o.CreatedAt).Seconds()
 		}
 		for _, item := range o.Items {
-			if item.CookingTime > 0 && item.Product.Name != "" {
-				productTimes[item.Product.Name] = append(productTimes[item.Product.Name], float64(item.CookingTime))
+			if item.CookingTime > 0 && item.ProductName != "" {
+				productTimes[item.ProductName] = append(productTimes[item.ProductName], float64(item.CookingTime))
 			}
 		}
 	}
@@ -233,6 +232,31 @@ func GetOrderMetrics(c *fiber.Ctx) error {
 		}{name, sum / float64(len(times)), len(times)})
 	}
 
+	// Desglose por mesero (quién abrió la orden)
+	type WaiterStats struct {
+		UserID     uint
+		Name       string
+		OrderCount int
+		Revenue    Money
+		AvgTotal   float64 // tiempo promedio de apertura a cierre en segundos
+	}
+
+	var waiterStats []WaiterStats
+	db.Raw(`
+        SELECT u.id as user_id,
+               COALESCE(NULLIF(u.full_name, ''), u.username) as name,
+               COUNT(o.id) as order_count,
+               COALESCE(SUM(o.total - o.refunded), 0)::bigint as revenue,
+               COALESCE(AVG(EXTRACT(EPOCH FROM (o.completed_at - o.created_at))), 0) as avg_total
+        FROM orders o
+        JOIN users u ON u.id = o.created_by_id
+        WHERE o.status = 'completed'
+        AND o.completed_at IS NOT NULL
+        AND o.deleted_at IS NULL
+        GROUP BY u.id, name
+        ORDER BY revenue DESC
+    `).Scan(&waiterStats)
+
 	return c.Render("metrics", fiber.Map{
 		"Title":           "Métricas",
 		"ActivePage":      "metrics",
@@ -241,6 +265,7 @@ func GetOrderMetrics(c *fiber.Ctx) error {
 		"AvgDelivery":     avgDelivery,
 		"AvgTotal":        avgTotal,
 		"ProductAverages": productAverages,
+		"WaiterStats":     waiterStats,
 		"Orders":          orders,
 	})
 }
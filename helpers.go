@@ -1,6 +1,11 @@
 package main
 
-import "github.com/gofiber/fiber/v2"
+import (
+	"encoding/json"
+	"log"
+
+	"github.com/gofiber/fiber/v2"
+)
 
 // RenderPage renders a template with the main layout
 func RenderPage(c *fiber.Ctx, template string, data fiber.Map) error {
@@ -16,20 +21,29 @@ func RenderPartial(c *fiber.Ctx, template string, data fiber.Map) error {
 	return c.Render(template, data, "")
 }
 
+// setTrigger sends the htmx events in the HX-Trigger header, encoded as JSON so that
+// user-entered text (names, error messages) can contain quotes or backslashes
+func setTrigger(c *fiber.Ctx, events fiber.Map) {
+	trigger, err := json.Marshal(events)
+	if err != nil {
+		log.Printf("Error al codificar HX-Trigger: %v", err)
+		return
+	}
+	c.Set("HX-Trigger", string(trigger))
+}
+
 // Success sends success response with toast and optional modal closing
 func Success(c *fiber.Ctx, message string, closeModal bool) error {
-	trigger := `{"showToast": "` + message + `"`
+	events := fiber.Map{"showToast": message}
 	if closeModal {
-		trigger += `, "closeModal": true`
+		events["closeModal"] = true
 	}
-	trigger += `}`
-
-	c.Set("HX-Trigger", trigger)
+	setTrigger(c, events)
 	return nil
 }
 
 // Error sends error response with toast
 func Error(c *fiber.Ctx, message string, statusCode int) error {
-	c.Set("HX-Trigger", `{"showToast": "`+message+`"}`)
+	setTrigger(c, fiber.Map{"showToast": message})
 	return c.Status(statusCode).SendString(message)
 }
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestTriggerEscapesUserText(t *testing.T) {
+	name := `Proveedor "El Sol" \ Norte`
+	app := fiber.New()
+	app.Get("/success", func(c *fiber.Ctx) error {
+		setTrigger(c, fiber.Map{"showToast": "Proveedor '" + name + "' creado", "closeModal": true})
+		return nil
+	})
+	app.Get("/error", func(c *fiber.Ctx) error {
+		return Error(c, name+" no está disponible", fiber.StatusBadRequest)
+	})
+
+	for path, want := range map[string]string{
+		"/success": "Proveedor '" + name + "' creado",
+		"/error":   name + " no está disponible",
+	} {
+		resp, err := app.Test(httptest.NewRequest(fiber.MethodGet, path, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var events map[string]interface{}
+		if err := json.Unmarshal([]byte(resp.Header.Get("HX-Trigger")), &events); err != nil {
+			t.Fatalf("%s: HX-Trigger no es JSON válido: %v", path, err)
+		}
+		if events["showToast"] != want {
+			t.Errorf("%s: showToast = %q, se esperaba %q", path, events["showToast"], want)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"strconv"
 	"time"
 
@@ -16,16 +17,17 @@ func HistoryHandler(c *fiber.Ctx) error {
 	var completedOrders []Order
 	db.Where("status = ? AND created_at BETWEEN ? AND ?", "completed", today, tomorrow).
 		Order("created_at desc").
-		Preload("Items").
+		Preload("Items", orderLines).
 		Find(&completedOrders)
 
 	// Calcular la cantidad de ítems para cada orden
-	ordersData := prepareOrdersForDisplay(completedOrders)
+	ordersData, totalSales := prepareOrdersForDisplay(completedOrders)
 
 	return c.Render("history", fiber.Map{
 		"Title":      "Historial de Órdenes",
 		"ActivePage": "history",
 		"Orders":     ordersData,
+		"TotalSales": totalSales,
 		"StartDate":  today.Format("2006-01-02"),
 		"EndDate":    tomorrow.Format("2006-01-02"),
 		"FilterType": "today",
@@ -34,19 +36,19 @@ func HistoryHandler(c *fiber.Ctx) error {
 
 // GetTodayHistory obtiene las órdenes de hoy
 func GetTodayHistory(c *fiber.Ctx) error {
-	today := time.Now().Truncate(24 * time.Hour)
-	tomorrow := today.AddDate(0, 0, 1)
+	today, tomorrow, _ := historyRange("today", "", "")
 
 	var completedOrders []Order
 	db.Where("status = ? AND created_at BETWEEN ? AND ?", "completed", today, tomorrow).
 		Order("created_at desc").
-		Preload("Items").
+		Preload("Items", orderLines).
 		Find(&completedOrders)
 
-	ordersData := prepareOrdersForDisplay(completedOrders)
+	ordersData, totalSales := prepareOrdersForDisplay(completedOrders)
 
 	return c.Render("partials/order_history", fiber.Map{
 		"Orders":     ordersData,
+		"TotalSales": totalSales,
 		"StartDate":  today.Format("2006-01-02"),
 		"EndDate":    tomorrow.Format("2006-01-02"),
 		"FilterType": "today",
@@ -55,24 +57,19 @@ func GetTodayHistory(c *fiber.Ctx) error {
 
 // GetWeekHistory obtiene las órdenes de la semana actual
 func GetWeekHistory(c *fiber.Ctx) error {
-	now := time.Now()
-
-	// Calcular el inicio de la semana (domingo o lunes, depende de la configuración regional)
-	weekStart := now.AddDate(0, 0, -int(now.Weekday()))
-	weekStart = time.Date(weekStart.Year(), weekStart.Month(), weekStart.Day(), 0, 0, 0, 0, now.Location())
-
-	weekEnd := weekStart.AddDate(0, 0, 7)
+	weekStart, weekEnd, _ := historyRange("week", "", "")
 
 	var completedOrders []Order
 	db.Where("status = ? AND created_at BETWEEN ? AND ?", "completed", weekStart, weekEnd).
 		Order("created_at desc").
-		Preload("Items").
+		Preload("Items", orderLines).
 		Find(&completedOrders)
 
-	ordersData := prepareOrdersForDisplay(completedOrders)
+	ordersData, totalSales := prepareOrdersForDisplay(completedOrders)
 
 	return c.Render("partials/order_history", fiber.Map{
 		"Orders":     ordersData,
+		"TotalSales": totalSales,
 		"StartDate":  weekStart.Format("2006-01-02"),
 		"EndDate":    weekEnd.Format("2006-01-02"),
 		"FilterType": "week",
@@ -81,24 +78,19 @@ func GetWeekHistory(c *fiber.Ctx) error {
 
 // GetMonthHistory obtiene las órdenes del mes actual
 func GetMonthHistory(c *fiber.Ctx) error {
-	now := time.Now()
-
-	// Primer día del mes actual
-	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
-
-	// Primer día del mes siguiente
-	nextMonth := monthStart.AddDate(0, 1, 0)
+	monthStart, nextMonth, _ := historyRange("month", "", "")
 
 	var completedOrders []Order
 	db.Where("status = ? AND created_at BETWEEN ? AND ?", "completed", monthStart, nextMonth).
 		Order("created_at desc").
-		Preload("Items").
+		Preload("Items", orderLines).
 		Find(&completedOrders)
 
-	ordersData := prepareOrdersForDisplay(completedOrders)
+	ordersData, totalSales := prepareOrdersForDisplay(completedOrders)
 
 	return c.Render("partials/order_history", fiber.Map{
 		"Orders":     ordersData,
+		"TotalSales": totalSales,
 		"StartDate":  monthStart.Format("2006-01-02"),
 		"EndDate":    nextMonth.Format("2006-01-02"),
 		"FilterType": "month",
@@ -110,58 +102,92 @@ func GetCustomHistory(c *fiber.Ctx) error {
 	startDateStr := c.Query("startDate")
 	endDateStr := c.Query("endDate")
 
-	layout := "2006-01-02"
-	startDate, err1 := time.Parse(layout, startDateStr)
-	endDate, err2 := time.Parse(layout, endDateStr)
-
-	if err1 != nil || err2 != nil {
+	startDate, endDate, err := historyRange("custom", startDateStr, endDateStr)
+	if err != nil {
 		return c.Status(fiber.StatusBadRequest).SendString("Fechas inválidas")
 	}
 
-	// Ajustar la fecha final para incluir todo el día
-	endDate = endDate.Add(24 * time.Hour)
-
 	var completedOrders []Order
 	db.Where("status = ? AND created_at BETWEEN ? AND ?", "completed", startDate, endDate).
 		Order("created_at desc").
-		Preload("Items").
+		Preload("Items", orderLines).
 		Find(&completedOrders)
 
-	ordersData := prepareOrdersForDisplay(completedOrders)
+	ordersData, totalSales := prepareOrdersForDisplay(completedOrders)
 
 	return c.Render("partials/order_history", fiber.Map{
 		"Orders":     ordersData,
+		"TotalSales": totalSales,
 		"StartDate":  startDateStr,
 		"EndDate":    endDateStr,
 		"FilterType": "custom",
 	}, "")
 }
 
-// Función auxiliar para preparar los datos de órdenes para mostrar
-func prepareOrdersForDisplay(orders []Order) []fiber.Map {
+// historyRange devuelve el intervalo [inicio, fin) de un filtro del historial: "today", "week",
+// "month" o "custom", que usa startDate y endDate (AAAA-MM-DD, ambos días incluidos)
+func historyRange(filter, startDateStr, endDateStr string) (time.Time, time.Time, error) {
+	now := time.Now()
+	switch filter {
+	case "today":
+		today := now.Truncate(24 * time.Hour)
+		return today, today.AddDate(0, 0, 1), nil
+	case "week":
+		// Calcular el inicio de la semana (domingo o lunes, depende de la configuración regional)
+		weekStart := now.AddDate(0, 0, -int(now.Weekday()))
+		weekStart = time.Date(weekStart.Year(), weekStart.Month(), weekStart.Day(), 0, 0, 0, 0, now.Location())
+		return weekStart, weekStart.AddDate(0, 0, 7), nil
+	case "month":
+		// Del primer día del mes actual al primero del siguiente
+		monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+		return monthStart, monthStart.AddDate(0, 1, 0), nil
+	}
+
+	layout := "2006-01-02"
+	startDate, err := time.Parse(layout, startDateStr)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	endDate, err := time.Parse(layout, endDateStr)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	// Ajustar la fecha final para incluir todo el día
+	return startDate, endDate.Add(24 * time.Hour), nil
+}
+
+// Función auxiliar para preparar los datos de órdenes para mostrar; devuelve también la suma de sus totales netos de reembolsos
+func prepareOrdersForDisplay(orders []Order) ([]fiber.Map, Money) {
 	result := make([]fiber.Map, 0, len(orders))
+	var totalSales Money
 
 	for _, order := range orders {
 		// Calcular estadísticas de la orden
 		itemCount := len(order.Items)
 
 		orderData := fiber.Map{
-			"ID":        order.ID,
-			"TableNum":  order.TableNum,
-			"Status":    order.Status,
-			"Total":     order.Total,
-			"ItemCount": itemCount,
-			"CreatedAt": order.CreatedAt,
+			"ID":            order.ID,
+			"TableNum":      order.TableNum,
+			"Status":        order.Status,
+			"Total":         order.Total,
+			"Subtotal":      order.Subtotal,
+			"TaxAmount":     order.TaxAmount,
+			"ServiceCharge": order.ServiceCharge,
+			"Tip":           order.Tip,
+			"Refunded":      order.Refunded,
+			"ItemCount":     itemCount,
+			"CreatedAt":     order.CreatedAt,
 		}
 
 		result = append(result, orderData)
+		totalSales += order.NetTotal()
 	}
 
-	return result
+	return result, totalSales
 }
 
-// GenerateOrderReport genera un informe PDF de una orden
-func GenerateOrderReport(c *fiber.Ctx) error {
+// ViewOrderReport muestra el informe de una orden con su línea de tiempo
+func ViewOrderReport(c *fiber.Ctx) error {
 	id, err := strconv.Atoi(c.Params("id"))
 	if err != nil {
 		return c.Status(fiber.StatusBadRequest).SendString("ID inválido")
@@ -169,15 +195,81 @@ func GenerateOrderReport(c *fiber.Ctx) error {
 
 	// Obtener la orden con todos sus detalles
 	var order Order
-	result := db.Preload("Items").Preload("Items.Product").First(&order, id)
+	result := preloadPayments(db).Preload("Items", orderLines).Preload("Items.Product").First(&order, id)
 	if result.Error != nil {
 		return c.Status(fiber.StatusNotFound).SendString("Orden no encontrada")
 	}
 
-	// Aquí implementarías la generación real del PDF
-	// Por ahora simulamos devolviendo un mensaje
+	var settings Settings
+	db.First(&settings)
+
+	return c.Render("order_report", fiber.Map{
+		"Title":      "Reporte de Orden #" + strconv.Itoa(id),
+		"ActivePage": "history",
+		"Order":      order,
+		"Settings":   settings,
+		"Events":     getOrderEvents(order.ID),
+	})
+}
+
+// GenerateOrderReport genera el informe PDF de una orden
+func GenerateOrderReport(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).SendString("ID inválido")
+	}
+
+	var order Order
+	if result := preloadReportOrder(db).First(&order, id); result.Error != nil {
+		return c.Status(fiber.StatusNotFound).SendString("Orden no encontrada")
+	}
+
+	report := newPDFReport(loadSettings(db))
+	report.Order(order, getOrderEvents(order.ID))
 
-	c.Set("HX-Trigger", `{"showToast": "Reporte generado"}`)
+	return sendPDF(c, report, fmt.Sprintf("orden-%d.pdf", order.ID))
+}
+
+// Límites del PDF de un período: el informe lleva una página por orden
+const (
+	maxReportDays   = 92
+	maxReportOrders = 1000
+)
+
+// GenerateRangeReport genera un PDF con el resumen y el detalle de las órdenes
+// completadas de un período del historial (mismos filtros que el listado)
+func GenerateRangeReport(c *fiber.Ctx) error {
+	filter := c.Query("filter", "custom")
+	startDate, endDate, err := historyRange(filter, c.Query("startDate"), c.Query("endDate"))
+	if err != nil || !endDate.After(startDate) {
+		return c.Status(fiber.StatusBadRequest).SendString("Fechas inválidas")
+	}
+	if endDate.Sub(startDate) > maxReportDays*24*time.Hour {
+		return c.Status(fiber.StatusBadRequest).SendString(fmt.Sprintf("El período del informe no puede superar %d días", maxReportDays))
+	}
+
+	var orders []Order
+	preloadReportOrder(db).
+		Where("status = ? AND created_at BETWEEN ? AND ?", "completed", startDate, endDate).
+		Order("created_at asc").
+		Limit(maxReportOrders + 1).
+		Find(&orders)
+	if len(orders) > maxReportOrders {
+		return c.Status(fiber.StatusBadRequest).SendString(fmt.Sprintf("El período tiene más de %d órdenes: elige un rango más corto", maxReportOrders))
+	}
+
+	orderIDs := make([]uint, len(orders))
+	for i, order := range orders {
+		orderIDs[i] = order.ID
+	}
+	events := getOrdersEvents(orderIDs)
+
+	report := newPDFReport(loadSettings(db))
+	report.Range(orders, startDate, endDate.AddDate(0, 0, -1))
+	for _, order := range orders {
+		report.Order(order, events[order.ID])
+	}
 
-	return c.SendString("Reporte generado correctamente")
+	filename := fmt.Sprintf("ordenes-%s-%s.pdf", startDate.Format("20060102"), endDate.AddDate(0, 0, -1).Format("20060102"))
+	return sendPDF(c, report, filename)
 }
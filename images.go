@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp" // Formatos de subida admitidos, además de los de printer.go
+)
+
+// Las imágenes subidas se validan, se vuelven a codificar en los tamaños que se usan y se
+// guardan con nombres únicos en uploadDir, que se sirve con caché larga (ver main.go)
+const (
+	uploadDir        = "./static/uploads"
+	uploadPublicPath = "/static/uploads/"
+	maxImageSize     = 5 << 20    // Bytes del archivo subido
+	maxImagePixels   = 40_000_000 // Ancho × alto, para no descomprimir imágenes enormes
+	imageQuality     = 85         // Calidad JPEG
+)
+
+// imageVariant es un tamaño en el que se guarda una imagen subida
+type imageVariant struct {
+	Suffix  string // Se agrega al nombre del archivo; vacío para el tamaño completo
+	MaxSide int    // Lado mayor en píxeles; las imágenes más chicas no se agrandan
+}
+
+var (
+	productImageVariants = []imageVariant{{"", 1200}, {thumbnailSuffix, 320}}
+	logoImageVariants    = []imageVariant{{"", 512}}
+)
+
+const thumbnailSuffix = "-thumb"
+
+// Tipos de imagen aceptados, según el contenido y no la extensión del archivo
+var allowedImageTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+// decodeUpload valida el tamaño y el tipo de una imagen subida y la decodifica
+func decodeUpload(file *multipart.FileHeader) (image.Image, error) {
+	if file.Size > maxImageSize {
+		return nil, fmt.Errorf("la imagen supera los %d MB", maxImageSize>>20)
+	}
+	f, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, maxImageSize+1))
+	if err != nil {
+		return nil, err
+	}
+	return decodeImage(data)
+}
+
+// decodeImage valida el tamaño y el tipo, según el contenido, de una imagen y la decodifica
+func decodeImage(data []byte) (image.Image, error) {
+	if len(data) > maxImageSize {
+		return nil, fmt.Errorf("la imagen supera los %d MB", maxImageSize>>20)
+	}
+	if !allowedImageTypes[http.DetectContentType(data)] {
+		return nil, errors.New("la imagen debe ser JPEG, PNG, GIF o WebP")
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, errors.New("no se pudo leer la imagen")
+	}
+	if config.Width*config.Height > maxImagePixels {
+		return nil, errors.New("la imagen tiene demasiados píxeles")
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, errors.New("no se pudo leer la imagen")
+	}
+	return img, nil
+}
+
+// fitImage reduce la imagen para que su lado mayor no pase de maxSide. Si flatten es true
+// la pinta sobre fondo blanco, para formatos sin transparencia.
+func fitImage(src image.Image, maxSide int, flatten bool) image.Image {
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width > maxSide || height > maxSide {
+		if width >= height {
+			width, height = maxSide, max(1, height*maxSide/width)
+		} else {
+			width, height = max(1, width*maxSide/height), maxSide
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	if flatten {
+		draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	}
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, bounds, draw.Over, nil)
+	return dst
+}
+
+// saveUploadedImage valida una imagen subida y la guarda en cada tamaño con el nombre
+// <prefix>-<marca de tiempo><sufijo>.<ext>; ext es jpg o png. Devuelve la ruta pública del
+// tamaño completo.
+func saveUploadedImage(file *multipart.FileHeader, prefix, ext string, variants []imageVariant) (string, error) {
+	img, err := decodeUpload(file)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(uploadDir, os.ModePerm); err != nil {
+		return "", err
+	}
+
+	base := fmt.Sprintf("%s-%d", prefix, time.Now().UnixNano())
+	if err := writeImageVariants(img, filepath.Join(uploadDir, base), ext, variants); err != nil {
+		return "", err
+	}
+	return uploadPublicPath + base + "." + ext, nil
+}
+
+// writeImageVariants vuelve a codificar la imagen en cada tamaño como <base><sufijo>.<ext>, en PNG
+// si ext es png y en JPEG si no. Si falla alguno borra los que ya había guardado.
+func writeImageVariants(img image.Image, base, ext string, variants []imageVariant) error {
+	var saved []string
+	for _, variant := range variants {
+		var buf bytes.Buffer
+		var err error
+		if ext == "png" {
+			err = png.Encode(&buf, fitImage(img, variant.MaxSide, false))
+		} else {
+			err = jpeg.Encode(&buf, fitImage(img, variant.MaxSide, true), &jpeg.Options{Quality: imageQuality})
+		}
+		target := base + variant.Suffix + "." + ext
+		if err == nil {
+			err = os.WriteFile(target, buf.Bytes(), 0o644)
+		}
+		if err != nil {
+			for _, path := range saved {
+				os.Remove(path)
+			}
+			return err
+		}
+		saved = append(saved, target)
+	}
+	return nil
+}
+
+// thumbnailPath devuelve la miniatura de una imagen subida, o la propia imagen si no tiene
+func thumbnailPath(publicPath string) string {
+	if !isUploadPath(publicPath) {
+		return publicPath
+	}
+	ext := filepath.Ext(publicPath)
+	thumb := strings.TrimSuffix(publicPath, ext) + thumbnailSuffix + ext
+	if _, err := os.Stat("." + thumb); err != nil {
+		return publicPath
+	}
+	return thumb
+}
+
+// removeUploadedImage borra una imagen subida con su miniatura; ignora rutas fuera de uploadDir
+func removeUploadedImage(publicPath string) {
+	if !isUploadPath(publicPath) {
+		return
+	}
+	ext := filepath.Ext(publicPath)
+	for _, path := range []string{publicPath, strings.TrimSuffix(publicPath, ext) + thumbnailSuffix + ext} {
+		if err := os.Remove("." + path); err != nil && !os.IsNotExist(err) {
+			log.Printf("No se pudo borrar la imagen %s: %v", path, err)
+		}
+	}
+}
@@ -0,0 +1,420 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Cantidad de movimientos recientes que se muestran en la página de inventario
+const recentMovementsLimit = 30
+
+// loadIngredients devuelve los ingredientes ordenados por nombre
+func loadIngredients() []Ingredient {
+	var ingredients []Ingredient
+	db.Order("name").Find(&ingredients)
+	return ingredients
+}
+
+// IsLow indica si el ingrediente está en o bajo su umbral de alerta, o agotado
+func (i Ingredient) IsLow() bool {
+	return i.OnHand <= 0 || (i.LowStock > 0 && i.OnHand <= i.LowStock)
+}
+
+// renderIngredientList devuelve la lista actualizada de ingredientes
+func renderIngredientList(c *fiber.Ctx) error {
+	return c.Render("partials/ingredient_list", fiber.Map{
+		"Ingredients": loadIngredients(),
+	}, "")
+}
+
+// InventoryHandler muestra la página de inventario: ingredientes, alertas y movimientos
+func InventoryHandler(c *fiber.Ctx) error {
+	ingredients := loadIngredients()
+	lowCount := 0
+	for _, ingredient := range ingredients {
+		if ingredient.IsLow() {
+			lowCount++
+		}
+	}
+
+	var outOfStock []Product
+	db.Where("out_of_stock = ?", true).Order("name").Find(&outOfStock)
+
+	return c.Render("inventory", fiber.Map{
+		"Title":       "Inventario",
+		"ActivePage":  "inventory",
+		"Ingredients": ingredients,
+		"LowCount":    lowCount,
+		"OutOfStock":  outOfStock,
+	})
+}
+
+// GetStockMovements devuelve los últimos movimientos de existencias
+func GetStockMovements(c *fiber.Ctx) error {
+	var movements []StockMovement
+	db.Preload("User", unscopedUsers).Order("created_at DESC, id DESC").Limit(recentMovementsLimit).Find(&movements)
+
+	byID := map[uint]Ingredient{}
+	for _, ingredient := range loadIngredients() {
+		byID[ingredient.ID] = ingredient
+	}
+
+	return c.Render("partials/stock_movements", fiber.Map{
+		"Movements":   movements,
+		"Ingredients": byID,
+	}, "")
+}
+
+// GetIngredientForm muestra el formulario para crear un ingrediente
+func GetIngredientForm(c *fiber.Ctx) error {
+	return c.Render("partials/ingredient_form", fiber.Map{
+		"IsNew": true,
+	}, "")
+}
+
+// GetIngredientEditForm muestra el formulario para editar un ingrediente
+func GetIngredientEditForm(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).SendString("ID inválido")
+	}
+
+	var ingredient Ingredient
+	if result := db.First(&ingredient, id); result.Error != nil {
+		return c.Status(fiber.StatusNotFound).SendString("Ingrediente no encontrado")
+	}
+
+	return c.Render("partials/ingredient_form", fiber.Map{
+		"Ingredient": ingredient,
+		"IsNew":      false,
+	}, "")
+}
+
+// GetIngredientAdjustForm muestra el formulario para contar o agregar existencias
+func GetIngredientAdjustForm(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).SendString("ID inválido")
+	}
+
+	var ingredient Ingredient
+	if result := db.First(&ingredient, id); result.Error != nil {
+		return c.Status(fiber.StatusNotFound).SendString("Ingrediente no encontrado")
+	}
+
+	return c.Render("partials/ingredient_adjust", fiber.Map{
+		"Ingredient": ingredient,
+	}, "")
+}
+
+// ingredientFromForm lee el nombre, la unidad y el umbral de alerta del formulario
+func ingredientFromForm(c *fiber.Ctx, ingredient *Ingredient) error {
+	ingredient.Name = strings.TrimSpace(c.FormValue("name"))
+	ingredient.Unit = strings.TrimSpace(c.FormValue("unit"))
+	if ingredient.Name == "" || ingredient.Unit == "" {
+		return errors.New("Nombre y unidad son obligatorios")
+	}
+
+	ingredient.LowStock = 0
+	if value := c.FormValue("low_stock"); strings.TrimSpace(value) != "" {
+		lowStock, err := parseQuantity(value)
+		if err != nil || lowStock < 0 {
+			return errors.New("Umbral de alerta inválido")
+		}
+		ingredient.LowStock = lowStock
+	}
+
+	var count int64
+	db.Model(&Ingredient{}).Where("LOWER(name) = LOWER(?) AND id <> ?", ingredient.Name, ingredient.ID).Count(&count)
+	if count > 0 {
+		return errors.New("Ya existe un ingrediente con ese nombre")
+	}
+	return nil
+}
+
+// CreateIngredient crea un ingrediente con sus existencias iniciales
+func CreateIngredient(c *fiber.Ctx) error {
+	var ingredient Ingredient
+	if err := ingredientFromForm(c, &ingredient); err != nil {
+		return Error(c, err.Error(), fiber.StatusBadRequest)
+	}
+
+	onHand := 0.0
+	if value := c.FormValue("on_hand"); strings.TrimSpace(value) != "" {
+		quantity, err := parseQuantity(value)
+		if err != nil || quantity < 0 {
+			return Error(c, "Existencia inicial inválida", fiber.StatusBadRequest)
+		}
+		onHand = quantity
+	}
+
+	// La existencia inicial queda como un ajuste para que el historial cuadre con OnHand
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&ingredient).Error; err != nil {
+			return err
+		}
+		return changeStock(tx, StockMovement{
+			IngredientID: ingredient.ID,
+			Quantity:     onHand,
+			Reason:       StockAdjustment,
+			UserID:       currentUserID(c),
+			Notes:        "Existencia inicial",
+		})
+	})
+	if err != nil {
+		log.Printf("Error al crear ingrediente: %v", err)
+		return Error(c, "Error al crear el ingrediente", fiber.StatusInternalServerError)
+	}
+
+	setTrigger(c, fiber.Map{"showToast": "Ingrediente '" + ingredient.Name + "' creado correctamente", "closeModal": true, "stockChanged": true})
+	return renderIngredientList(c)
+}
+
+// UpdateIngredient actualiza el nombre, la unidad y el umbral de un ingrediente. Las
+// existencias solo cambian con ajustes, para que quede el movimiento.
+func UpdateIngredient(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return Error(c, "ID inválido", fiber.StatusBadRequest)
+	}
+
+	var ingredient Ingredient
+	if result := db.First(&ingredient, id); result.Error != nil {
+		return Error(c, "Ingrediente no encontrado", fiber.StatusNotFound)
+	}
+	if err := ingredientFromForm(c, &ingredient); err != nil {
+		return Error(c, err.Error(), fiber.StatusBadRequest)
+	}
+
+	if err := db.Select("name", "unit", "low_stock").Save(&ingredient).Error; err != nil {
+		log.Printf("Error al actualizar ingrediente: %v", err)
+		return Error(c, "Error al actualizar el ingrediente", fiber.StatusInternalServerError)
+	}
+
+	setTrigger(c, fiber.Map{"showToast": "Ingrediente '" + ingredient.Name + "' actualizado correctamente", "closeModal": true})
+	return renderIngredientList(c)
+}
+
+// AdjustIngredient registra un conteo físico (mode=count, la cantidad es la existencia real)
+// o una entrada o salida manual (mode=add, la cantidad se suma y puede ser negativa)
+func AdjustIngredient(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return Error(c, "ID inválido", fiber.StatusBadRequest)
+	}
+
+	var ingredient Ingredient
+	if result := db.First(&ingredient, id); result.Error != nil {
+		return Error(c, "Ingrediente no encontrado", fiber.StatusNotFound)
+	}
+
+	quantity, err := parseQuantity(c.FormValue("quantity"))
+	if err != nil {
+		return Error(c, "Cantidad inválida", fiber.StatusBadRequest)
+	}
+	count := c.FormValue("mode") == "count"
+	if count && quantity < 0 {
+		return Error(c, "El conteo no puede ser negativo", fiber.StatusBadRequest)
+	}
+	notes := strings.TrimSpace(c.FormValue("notes"))
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		change := quantity
+		if count {
+			// Releer dentro de la transacción: pudo venderse algo desde que se abrió el formulario
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&ingredient, ingredient.ID).Error; err != nil {
+				return err
+			}
+			change = quantity - ingredient.OnHand
+			if notes == "" {
+				notes = "Conteo"
+			}
+		}
+		err := changeStock(tx, StockMovement{
+			IngredientID: ingredient.ID,
+			Quantity:     change,
+			Reason:       StockAdjustment,
+			UserID:       currentUserID(c),
+			Notes:        notes,
+		})
+		if err != nil {
+			return err
+		}
+		return refreshStockAvailability(tx)
+	})
+	if err != nil {
+		log.Printf("Error al ajustar existencias: %v", err)
+		return Error(c, "Error al ajustar las existencias", fiber.StatusInternalServerError)
+	}
+
+	setTrigger(c, fiber.Map{"showToast": "Existencias de '" + ingredient.Name + "' actualizadas", "closeModal": true, "stockChanged": true})
+	return renderIngredientList(c)
+}
+
+// DeleteIngredient elimina un ingrediente que no se usa en ninguna receta
+func DeleteIngredient(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return Error(c, "ID inválido", fiber.StatusBadRequest)
+	}
+
+	var ingredient Ingredient
+	if result := db.First(&ingredient, id); result.Error != nil {
+		return Error(c, "Ingrediente no encontrado", fiber.StatusNotFound)
+	}
+
+	var used int64
+	db.Model(&RecipeLine{}).Where("ingredient_id = ?", ingredient.ID).Count(&used)
+	if used > 0 {
+		return Error(c, "El ingrediente se usa en recetas; quítalo de ellas antes de eliminarlo", fiber.StatusBadRequest)
+	}
+	db.Model(&PurchaseOrderLine{}).Where("ingredient_id = ?", ingredient.ID).Count(&used)
+	if used > 0 {
+		return Error(c, "El ingrediente tiene órdenes de compra; no se puede eliminar", fiber.StatusBadRequest)
+	}
+
+	if err := db.Delete(&ingredient).Error; err != nil {
+		log.Printf("Error al eliminar ingrediente: %v", err)
+		return Error(c, "Error al eliminar el ingrediente", fiber.StatusInternalServerError)
+	}
+
+	setTrigger(c, fiber.Map{"showToast": "Ingrediente '" + ingredient.Name + "' eliminado", "stockChanged": true})
+	return renderIngredientList(c)
+}
+
+// renderProductRecipe responde con la receta de un producto para editarla
+func renderProductRecipe(c *fiber.Ctx, productID uint) error {
+	var product Product
+	if err := db.Preload("Recipe", func(tx *gorm.DB) *gorm.DB { return tx.Order("id") }).
+		Preload("Recipe.Ingredient").First(&product, productID).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).SendString("Producto no encontrado")
+	}
+
+	return c.Render("partials/product_recipe", fiber.Map{
+		"Product":     product,
+		"Ingredients": loadIngredients(),
+	}, "")
+}
+
+// GetProductRecipe muestra los ingredientes que lleva una unidad del producto
+func GetProductRecipe(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).SendString("ID inválido")
+	}
+	return renderProductRecipe(c, uint(id))
+}
+
+// CreateRecipeLine agrega un ingrediente a la receta; si ya estaba, reemplaza su cantidad
+func CreateRecipeLine(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return Error(c, "ID de producto inválido", fiber.StatusBadRequest)
+	}
+	var product Product
+	if result := db.First(&product, id); result.Error != nil {
+		return Error(c, "Producto no encontrado", fiber.StatusNotFound)
+	}
+
+	var ingredient Ingredient
+	if result := db.First(&ingredient, c.FormValue("ingredient_id")); result.Error != nil {
+		return Error(c, "Elige un ingrediente", fiber.StatusBadRequest)
+	}
+	quantity, err := parseQuantity(c.FormValue("quantity"))
+	if err != nil || quantity <= 0 {
+		return Error(c, "La cantidad debe ser mayor que cero", fiber.StatusBadRequest)
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var line RecipeLine
+		tx.Where("product_id = ? AND ingredient_id = ?", product.ID, ingredient.ID).First(&line)
+		line.ProductID = product.ID
+		line.IngredientID = ingredient.ID
+		line.Quantity = quantity
+		if err := tx.Save(&line).Error; err != nil {
+			return err
+		}
+		return refreshStockAvailability(tx)
+	})
+	if err != nil {
+		log.Printf("Error al guardar la receta: %v", err)
+		return Error(c, "Error al guardar la receta", fiber.StatusInternalServerError)
+	}
+
+	c.Set("HX-Trigger", `{"showToast": "Receta actualizada", "toastType": "success"}`)
+	return renderProductRecipe(c, product.ID)
+}
+
+// DeleteRecipeLine quita un ingrediente de la receta de un producto
+func DeleteRecipeLine(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return Error(c, "ID de ingrediente inválido", fiber.StatusBadRequest)
+	}
+	var line RecipeLine
+	if result := db.First(&line, id); result.Error != nil {
+		return Error(c, "Ingrediente no encontrado en la receta", fiber.StatusNotFound)
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&line).Error; err != nil {
+			return err
+		}
+		return refreshStockAvailability(tx)
+	})
+	if err != nil {
+		log.Printf("Error al quitar de la receta: %v", err)
+		return Error(c, "Error al quitar el ingrediente", fiber.StatusInternalServerError)
+	}
+
+	c.Set("HX-Trigger", `{"showToast": "Receta actualizada", "toastType": "success"}`)
+	return renderProductRecipe(c, line.ProductID)
+}
+
+// valuationRow es un ingrediente en el reporte de valorización
+type valuationRow struct {
+	Ingredient
+	Value Money
+}
+
+// ValuationHandler muestra el valor del inventario: existencias por el último costo de compra.
+// Las existencias negativas (ventas sin recepción registrada) valen cero.
+func ValuationHandler(c *fiber.Ctx) error {
+	var rows []valuationRow
+	var total Money
+	withoutCost := 0
+	for _, ingredient := range loadIngredients() {
+		row := valuationRow{Ingredient: ingredient, Value: ingredient.LastCost.MulRate(max(0, ingredient.OnHand))}
+		if ingredient.LastCost == 0 && ingredient.OnHand > 0 {
+			withoutCost++
+		}
+		total += row.Value
+		rows = append(rows, row)
+	}
+
+	// Lo que falta recibir de las órdenes enviadas, al costo de cada línea
+	var pending Money
+	var lines []PurchaseOrderLine
+	db.Joins("JOIN purchase_orders ON purchase_orders.id = purchase_order_lines.purchase_order_id").
+		Where("purchase_orders.status = ?", PurchaseOrdered).Find(&lines)
+	for _, line := range lines {
+		pending += line.UnitCost.MulRate(line.Pending())
+	}
+
+	return c.Render("valuation", fiber.Map{
+		"Title":       "Valorización de inventario",
+		"ActivePage":  "valuation",
+		"Rows":        rows,
+		"Total":       total,
+		"WithoutCost": withoutCost,
+		"Pending":     pending,
+		"GeneratedAt": time.Now(),
+	})
+}
@@ -6,30 +6,33 @@ import (
 	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
 )
 
-// KitchenHandler muestra la vista de cocina
-func KitchenHandler(c *fiber.Ctx) error {
+// kitchenOrders devuelve las órdenes enviadas a cocina que tienen ítems por preparar; de los
+// combos se muestran sus componentes
+func kitchenOrders() []Order {
 	var orders []Order
-	// Solo mostrar órdenes con ítems pendientes de cocina
 	allOrders := []Order{}
-	db.Where("status IN (?)", []string{"pending", "in_progress", "ready"}).
+	db.Where("status = ?", StatusInProgress).
 		Order("created_at asc").
-		Preload("Items").
+		Preload("Items", kitchenLines).
 		Preload("Items.Product").
 		Find(&allOrders)
 	for _, o := range allOrders {
-		show := false
 		for _, item := range o.Items {
 			if !item.IsReady {
-				show = true
+				orders = append(orders, o)
 				break
 			}
 		}
-		if show {
-			orders = append(orders, o)
-		}
 	}
+	return orders
+}
+
+// KitchenHandler muestra la vista de cocina
+func KitchenHandler(c *fiber.Ctx) error {
+	orders := kitchenOrders()
 	return c.Render("kitchen", fiber.Map{
 		"Title":      "Cocina",
 		"ActivePage": "kitchen",
@@ -39,25 +42,7 @@ func KitchenHandler(c *fiber.Ctx) error {
 
 // GetKitchenOrders devuelve la lista actualizada de órdenes para la cocina
 func GetKitchenOrders(c *fiber.Ctx) error {
-	var orders []Order
-	allOrders := []Order{}
-	db.Where("status IN (?)", []string{"pending", "in_progress", "ready"}).
-		Order("created_at asc").
-		Preload("Items").
-		Preload("Items.Product").
-		Find(&allOrders)
-	for _, o := range allOrders {
-		show := false
-		for _, item := range o.Items {
-			if !item.IsReady {
-				show = true
-				break
-			}
-		}
-		if show {
-			orders = append(orders, o)
-		}
-	}
+	orders := kitchenOrders()
 	return c.Render("partials/kitchen_orders", fiber.Map{
 		"Orders": orders,
 	}, "")
@@ -75,125 +60,112 @@ func ToggleItemStatus(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusNotFound).SendString("Ítem no encontrado")
 	}
 
-	// Registrar métricas de tiempo
-	now := time.Now()
-
-	if !item.IsReady {
-		// El producto está pasando de "no listo" a "listo"
-		item.IsReady = true
-
-		// Si no tenía tiempo de inicio, registrarlo
-		if item.CookingStarted == nil {
-			if item.CookingFinished != nil {
-				item.CookingStarted = item.CookingFinished
-			} else {
-				item.CookingStarted = &now
-			}
+	var order Order
+	message := "Estado actualizado"
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := lockOrder(tx, item.OrderID, &order); err != nil {
+			return err
 		}
 
-		// Registrar tiempo de finalización
-		item.CookingFinished = &now
-
-		// Calcular tiempo de cocción total en segundos
-		if item.CookingStarted != nil {
-			cookingTime := int(now.Sub(*item.CookingStarted).Seconds())
-			if cookingTime < 0 {
-				cookingTime = 0
-			}
-			item.CookingTime = cookingTime
+		// La cocina solo trabaja sobre órdenes enviadas que siguen abiertas
+		if order.Status == StatusPending {
+			return fiber.NewError(fiber.StatusBadRequest, "La orden aún no fue enviada a cocina")
+		}
+		if !isOrderEditable(order.Status) {
+			return errOrderClosed(order)
 		}
 
-		if item.DeliveredAt == nil {
-			item.DeliveredAt = &now
+		// Releer el ítem con la orden bloqueada por si otro cocinero lo modificó
+		if err := tx.First(&item, itemID).Error; err != nil {
+			return fiber.NewError(fiber.StatusNotFound, "Ítem no encontrado")
+		}
+		if item.IsCombo {
+			return fiber.NewError(fiber.StatusBadRequest, "Marca por separado los productos del combo")
 		}
-	} else {
-		// El producto está pasando de "listo" a "no listo"
-		item.IsReady = false
-		item.CookingFinished = nil // Remover tiempo de finalización
-		item.CookingTime = 0
-		item.DeliveredAt = nil
-	}
 
-	db.Save(&item)
+		// Registrar métricas de tiempo
+		now := time.Now()
 
-	// Actualizar el estado de la orden a "in_progress" si estaba en "pending"
-	var order Order
-	db.First(&order, item.OrderID)
-	if order.Status == "pending" {
-		order.Status = "in_progress"
-		db.Save(&order)
-	}
+		if !item.IsReady {
+			// El producto está pasando de "no listo" a "listo"
+			item.IsReady = true
 
-	// Verificar si todos los items están listos para sugerir completar la orden
-	var itemsCount int64
-	var readyItemsCount int64
+			// Si no tenía tiempo de inicio, registrarlo
+			if item.CookingStarted == nil {
+				if item.CookingFinished != nil {
+					item.CookingStarted = item.CookingFinished
+				} else {
+					item.CookingStarted = &now
+				}
+			}
 
-	db.Model(&OrderItem{}).Where("order_id = ?", item.OrderID).Count(&itemsCount)
-	db.Model(&OrderItem{}).Where("order_id = ? AND is_ready = ?", item.OrderID, true).Count(&readyItemsCount)
+			// Registrar tiempo de finalización y quién lo preparó
+			item.CookingFinished = &now
+			item.PreparedByID = currentUserID(c)
+
+			// Calcular tiempo de cocción total en segundos
+			if item.CookingStarted != nil {
+				cookingTime := int(now.Sub(*item.CookingStarted).Seconds())
+				if cookingTime < 0 {
+					cookingTime = 0
+				}
+				item.CookingTime = cookingTime
+			}
 
-	message := "Estado actualizado"
-	if itemsCount > 0 && itemsCount == readyItemsCount {
-		message = "¡Todos los productos están listos! Puede completar la orden."
-	}
+			if item.DeliveredAt == nil {
+				item.DeliveredAt = &now
+			}
+		} else {
+			// El producto está pasando de "listo" a "no listo"
+			item.IsReady = false
+			item.CookingFinished = nil // Remover tiempo de finalización
+			item.CookingTime = 0
+			item.DeliveredAt = nil
+			item.PreparedByID = nil
+		}
 
-	// Si todos los ítems están listos, marcar CookingCompletedAt en la orden
-	var allItems []OrderItem
-	db.Where("order_id = ?", item.OrderID).Find(&allItems)
-	allReady := true
-	for _, it := range allItems {
-		if !it.IsReady {
-			allReady = false
-			break
+		if err := tx.Save(&item).Error; err != nil {
+			return err
 		}
-	}
-	if allReady {
-		if order.CookingCompletedAt == nil {
-			order.CookingCompletedAt = &now
-			db.Save(&order)
+		if item.ParentItemID != nil {
+			if err := syncComboReady(tx, *item.ParentItemID); err != nil {
+				return err
+			}
 		}
-	}
-
-	c.Set("HX-Trigger", `{"showToast": "`+message+`"}`)
 
-	// --- Notificación WebSocket ---
-	wsBroadcast <- WSMessage{
-		Type:    "order_update",
-		Payload: order,
-	}
-	wsBroadcast <- WSMessage{
-		Type:    "kitchen_update",
-		Payload: order,
-	}
-	// -----------------------------
-
-	// Obtener órdenes pendientes actualizadas para actualizar la vista
-	var pendingOrders []Order
-	allOrders := []Order{}
-	db.Where("status IN (?)", []string{"pending", "in_progress", "ready"}).
-		Order("created_at asc").
-		Preload("Items").
-		Preload("Items.Product").
-		Find(&allOrders)
-	for _, o := range allOrders {
-		show := false
-		for _, item := range o.Items {
-			if !item.IsReady {
-				show = true
-				break
+		if !item.IsReady {
+			// Un producto desmarcado devuelve la orden a preparación
+			if order.Status == StatusReady || order.Status == StatusToPay {
+				return applyTransition(tx, &order, StatusInProgress, currentUserID(c), "Producto desmarcado en cocina")
 			}
+			return nil
 		}
-		if show {
-			pendingOrders = append(pendingOrders, o)
+
+		// Si todos los ítems están listos, la orden queda lista para entregar
+		ready, err := SetOrderReadyIfAllItemsReady(tx, &order, currentUserID(c))
+		if ready {
+			message = "¡Todos los productos están listos! Orden lista para entregar."
 		}
+		return err
+	})
+	if err != nil {
+		return orderErrorResponse(c, err)
 	}
 
+	setTrigger(c, fiber.Map{"showToast": message})
+	broadcastOrderUpdate(order)
+
+	// Obtener órdenes pendientes actualizadas para actualizar la vista
+	pendingOrders := kitchenOrders()
+
 	// Devolver la vista actualizada
 	return c.Render("partials/kitchen_orders", fiber.Map{
 		"Orders": pendingOrders,
 	}, "")
 }
 
-// KitchenCompleteOrder marca una orden como completada desde la cocina
+// KitchenCompleteOrder marca todos los productos de la orden como listos y
+// la deja lista para entregar; el cobro y cierre quedan a cargo del mesero
 func KitchenCompleteOrder(c *fiber.Ctx) error {
 	id, err := strconv.Atoi(c.Params("id"))
 	if err != nil {
@@ -201,79 +173,52 @@ func KitchenCompleteOrder(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusBadRequest).SendString("ID inválido")
 	}
 
-	log.Printf("Completando orden #%d", id)
-
 	var order Order
-	if result := db.First(&order, id); result.Error != nil {
-		log.Printf("Orden no encontrada: %v", result.Error)
-		return c.Status(fiber.StatusNotFound).SendString("Orden no encontrada")
-	}
-
 	now := time.Now()
-	// Refuerzo: asegurar que todos los ítems tengan CookingFinished, CookingTime y DeliveredAt
-	var items []OrderItem
-	db.Where("order_id = ?", order.ID).Find(&items)
-	for _, item := range items {
-		if !item.IsReady {
-			item.IsReady = true
-			if item.CookingStarted == nil {
-				item.CookingStarted = &now
-			}
-			item.CookingFinished = &now
-			cookingTime := int(now.Sub(*item.CookingStarted).Seconds())
-			if cookingTime < 0 {
-				cookingTime = 0
-			}
-			item.CookingTime = cookingTime
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := lockOrder(tx, uint(id), &order); err != nil {
+			return err
 		}
-		if item.DeliveredAt == nil {
-			item.DeliveredAt = &now
+		if !canTransition(order.Status, StatusReady) {
+			return &TransitionError{From: order.Status, To: StatusReady}
 		}
-		db.Save(&item)
-	}
-	if order.CookingCompletedAt == nil {
-		order.CookingCompletedAt = &now
-	}
-	order.Status = "completed"
-	order.UpdatedAt = now
-	if order.CompletedAt == nil {
-		order.CompletedAt = &now
-	}
-	if order.DeliveredAt == nil {
-		order.DeliveredAt = &now
-	}
-	db.Save(&order)
-	log.Printf("Orden #%d marcada como completada", id)
 
-	// Liberar la mesa asociada
-	db.Model(&Table{}).Where("number = ?", order.TableNum).Updates(map[string]interface{}{
-		"occupied": false,
-		"order_id": nil,
-	})
-	log.Printf("Mesa %d liberada", order.TableNum)
-
-	// Obtener órdenes pendientes actualizadas para actualizar la vista
-	var pendingOrders []Order
-	allOrders := []Order{}
-	db.Where("status IN (?)", []string{"pending", "in_progress", "ready"}).
-		Order("created_at asc").
-		Preload("Items").
-		Preload("Items.Product").
-		Find(&allOrders)
-	for _, o := range allOrders {
-		show := false
-		for _, item := range o.Items {
+		// Refuerzo: asegurar que todos los ítems tengan CookingFinished, CookingTime y DeliveredAt
+		var items []OrderItem
+		tx.Where("order_id = ?", order.ID).Find(&items)
+		for _, item := range items {
 			if !item.IsReady {
-				show = true
-				break
+				item.IsReady = true
+				if item.CookingStarted == nil {
+					item.CookingStarted = &now
+				}
+				item.CookingFinished = &now
+				item.PreparedByID = currentUserID(c)
+				// El tiempo de un combo se registra en sus componentes
+				cookingTime := int(now.Sub(*item.CookingStarted).Seconds())
+				if cookingTime < 0 || item.IsCombo {
+					cookingTime = 0
+				}
+				item.CookingTime = cookingTime
+			}
+			if item.DeliveredAt == nil {
+				item.DeliveredAt = &now
+			}
+			if err := tx.Save(&item).Error; err != nil {
+				return err
 			}
 		}
-		if show {
-			pendingOrders = append(pendingOrders, o)
-		}
+		return applyTransition(tx, &order, StatusReady, currentUserID(c), "")
+	})
+	if err != nil {
+		return orderErrorResponse(c, err)
 	}
+	broadcastOrderUpdate(order)
+
+	// Obtener órdenes pendientes actualizadas para actualizar la vista
+	pendingOrders := kitchenOrders()
 
-	c.Set("HX-Trigger", `{"showToast": "Orden #`+strconv.Itoa(id)+` completada correctamente"}`)
+	setTrigger(c, fiber.Map{"showToast": "Orden #" + strconv.Itoa(id) + " lista para entregar"})
 
 	return c.Render("partials/kitchen_orders", fiber.Map{
 		"Orders": pendingOrders,
@@ -288,7 +233,7 @@ func GetOrderCompletionStatus(c *fiber.Ctx) error {
 	}
 
 	var order Order
-	if result := db.Preload("Items").First(&order, id); result.Error != nil {
+	if result := db.Preload("Items", kitchenLines).First(&order, id); result.Error != nil {
 		return c.Status(fiber.StatusNotFound).SendString("Orden no encontrada")
 	}
 
@@ -338,17 +283,16 @@ func GetKitchenStats(c *fiber.Ctx) error {
 
 	var fastestProducts []ProductCookingTime
 	db.Raw(`
-        SELECT oi.product_id, p.name as product_name, 
+        SELECT oi.product_id, oi.product_name, 
                AVG(oi.cooking_time) as avg_time,
                MIN(oi.cooking_time) as min_time,
                MAX(oi.cooking_time) as max_time,
                COUNT(oi.id) as count
         FROM order_items oi
-        JOIN products p ON p.id = oi.product_id
         WHERE oi.cooking_time > 0
         AND oi.cooking_finished IS NOT NULL
         AND oi.created_at >= ?
-        GROUP BY oi.product_id, p.name
+        GROUP BY oi.product_id, oi.product_name
         ORDER BY avg_time ASC
         LIMIT 10
     `, startDate).Scan(&fastestProducts)
@@ -356,17 +300,16 @@ func GetKitchenStats(c *fiber.Ctx) error {
 	// Productos más lentos
 	var slowestProducts []ProductCookingTime
 	db.Raw(`
-        SELECT oi.product_id, p.name as product_name, 
+        SELECT oi.product_id, oi.product_name, 
                AVG(oi.cooking_time) as avg_time,
                MIN(oi.cooking_time) as min_time,
                MAX(oi.cooking_time) as max_time,
                COUNT(oi.id) as count
         FROM order_items oi
-        JOIN products p ON p.id = oi.product_id
         WHERE oi.cooking_time > 0
         AND oi.cooking_finished IS NOT NULL
         AND oi.created_at >= ?
-        GROUP BY oi.product_id, p.name
+        GROUP BY oi.product_id, oi.product_name
         ORDER BY avg_time DESC
         LIMIT 10
     `, startDate).Scan(&slowestProducts)
@@ -380,13 +323,12 @@ func GetKitchenStats(c *fiber.Ctx) error {
 
 	var categoryTimes []CategoryCookingTime
 	db.Raw(`
-        SELECT p.category, AVG(oi.cooking_time) as avg_time, COUNT(oi.id) as count
+        SELECT oi.category, AVG(oi.cooking_time) as avg_time, COUNT(oi.id) as count
         FROM order_items oi
-        JOIN products p ON p.id = oi.product_id
         WHERE oi.cooking_time > 0
         AND oi.cooking_finished IS NOT NULL
         AND oi.created_at >= ?
-        GROUP BY p.category
+        GROUP BY oi.category
         ORDER BY avg_time ASC
     `, startDate).Scan(&categoryTimes)
 
@@ -433,7 +375,32 @@ func GetKitchenStats(c *fiber.Ctx) error {
         ORDER BY hour
     `, startDate).Scan(&hourlyStats)
 
-	return c.Render("kitchen_stats", fiber.Map{
+	// Desglose por cocinero (quién marcó el ítem como listo)
+	type CookStats struct {
+		UserID    uint
+		Name      string
+		ItemCount int
+		Quantity  int
+		AvgTime   float64
+	}
+
+	var cookStats []CookStats
+	db.Raw(`
+        SELECT u.id as user_id,
+               COALESCE(NULLIF(u.full_name, ''), u.username) as name,
+               COUNT(oi.id) as item_count,
+               COALESCE(SUM(oi.quantity), 0) as quantity,
+               AVG(oi.cooking_time) as avg_time
+        FROM order_items oi
+        JOIN users u ON u.id = oi.prepared_by_id
+        WHERE oi.cooking_time > 0
+        AND oi.cooking_finished IS NOT NULL
+        AND oi.created_at >= ?
+        GROUP BY u.id, name
+        ORDER BY item_count DESC
+    `, startDate).Scan(&cookStats)
+
+	return c.Render("partials/kitchen_stats", fiber.Map{
 		"Title":           "Estadísticas de Cocina",
 		"ActivePage":      "kitchen_stats",
 		"Days":            days,
@@ -442,5 +409,6 @@ func GetKitchenStats(c *fiber.Ctx) error {
 		"CategoryTimes":   categoryTimes,
 		"DailyPrepTimes":  dailyPrepTimes,
 		"HourlyStats":     hourlyStats,
+		"CookStats":       cookStats,
 	})
 }
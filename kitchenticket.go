@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+)
+
+// Nombre de la estación para las categorías que usan la impresora de cocina general
+const defaultKitchenStation = "Cocina"
+
+// kitchenStation es un destino de comandas con los ítems que le tocan
+type kitchenStation struct {
+	Name    string
+	Printer string
+	Items   []OrderItem
+}
+
+// routeKitchenItems reparte los ítems entre las impresoras según la categoría actual de su
+// producto (productCategories: ID de producto -> ID de categoría); las categorías sin impresora
+// propia van a la de cocina general. Los ítems sin destino no se imprimen.
+func routeKitchenItems(items []OrderItem, settings Settings, routes []CategoryPrinter, productCategories map[uint]uint) []*kitchenStation {
+	byCategory := make(map[uint]CategoryPrinter, len(routes))
+	for _, route := range routes {
+		byCategory[route.CategoryID] = route
+	}
+
+	var stations []*kitchenStation
+	byKey := map[string]*kitchenStation{}
+	for _, item := range items {
+		name, printer := defaultKitchenStation, settings.KitchenPrinter
+		if route, ok := byCategory[productCategories[item.ProductID]]; ok && route.Printer != "" {
+			name, printer = route.Station, route.Printer
+			if name == "" && route.Category != nil {
+				name = route.Category.Name
+			}
+		}
+		if printer == "" {
+			continue
+		}
+
+		key := name + "\x00" + printer
+		station := byKey[key]
+		if station == nil {
+			station = &kitchenStation{Name: name, Printer: printer}
+			byKey[key] = station
+			stations = append(stations, station)
+		}
+		station.Items = append(station.Items, item)
+	}
+	return stations
+}
+
+// buildKitchenTicket arma la comanda ESC/POS de una estación. Una adición lleva
+// solo los productos agregados a una orden que ya estaba en cocina.
+func buildKitchenTicket(order Order, station string, items []OrderItem, addition bool, at time.Time) []byte {
+	p := newESCPOS().Center().Bold(true).Line(station)
+	if addition {
+		p.Double(true).Line("*** ADICIÓN ***").Double(false)
+	}
+	p.Double(true).Line("MESA " + strconv.Itoa(order.TableNum)).Double(false).Bold(false)
+
+	p.Left().Columns(fmt.Sprintf("Orden #%d", order.ID), at.Format("15:04"), receiptColumns).
+		Separator(receiptColumns)
+	for _, item := range items {
+		p.Bold(true)
+		for _, line := range wrapText(fmt.Sprintf("%d x %s", item.Quantity, item.ProductName), receiptColumns) {
+			p.Line(line)
+		}
+		if item.ModifierNames != "" {
+			for _, line := range wrapText(item.ModifierNames, receiptColumns-5) {
+				p.Line("   + " + line)
+			}
+		}
+		p.Bold(false)
+		if item.Seat > 0 {
+			p.Line("   Asiento " + strconv.Itoa(item.Seat))
+		}
+		if item.Notes != "" {
+			for _, line := range wrapText(item.Notes, receiptColumns-5) {
+				p.Line("   > " + line)
+			}
+		}
+	}
+	return p.Separator(receiptColumns).Cut().Bytes()
+}
+
+// printKitchenTickets envía en segundo plano las comandas de los ítems a la impresora de cada estación
+func printKitchenTickets(order Order, items []OrderItem, addition bool) {
+	if len(items) == 0 {
+		return
+	}
+	settings := loadSettings(db)
+	var routes []CategoryPrinter
+	db.Preload("Category").Find(&routes)
+	productIDs := make([]uint, 0, len(items))
+	for _, item := range items {
+		productIDs = append(productIDs, item.ProductID)
+	}
+	var products []Product
+	db.Select("id", "category_id").Where("id IN ?", productIDs).Find(&products)
+	productCategories := make(map[uint]uint, len(products))
+	for _, product := range products {
+		if product.CategoryID != nil {
+			productCategories[product.ID] = *product.CategoryID
+		}
+	}
+	stations := routeKitchenItems(items, settings, routes, productCategories)
+	if len(stations) == 0 {
+		return
+	}
+
+	now := time.Now()
+	go func() {
+		for _, station := range stations {
+			ticket := buildKitchenTicket(order, station.Name, station.Items, addition, now)
+			if err := sendToPrinter(station.Printer, ticket); err != nil {
+				log.Printf("Error al imprimir la comanda de la orden #%d en %s: %v", order.ID, station.Name, err)
+			}
+		}
+	}()
+}
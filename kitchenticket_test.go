@@ -0,0 +1,28 @@
+package main
+
+import "testing"
+
+func TestRouteKitchenItemsByCategoryID(t *testing.T) {
+	bar := Category{ID: 2, Name: "Bebidas"}
+	routes := []CategoryPrinter{{CategoryID: bar.ID, Category: &bar, Printer: "192.168.1.52"}}
+	settings := Settings{KitchenPrinter: "192.168.1.51"}
+
+	// El ítem guarda el nombre viejo de la categoría: la ruta sigue al producto por ID
+	items := []OrderItem{
+		{ProductID: 10, ProductName: "Limonada", Category: "Refrescos"},
+		{ProductID: 11, ProductName: "Tacos", Category: "Comida"},
+		{ProductID: 12, ProductName: "Agua", Category: "Refrescos"},
+	}
+	productCategories := map[uint]uint{10: bar.ID, 11: 1, 12: bar.ID}
+
+	stations := routeKitchenItems(items, settings, routes, productCategories)
+	if len(stations) != 2 {
+		t.Fatalf("se esperaban 2 estaciones, hay %d", len(stations))
+	}
+	if got := stations[0]; got.Name != "Bebidas" || got.Printer != "192.168.1.52" || len(got.Items) != 2 {
+		t.Errorf("estación de barra = %q en %q con %d ítems", got.Name, got.Printer, len(got.Items))
+	}
+	if got := stations[1]; got.Name != defaultKitchenStation || got.Printer != settings.KitchenPrinter || len(got.Items) != 1 {
+		t.Errorf("estación de cocina = %q en %q con %d ítems", got.Name, got.Printer, len(got.Items))
+	}
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Estados de un EmailDelivery
+const (
+	EmailPending = "pending"
+	EmailSent    = "sent"
+	EmailFailed  = "failed"
+)
+
+// Reintentos de la cola de correos: la espera se duplica en cada intento fallido
+const (
+	maxEmailAttempts  = 5
+	emailRetryBackoff = time.Minute
+	emailPollInterval = 30 * time.Second
+)
+
+// EmailMessage es un correo con versión HTML y de texto plano
+type EmailMessage struct {
+	From    string
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// Mailer es el transporte que entrega los correos
+type Mailer interface {
+	Send(msg EmailMessage) error
+}
+
+// newMailer crea el transporte a partir de la configuración. Es una variable para poder
+// reemplazarlo, por ejemplo por un servidor SMTP falso en pruebas.
+var newMailer = func(settings Settings) Mailer {
+	return smtpMailer{
+		host:        settings.SMTPHost,
+		port:        settings.SMTPPort,
+		username:    settings.SMTPUsername,
+		password:    settings.SMTPPassword,
+		implicitTLS: settings.SMTPPort == smtpsPort,
+	}
+}
+
+// Puerto SMTP con TLS implícito (SMTPS): la conexión es cifrada desde el inicio
+const smtpsPort = 465
+
+// Tiempo máximo para conectar y para toda la conversación con el servidor SMTP, para que un
+// servidor que no responde no bloquee la cola de correos
+const smtpTimeout = 30 * time.Second
+
+// smtpMailer envía por SMTP. Con implicitTLS la conexión se abre con TLS (puerto 465);
+// si no, se usa STARTTLS cuando el servidor lo ofrece (puerto 587).
+type smtpMailer struct {
+	host        string
+	port        int
+	username    string
+	password    string
+	implicitTLS bool
+	tlsConfig   *tls.Config   // nil = verificar el certificado de host con las raíces del sistema
+	timeout     time.Duration // 0 = smtpTimeout
+}
+
+func (m smtpMailer) Send(msg EmailMessage) error {
+	if m.host == "" {
+		return errors.New("no hay servidor SMTP configurado")
+	}
+	port := m.port
+	if port == 0 {
+		port = 587
+	}
+	timeout := m.timeout
+	if timeout == 0 {
+		timeout = smtpTimeout
+	}
+	config := m.tlsConfig
+	if config == nil {
+		config = &tls.Config{ServerName: m.host}
+	}
+
+	from, err := mail.ParseAddress(msg.From)
+	if err != nil {
+		return fmt.Errorf("remitente inválido: %w", err)
+	}
+	data, err := msg.Bytes()
+	if err != nil {
+		return err
+	}
+
+	conn, err := (&net.Dialer{Timeout: timeout}).Dial("tcp", net.JoinHostPort(m.host, strconv.Itoa(port)))
+	if err != nil {
+		return err
+	}
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		conn.Close()
+		return err
+	}
+	if m.implicitTLS {
+		conn = tls.Client(conn, config)
+	}
+	client, err := smtp.NewClient(conn, m.host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	// Igual que smtp.SendMail: STARTTLS y autenticación si el servidor las ofrece
+	if !m.implicitTLS {
+		if ok, _ := client.Extension("STARTTLS"); ok {
+			if err := client.StartTLS(config); err != nil {
+				return err
+			}
+		}
+	}
+	if m.username != "" {
+		if ok, _ := client.Extension("AUTH"); ok {
+			if err := client.Auth(smtp.PlainAuth("", m.username, m.password, m.host)); err != nil {
+				return err
+			}
+		}
+	}
+	if err := client.Mail(from.Address); err != nil {
+		return err
+	}
+	if err := client.Rcpt(msg.To); err != nil {
+		return err
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// Bytes arma el mensaje MIME multipart/alternative (texto plano y HTML)
+func (msg EmailMessage) Bytes() ([]byte, error) {
+	var body bytes.Buffer
+	parts := multipart.NewWriter(&body)
+	for _, part := range []struct{ contentType, content string }{
+		{"text/plain; charset=utf-8", msg.Text},
+		{"text/html; charset=utf-8", msg.HTML},
+	} {
+		w, err := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		qp := quotedprintable.NewWriter(w)
+		if _, err := qp.Write([]byte(part.content)); err != nil {
+			return nil, err
+		}
+		if err := qp.Close(); err != nil {
+			return nil, err
+		}
+	}
+	if err := parts.Close(); err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "From: %s\r\n", msg.From)
+	fmt.Fprintf(&out, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&out, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&out, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	out.WriteString("MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&out, "Content-Type: multipart/alternative; boundary=%q\r\n\r\n", parts.Boundary())
+	out.Write(body.Bytes())
+	return out.Bytes(), nil
+}
+
+// Cola de envíos pendientes; si se llena, el ciclo periódico de emailWorker los recoge igual
+var emailQueue = make(chan uint, 100)
+
+// queueEmailDelivery pide enviar cuanto antes un EmailDelivery pendiente
+func queueEmailDelivery(id uint) {
+	select {
+	case emailQueue <- id:
+	default:
+	}
+}
+
+// emailWorker envía los correos en cola y reintenta los pendientes, incluidos los que
+// quedaron de antes de reiniciar el servidor. Procesa de a uno para no enviar dos veces.
+func emailWorker() {
+	ticker := time.NewTicker(emailPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case id := <-emailQueue:
+			deliverEmail(id)
+		case <-ticker.C:
+			var due []EmailDelivery
+			db.Where("status = ? AND next_attempt_at <= ?", EmailPending, time.Now()).Order("id").Find(&due)
+			for _, delivery := range due {
+				deliverEmail(delivery.ID)
+			}
+		}
+	}
+}
+
+// deliverEmail hace un intento de envío y guarda el resultado
+func deliverEmail(id uint) {
+	var delivery EmailDelivery
+	if err := db.First(&delivery, id).Error; err != nil {
+		return
+	}
+	if delivery.Status != EmailPending || delivery.NextAttemptAt.After(time.Now()) {
+		return
+	}
+
+	err := sendReceiptEmail(delivery)
+	delivery.recordAttempt(err, time.Now())
+	if err != nil {
+		log.Printf("Error al enviar el recibo de la orden #%d a %s (intento %d): %v", delivery.OrderID, delivery.To, delivery.Attempts, err)
+	}
+	db.Save(&delivery)
+}
+
+// recordAttempt anota el resultado de un intento de envío: enviado, o pendiente con una espera
+// que se duplica en cada fallo hasta maxEmailAttempts, cuando queda fallido
+func (delivery *EmailDelivery) recordAttempt(err error, now time.Time) {
+	delivery.Attempts++
+	switch {
+	case err == nil:
+		delivery.Status = EmailSent
+		delivery.SentAt = &now
+		delivery.LastError = ""
+	case delivery.Attempts >= maxEmailAttempts:
+		delivery.Status = EmailFailed
+		delivery.LastError = err.Error()
+	default:
+		delivery.LastError = err.Error()
+		delivery.NextAttemptAt = now.Add(emailRetryBackoff << (delivery.Attempts - 1))
+	}
+}
+
+// sendReceiptEmail envía el recibo de la orden del envío con el transporte configurado
+func sendReceiptEmail(delivery EmailDelivery) error {
+	var order Order
+	if err := preloadReportOrder(db).First(&order, delivery.OrderID).Error; err != nil {
+		return err
+	}
+	settings := loadSettings(db)
+	msg, err := buildReceiptEmail(order, settings)
+	if err != nil {
+		return err
+	}
+	msg.To = delivery.To
+	return newMailer(settings).Send(msg)
+}
+
+// emailDeliveriesData arma los datos del partial de recibos enviados por correo de una orden
+func emailDeliveriesData(orderID uint) fiber.Map {
+	var deliveries []EmailDelivery
+	db.Where("order_id = ?", orderID).Order("id DESC").Find(&deliveries)
+	return fiber.Map{
+		"OrderID":    orderID,
+		"Deliveries": deliveries,
+	}
+}
+
+// RetryEmailDelivery vuelve a poner en cola un recibo cuyo envío falló
+func RetryEmailDelivery(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).SendString("ID inválido")
+	}
+
+	var delivery EmailDelivery
+	if result := db.First(&delivery, id); result.Error != nil {
+		return c.Status(fiber.StatusNotFound).SendString("Envío no encontrado")
+	}
+	if delivery.Status != EmailFailed {
+		return Error(c, "Solo se pueden reintentar los envíos fallidos", fiber.StatusConflict)
+	}
+
+	delivery.Status = EmailPending
+	delivery.Attempts = 0
+	delivery.NextAttemptAt = time.Now()
+	if err := db.Save(&delivery).Error; err != nil {
+		return Error(c, "Error al reintentar el envío", fiber.StatusInternalServerError)
+	}
+	queueEmailDelivery(delivery.ID)
+
+	setTrigger(c, fiber.Map{"showToast": "Reintentando el envío a " + delivery.To, "toastType": "success"})
+	return c.Render("partials/email_deliveries", emailDeliveriesData(delivery.OrderID), "")
+}
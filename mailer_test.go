@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"math/big"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeSMTP es un servidor SMTP mínimo para pruebas: acepta AUTH PLAIN con un único
+// usuario y guarda los mensajes recibidos. Con rejectMail responde 451 a MAIL FROM.
+type fakeSMTP struct {
+	username, password string
+	rejectMail         bool
+
+	mu       sync.Mutex
+	authed   []string
+	messages []string
+}
+
+// start escucha en un puerto local, con TLS si config no es nil, y devuelve el puerto
+func (s *fakeSMTP) start(t *testing.T, config *tls.Config) int {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("no se pudo abrir el listener: %v", err)
+	}
+	if config != nil {
+		ln = tls.NewListener(ln, config)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+func (s *fakeSMTP) serve(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	r := bufio.NewReader(conn)
+	reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+
+	reply("220 fake ESMTP")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		command := strings.TrimRight(line, "\r\n")
+		verb := strings.ToUpper(strings.SplitN(command, " ", 2)[0])
+		switch verb {
+		case "EHLO", "HELO":
+			reply("250-fake")
+			reply("250 AUTH PLAIN")
+		case "AUTH":
+			fields := strings.Fields(command)
+			decoded, _ := base64.StdEncoding.DecodeString(fields[len(fields)-1])
+			parts := strings.Split(string(decoded), "\x00")
+			if len(parts) == 3 && parts[1] == s.username && parts[2] == s.password {
+				s.mu.Lock()
+				s.authed = append(s.authed, parts[1])
+				s.mu.Unlock()
+				reply("235 autenticado")
+			} else {
+				reply("535 credenciales inválidas")
+			}
+		case "MAIL":
+			if s.rejectMail {
+				reply("451 intente más tarde")
+				continue
+			}
+			reply("250 ok")
+		case "RCPT", "RSET", "NOOP":
+			reply("250 ok")
+		case "DATA":
+			reply("354 fin con <CRLF>.<CRLF>")
+			var data strings.Builder
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				data.WriteString(line)
+			}
+			s.mu.Lock()
+			s.messages = append(s.messages, data.String())
+			s.mu.Unlock()
+			reply("250 recibido")
+		case "QUIT":
+			reply("221 adiós")
+			return
+		default:
+			reply("502 no implementado")
+		}
+	}
+}
+
+// received devuelve cuántos mensajes se entregaron
+func (s *fakeSMTP) received() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.messages)
+}
+
+func testEmail() EmailMessage {
+	return EmailMessage{
+		From:    "Restaurante <recibos@ejemplo.com>",
+		To:      "cliente@ejemplo.com",
+		Subject: "Recibo de la orden #5",
+		Text:    "Gracias por su visita",
+		HTML:    "<p>Gracias por su visita</p>",
+	}
+}
+
+func TestSMTPMailerSendsWithAuth(t *testing.T) {
+	server := &fakeSMTP{username: "recibos", password: "secreto"}
+	port := server.start(t, nil)
+
+	mailer := newMailer(Settings{SMTPHost: "127.0.0.1", SMTPPort: port, SMTPUsername: "recibos", SMTPPassword: "secreto"})
+	if err := mailer.Send(testEmail()); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if len(server.authed) != 1 || server.authed[0] != "recibos" {
+		t.Errorf("autenticaciones = %v, se esperaba una de recibos", server.authed)
+	}
+	if len(server.messages) != 1 {
+		t.Fatalf("mensajes recibidos = %d, se esperaba 1", len(server.messages))
+	}
+	for _, want := range []string{"To: cliente@ejemplo.com", "Subject: Recibo de la orden #5", "multipart/alternative", "Gracias por su visita"} {
+		if !strings.Contains(server.messages[0], want) {
+			t.Errorf("el mensaje no contiene %q", want)
+		}
+	}
+}
+
+func TestSMTPMailerRejectsBadCredentials(t *testing.T) {
+	server := &fakeSMTP{username: "recibos", password: "secreto"}
+	port := server.start(t, nil)
+
+	mailer := newMailer(Settings{SMTPHost: "127.0.0.1", SMTPPort: port, SMTPUsername: "recibos", SMTPPassword: "otra"})
+	if err := mailer.Send(testEmail()); err == nil {
+		t.Fatal("se esperaba un error de autenticación")
+	}
+	if server.received() != 0 {
+		t.Errorf("no debía entregarse ningún mensaje")
+	}
+}
+
+func TestSMTPMailerImplicitTLS(t *testing.T) {
+	certificate, pool := testCertificate(t)
+	server := &fakeSMTP{username: "recibos", password: "secreto"}
+	port := server.start(t, &tls.Config{Certificates: []tls.Certificate{certificate}})
+
+	mailer := smtpMailer{
+		host: "127.0.0.1", port: port, username: "recibos", password: "secreto",
+		implicitTLS: true, tlsConfig: &tls.Config{ServerName: "127.0.0.1", RootCAs: pool},
+	}
+	if err := mailer.Send(testEmail()); err != nil {
+		t.Fatalf("Send con TLS implícito: %v", err)
+	}
+	if received := server.received(); received != 1 {
+		t.Fatalf("mensajes recibidos = %d, se esperaba 1", received)
+	}
+
+	if mailer, ok := newMailer(Settings{SMTPHost: "smtp.ejemplo.com", SMTPPort: 465}).(smtpMailer); !ok || !mailer.implicitTLS {
+		t.Error("el puerto 465 debe usar TLS implícito")
+	}
+}
+
+func TestSMTPMailerTimesOut(t *testing.T) {
+	// Un servidor que acepta la conexión y nunca responde
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("no se pudo abrir el listener: %v", err)
+	}
+	var mu sync.Mutex
+	var conns []net.Conn
+	t.Cleanup(func() {
+		ln.Close()
+		mu.Lock()
+		defer mu.Unlock()
+		for _, conn := range conns {
+			conn.Close()
+		}
+	})
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			conns = append(conns, conn)
+			mu.Unlock()
+		}
+	}()
+
+	for _, implicitTLS := range []bool{false, true} {
+		mailer := smtpMailer{
+			host: "127.0.0.1", port: ln.Addr().(*net.TCPAddr).Port,
+			implicitTLS: implicitTLS, timeout: 200 * time.Millisecond,
+		}
+		start := time.Now()
+		if err := mailer.Send(testEmail()); err == nil {
+			t.Fatalf("TLS implícito %v: se esperaba un error por tiempo de espera", implicitTLS)
+		}
+		if elapsed := time.Since(start); elapsed > 2*time.Second {
+			t.Errorf("TLS implícito %v: Send tardó %v", implicitTLS, elapsed)
+		}
+	}
+}
+
+func TestEmailDeliveryRetriesUntilFailed(t *testing.T) {
+	server := &fakeSMTP{rejectMail: true}
+	port := server.start(t, nil)
+	mailer := newMailer(Settings{SMTPHost: "127.0.0.1", SMTPPort: port})
+
+	delivery := EmailDelivery{Status: EmailPending, To: "cliente@ejemplo.com"}
+	now := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+	for attempt := 1; attempt <= maxEmailAttempts; attempt++ {
+		err := mailer.Send(testEmail())
+		if err == nil {
+			t.Fatal("el servidor rechaza el remitente: se esperaba un error")
+		}
+		delivery.recordAttempt(err, now)
+
+		if delivery.Attempts != attempt {
+			t.Fatalf("intentos = %d, se esperaba %d", delivery.Attempts, attempt)
+		}
+		if delivery.LastError == "" {
+			t.Errorf("intento %d: falta el último error", attempt)
+		}
+		if attempt < maxEmailAttempts {
+			if delivery.Status != EmailPending {
+				t.Fatalf("intento %d: estado %q, se esperaba pendiente", attempt, delivery.Status)
+			}
+			if wait := delivery.NextAttemptAt.Sub(now); wait != emailRetryBackoff<<(attempt-1) {
+				t.Errorf("intento %d: espera %v, se esperaba %v", attempt, wait, emailRetryBackoff<<(attempt-1))
+			}
+		}
+	}
+	if delivery.Status != EmailFailed {
+		t.Errorf("estado final %q, se esperaba fallido", delivery.Status)
+	}
+
+	// Un envío correcto deja el recibo enviado y limpia el error
+	delivery = EmailDelivery{Status: EmailPending, Attempts: 2, LastError: "timeout"}
+	delivery.recordAttempt(nil, now)
+	if delivery.Status != EmailSent || delivery.SentAt == nil || delivery.LastError != "" {
+		t.Errorf("tras un envío correcto: estado %q, enviado %v, error %q", delivery.Status, delivery.SentAt, delivery.LastError)
+	}
+}
+
+// testCertificate genera un certificado autofirmado para 127.0.0.1 y el pool que lo acepta
+func testCertificate(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "fake smtp"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(leaf)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, pool
+}
@@ -4,7 +4,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"math"
 	"os"
+	"strings"
 	"text/template"
 	"time"
 
@@ -20,6 +22,9 @@ import (
 
 var db *gorm.DB
 
+// Plantillas de la aplicación, también usadas fuera de las peticiones (correos)
+var views *html.Engine
+
 // WebSocket clients and broadcaster
 var orderClients = make(map[*websocket.Conn]bool)
 var kitchenClients = make(map[*websocket.Conn]bool)
@@ -46,12 +51,27 @@ func initDatabase() {
 		log.Fatalf("Error al conectar a la base de datos: %v", err)
 	}
 
+	// Migraciones de esquema que deben correr antes de AutoMigrate
+	if err := runMigrations(true); err != nil {
+		log.Fatalf("Error al aplicar migraciones: %v", err)
+	}
+
 	// Auto-migrar modelos
-	err = db.AutoMigrate(&Product{}, &Category{}, &Order{}, &OrderItem{}, &Settings{}, &Table{}, &Backup{}, &User{})
+	err = db.AutoMigrate(&Product{}, &Category{}, &Order{}, &OrderItem{}, &Settings{}, &Table{}, &Backup{}, &User{}, &OrderEvent{}, &Payment{}, &OrderCheck{}, &CategoryPrinter{}, &EmailDelivery{}, &ModifierGroup{}, &ModifierOption{}, &OrderItemModifier{}, &ComboSlot{}, &Ingredient{}, &RecipeLine{}, &StockMovement{}, &Supplier{}, &PurchaseOrder{}, &PurchaseOrderLine{})
 	if err != nil {
 		log.Fatalf("Error en auto-migración: %v", err)
 	}
 
+	// Migraciones de datos que AutoMigrate no cubre
+	if err := runMigrations(false); err != nil {
+		log.Fatalf("Error al aplicar migraciones: %v", err)
+	}
+
+	// El historial de eventos de las órdenes es de solo inserción
+	if err := db.Exec(orderEventsAppendOnlySQL).Error; err != nil {
+		log.Fatalf("Error al crear el trigger de order_events: %v", err)
+	}
+
 	// Insertar datos de ejemplo si no existen productos
 	var count int64
 	db.Model(&Product{}).Count(&count)
@@ -59,6 +79,13 @@ func initDatabase() {
 		seedProducts()
 	}
 
+	// Crear el administrador inicial si no hay usuarios
+	var userCount int64
+	db.Model(&User{}).Count(&userCount)
+	if userCount == 0 {
+		bootstrapAdmin()
+	}
+
 	// Inicializar configuración si no existe
 	var settings Settings
 	result := db.First(&settings)
@@ -69,7 +96,7 @@ func initDatabase() {
 			Address:        "123 Calle Principal",
 			Phone:          "(555) 123-4567",
 			Email:          "contacto@resto.com",
-			DefaultPrinter: "thermal1",
+			DefaultPrinter: "",
 			AutoPrint:      true,
 			TableCount:     12,
 			DarkMode:       false,
@@ -101,19 +128,47 @@ func initDatabase() {
 // seedProducts inserta productos de ejemplo en la base de datos
 func seedProducts() {
 	products := []Product{
-		{Name: "Hamburguesa Clásica", Description: "Carne de res, lechuga, tomate y mayonesa", Price: 8.99, Category: "Hamburguesas"},
-		{Name: "Hamburguesa con Queso", Description: "Carne de res, queso cheddar, lechuga, tomate", Price: 9.99, Category: "Hamburguesas"},
-		{Name: "Pizza Margarita", Description: "Salsa de tomate, queso mozzarella y albahaca", Price: 10.99, Category: "Pizzas"},
-		{Name: "Pizza Pepperoni", Description: "Salsa de tomate, queso mozzarella y pepperoni", Price: 12.99, Category: "Pizzas"},
-		{Name: "Ensalada César", Description: "Lechuga romana, crutones, parmesano y aderezo césar", Price: 7.99, Category: "Ensaladas"},
-		{Name: "Papas Fritas", Description: "Papas fritas crujientes con sal", Price: 3.99, Category: "Acompañamientos"},
-		{Name: "Refresco", Description: "Variedad de refrescos", Price: 2.50, Category: "Bebidas"},
-		{Name: "Agua Mineral", Description: "Agua mineral con o sin gas", Price: 1.99, Category: "Bebidas"},
+		{Name: "Hamburguesa Clásica", Description: "Carne de res, lechuga, tomate y mayonesa", Price: 899, Category: "Hamburguesas"},
+		{Name: "Hamburguesa con Queso", Description: "Carne de res, queso cheddar, lechuga, tomate", Price: 999, Category: "Hamburguesas"},
+		{Name: "Pizza Margarita", Description: "Salsa de tomate, queso mozzarella y albahaca", Price: 1099, Category: "Pizzas"},
+		{Name: "Pizza Pepperoni", Description: "Salsa de tomate, queso mozzarella y pepperoni", Price: 1299, Category: "Pizzas"},
+		{Name: "Ensalada César", Description: "Lechuga romana, crutones, parmesano y aderezo césar", Price: 799, Category: "Ensaladas"},
+		{Name: "Papas Fritas", Description: "Papas fritas crujientes con sal", Price: 399, Category: "Acompañamientos"},
+		{Name: "Refresco", Description: "Variedad de refrescos", Price: 250, Category: "Bebidas"},
+		{Name: "Agua Mineral", Description: "Agua mineral con o sin gas", Price: 199, Category: "Bebidas"},
 	}
 
 	for _, product := range products {
+		category, err := findOrCreateCategory(db, product.Category)
+		if err != nil {
+			log.Printf("Error al crear la categoría %s: %v", product.Category, err)
+			continue
+		}
+		product.CategoryID = &category.ID
 		db.Create(&product)
 	}
+
+	// Combo de ejemplo: hamburguesa fija más un acompañamiento y una bebida a elegir
+	category, err := findOrCreateCategory(db, "Combos")
+	if err != nil {
+		log.Printf("Error al crear la categoría Combos: %v", err)
+		return
+	}
+	combo := Product{Name: "Combo Clásico", Description: "Hamburguesa clásica, acompañamiento y bebida", Price: 1299, Category: category.Name, CategoryID: &category.ID}
+	if err := db.Create(&combo).Error; err != nil {
+		log.Printf("Error al crear el combo de ejemplo: %v", err)
+		return
+	}
+	var burger Product
+	var sides, drinks Category
+	db.Where("name = ?", "Hamburguesa Clásica").First(&burger)
+	db.Where("name = ?", "Acompañamientos").First(&sides)
+	db.Where("name = ?", "Bebidas").First(&drinks)
+	db.Create(&[]ComboSlot{
+		{ComboID: combo.ID, Name: "Hamburguesa", ProductID: &burger.ID, Position: 1},
+		{ComboID: combo.ID, Name: "Acompañamiento", CategoryID: &sides.ID, Position: 2},
+		{ComboID: combo.ID, Name: "Bebida", CategoryID: &drinks.ID, Position: 3},
+	})
 }
 
 func wsOrders(c *websocket.Conn) {
@@ -159,6 +214,42 @@ func wsBroadcaster() {
 	}
 }
 
+// Margen para los campos de texto que acompañan a una imagen en el mismo formulario
+const uploadFormOverhead = 1 << 20
+
+// requestBodyLimit devuelve el tamaño máximo del cuerpo de una petición: el general de Fiber,
+// salvo en las rutas que reciben imágenes o el archivo de importación de configuración
+func requestBodyLimit(method, path string) int {
+	switch {
+	case method == fiber.MethodPost && path == "/settings/import":
+		return maxImportSize + uploadFormOverhead
+	case method == fiber.MethodPost && path == "/products",
+		method == fiber.MethodPut && strings.HasPrefix(path, "/products/"),
+		method == fiber.MethodPut && path == "/settings/restaurant":
+		return maxImageSize + uploadFormOverhead
+	}
+	return fiber.DefaultBodyLimit
+}
+
+// limitRequestBody rechaza los cuerpos que superan el límite de la ruta antes de leerlos.
+// El cuerpo llega como stream (StreamRequestBody), así que sin este middleware no habría límite.
+// Al rechazar se cierra la conexión, porque el resto del cuerpo queda sin leer.
+func limitRequestBody(c *fiber.Ctx) error {
+	status := 0
+	switch length := c.Request().Header.ContentLength(); {
+	case length == -1:
+		// Transfer-Encoding: chunked; los formularios del navegador siempre envían Content-Length
+		status = fiber.StatusLengthRequired
+	case length > requestBodyLimit(c.Method(), c.Path()):
+		status = fiber.StatusRequestEntityTooLarge
+	}
+	if status != 0 {
+		c.Context().SetConnectionClose()
+		return c.SendStatus(status)
+	}
+	return c.Next()
+}
+
 func main() {
 	// Cargar variables de entorno
 	if err := godotenv.Load(); err != nil {
@@ -215,17 +306,25 @@ func main() {
 		"subtract": func(a, b int) int { // Añadimos la función "subtract"
 			return a - b
 		},
-		"mul": func(a float64, b int) float64 {
-			return a * float64(b)
+		"mul": func(amount Money, quantity int) Money {
+			return amount.Mul(quantity)
 		},
+		"formatRate":     formatRate,
+		"formatQuantity": formatQuantity,
+		"stockReason":    func(reason string) string { return stockReasonLabels[reason] },
+		"thumbnail":      thumbnailPath,
 		"div": func(a, b int) int {
 			if b == 0 {
 				return 0
 			}
 			return a / b
 		},
-		"multiply": func(a float64, b int) float64 {
-			return a * float64(b)
+		// Duraciones en segundos para formatDuration y las gráficas de cocina
+		"durationSeconds": func(seconds int) float64 {
+			return float64(seconds)
+		},
+		"minutes": func(seconds float64) float64 {
+			return math.Round(seconds/60*10) / 10
 		},
 		"truncate": func(s string, length int) string {
 			if len(s) <= length {
@@ -253,6 +352,10 @@ func main() {
 		"float64": func(i int) float64 {
 			return float64(i)
 		},
+		// Etiqueta en español para los estados de una orden
+		"statusLabel": statusLabel,
+		// Etiqueta en español para los medios de pago
+		"paymentMethodLabel": paymentMethodLabel,
 		// Añadir la función de porcentaje para calcular progreso de órdenes
 		"percentage": func(part, total int) int {
 			if total == 0 {
@@ -266,100 +369,212 @@ func main() {
 	if err := engine.Load(); err != nil {
 		log.Fatalf("Error cargando plantillas: %v", err)
 	}
+	views = engine
 
 	// Crear aplicación Fiber
 	app := fiber.New(fiber.Config{
-		Views:       engine,
-		ViewsLayout: "layouts/main",
+		Views:             engine,
+		ViewsLayout:       "layouts/main",
+		PassLocalsToViews: true,
+		// El cuerpo se lee bajo demanda para aplicar un límite por ruta en limitRequestBody;
+		// los formularios multipart se leen al pedirlos, después de comprobar el límite
+		StreamRequestBody:            true,
+		DisablePreParseMultipartForm: true,
 	})
 
 	// Middleware
 	app.Use(logger.New())
 	app.Use(recover.New())
+	app.Use(limitRequestBody)
 
 	app.Use(func(c *fiber.Ctx) error {
 		c.Locals("CurrentTime", time.Now().Format("02/01/2006 15:04"))
 		return c.Next()
 	})
 
+	// Imágenes subidas (públicas): cada archivo tiene un nombre único, así que se cachean un año
+	app.Static("/static/uploads", uploadDir, fiber.Static{
+		MaxAge: 365 * 24 * 60 * 60,
+	})
+
+	// Rutas de autenticación (públicas)
+	app.Get("/login", LoginPage)
+	app.Post("/login", Login)
+	app.Post("/logout", Logout)
+
+	// A partir de aquí todas las rutas requieren sesión
+	app.Use(RequireAuth)
+
+	// Permisos por rol (los administradores tienen acceso a todo)
+	adminOnly := RequireRole(RoleAdmin)
+	waiters := RequireRole(RoleWaiter)
+	cooks := RequireRole(RoleCook)
+	staff := RequireRole(RoleWaiter, RoleCook)
+
 	// Rutas del Dashboard
-	app.Get("/", DashboardHandler)
+	app.Get("/", adminOnly, DashboardHandler)
 	// Rutas de Productos
-	app.Get("/products", GetProducts)
-	app.Get("/products/category/:category", GetProductsByCategory)
-	app.Get("/products/form", GetProductForm)
-	app.Post("/products", CreateProduct)
-	app.Put("/products/:id", UpdateProduct)
-	app.Delete("/products/:id", DeleteProduct)
-	app.Get("/products/:id/edit", GetProductEditForm)
+	app.Get("/products", adminOnly, GetProducts)
+	app.Get("/products/category/:category", adminOnly, GetProductsByCategory)
+	app.Get("/products/form", adminOnly, GetProductForm)
+	app.Post("/products", adminOnly, CreateProduct)
+	app.Put("/products/:id", adminOnly, UpdateProduct)
+	app.Delete("/products/:id", adminOnly, DeleteProduct)
+	app.Get("/products/:id/edit", adminOnly, GetProductEditForm)
+	app.Get("/products/:id/modifiers", adminOnly, GetProductModifiers)
+	app.Get("/products/:id/modifiers/picker", waiters, GetProductModifierPicker)
+	app.Post("/products/:id/modifiers", adminOnly, CreateModifierGroup)
+	app.Delete("/modifier-groups/:id", adminOnly, DeleteModifierGroup)
+	app.Post("/modifier-groups/:id/options", adminOnly, CreateModifierOption)
+	app.Delete("/modifier-options/:id", adminOnly, DeleteModifierOption)
+	app.Get("/products/:id/combo", adminOnly, GetProductCombo)
+	app.Post("/products/:id/combo", adminOnly, CreateComboSlot)
+	app.Delete("/combo-slots/:id", adminOnly, DeleteComboSlot)
+	app.Get("/products/:id/recipe", adminOnly, GetProductRecipe)
+	app.Post("/products/:id/recipe", adminOnly, CreateRecipeLine)
+	app.Delete("/recipe-lines/:id", adminOnly, DeleteRecipeLine)
 
 	// En la sección de rutas
 
 	// Rutas de Categorías
-	app.Get("/forms/category", GetCategoryForm)
-	app.Post("/categories", CreateCategory)
-	app.Get("/categories/list", GetCategoryList)
+	app.Get("/forms/category", adminOnly, GetCategoryForm)
+	app.Post("/categories", adminOnly, CreateCategory)
+	app.Get("/categories/list", adminOnly, GetCategoryList)
+	app.Get("/categories/:id/edit", adminOnly, GetCategoryEditForm)
+	app.Put("/categories/:id", adminOnly, UpdateCategory)
+	app.Delete("/categories/:id", adminOnly, DeleteCategory)
+	app.Post("/categories/:id/move", adminOnly, MoveCategory)
 
 	// Rutas para órdenes
-	app.Get("/orders", OrdersHandler)
-	app.Post("/orders/create", CreateOrder)
-	app.Get("/order/:id", GetOrder)
-	app.Post("/order/:id/complete", CompleteOrder)
-	app.Post("/order/:id/process", ProcessOrder) // Nueva ruta para procesar la orden
-	app.Post("/order/:id/cancel", CancelOrder)   // Ruta para cancelar orden
-	app.Post("/order/:id/item", AddItemToOrder)
-	app.Put("/order/item/:id", UpdateOrderItem)
-	app.Delete("/order/:id/item/:itemId", RemoveItemFromOrder)
-	app.Put("/order/:id/notes", UpdateOrderNotes)
-	app.Get("/orders/metrics", GetOrderMetrics)
+	app.Get("/orders", waiters, OrdersHandler)
+	app.Post("/orders/create", waiters, CreateOrder)
+	app.Get("/order/:id", waiters, GetOrder)
+	app.Post("/order/:id/complete", waiters, CompleteOrder)
+	app.Post("/order/:id/process", waiters, ProcessOrder) // Nueva ruta para procesar la orden
+	app.Post("/order/:id/cancel", waiters, CancelOrder)   // Ruta para cancelar orden
+	app.Post("/order/:id/item", waiters, AddItemToOrder)
+	app.Put("/order/item/:id", waiters, UpdateOrderItem)
+	app.Delete("/order/:id/item/:itemId", waiters, RemoveItemFromOrder)
+	app.Put("/order/:id/notes", waiters, UpdateOrderNotes)
+	app.Put("/order/:id/tip", waiters, UpdateOrderTip)
+	app.Post("/order/:id/print", waiters, PrintOrder)
+	app.Post("/order/:id/email", waiters, EmailOrder)
+	app.Post("/email-deliveries/:id/retry", waiters, RetryEmailDelivery)
+	app.Post("/order/:id/payments", waiters, AddOrderPayment)
+	app.Get("/order/:id/split", waiters, GetOrderSplit)
+	app.Post("/order/:id/split", waiters, SplitOrder)
+	app.Delete("/order/:id/split", waiters, UnsplitOrder)
+	app.Post("/order/:id/transfer", waiters, TransferOrder)
+	app.Post("/order/:id/merge", waiters, MergeOrders)
+	app.Post("/payments/:id/refund", adminOnly, RefundPayment)
+	app.Get("/order/:id/timeline", waiters, GetOrderTimeline)
+	app.Get("/orders/metrics", adminOnly, GetOrderMetrics)
 	// Ruta para marcar orden como 'ready'
-	app.Post("/order/:id/ready", SetOrderReady)
+	app.Post("/order/:id/ready", waiters, SetOrderReady)
 	// Ruta para marcar orden como 'to_pay'
-	app.Post("/order/:id/to_pay", SetOrderToPay)
+	app.Post("/order/:id/to_pay", waiters, SetOrderToPay)
 	// Ruta para marcar orden como 'completed' desde 'to_pay'
-	app.Post("/order/:id/complete_pay", SetOrderCompletedFromToPay)
+	app.Post("/order/:id/complete_pay", waiters, SetOrderCompletedFromToPay)
 
 	// Rutas de Cocina
-	app.Get("/kitchen", KitchenHandler)
-	app.Get("/kitchen/orders", GetKitchenOrders)
-	app.Put("/kitchen/items/:id/toggle", ToggleItemStatus)
-	app.Post("/kitchen/order/:id/complete", KitchenCompleteOrder)
-	app.Get("/kitchen/order/:id/status", GetOrderCompletionStatus)
-	app.Get("/kitchen/stats", GetKitchenStats)
+	app.Get("/kitchen", cooks, KitchenHandler)
+	app.Get("/kitchen/orders", cooks, GetKitchenOrders)
+	app.Put("/kitchen/items/:id/toggle", cooks, ToggleItemStatus)
+	app.Post("/kitchen/order/:id/complete", cooks, KitchenCompleteOrder)
+	app.Get("/kitchen/order/:id/status", staff, GetOrderCompletionStatus) // También lo usa la vista de la orden
+	app.Get("/kitchen/stats", cooks, GetKitchenStats)
 	// Rutas de Menu
-	app.Get("/menu", MenuHandler)
+	app.Get("/menu", adminOnly, MenuHandler)
 
 	// Rutas de Historial
-	app.Get("/history", HistoryHandler)
-	app.Get("/history/today", GetTodayHistory)
-	app.Get("/history/week", GetWeekHistory)
-	app.Get("/history/month", GetMonthHistory)
-	app.Get("/history/custom", GetCustomHistory)
-	app.Get("/history/report/:id", GenerateOrderReport)
+	app.Get("/history", adminOnly, HistoryHandler)
+	app.Get("/history/today", adminOnly, GetTodayHistory)
+	app.Get("/history/week", adminOnly, GetWeekHistory)
+	app.Get("/history/month", adminOnly, GetMonthHistory)
+	app.Get("/history/custom", adminOnly, GetCustomHistory)
+	app.Get("/history/report", adminOnly, GenerateRangeReport)
+	app.Get("/history/report/:id", adminOnly, GenerateOrderReport)
+	app.Get("/history/report/:id/view", adminOnly, ViewOrderReport)
 
 	// Rutas de Configuración
-	app.Get("/settings", SettingsHandler)
-	app.Put("/settings/restaurant", UpdateRestaurantSettings)
-	app.Put("/settings/printer", UpdatePrinterSettings)
-	app.Put("/settings/tables", UpdateTableSettings)
-	app.Put("/settings/app", UpdateAppSettings)
-	app.Post("/backup", CreateBackup)
-	app.Get("/backup/list", GetBackupList)
-	app.Get("/backup/:id/download", DownloadBackup)
+	app.Get("/settings", adminOnly, SettingsHandler)
+	app.Put("/settings/restaurant", adminOnly, UpdateRestaurantSettings)
+	app.Put("/settings/printer", adminOnly, UpdatePrinterSettings)
+	app.Post("/settings/printer/categories", adminOnly, SaveCategoryPrinter)
+	app.Delete("/settings/printer/categories/:id", adminOnly, DeleteCategoryPrinter)
+	app.Put("/settings/tables", adminOnly, UpdateTableSettings)
+	app.Put("/settings/app", adminOnly, UpdateAppSettings)
+	app.Put("/settings/email", adminOnly, UpdateEmailSettings)
+	app.Put("/settings/backup", adminOnly, UpdateBackupSettings)
+	app.Get("/settings/export", adminOnly, ExportConfiguration)
+	app.Post("/settings/import", adminOnly, ImportConfiguration)
+	app.Post("/backup", adminOnly, CreateBackup)
+	app.Get("/backup/list", adminOnly, GetBackupList)
+	app.Get("/backup/:id/download", adminOnly, DownloadBackup)
+	app.Post("/backup/:id/restore", adminOnly, RestoreBackup)
+	app.Delete("/backup/:id", adminOnly, DeleteBackup)
 
 	// Rutas de Mesas
-	app.Get("/tables", TablesHandler)
-	app.Post("/tables", CreateTable)
-	app.Delete("/tables/:id", DeleteTable)
-	app.Post("/tables/reset", ResetTables)
+	app.Get("/tables", adminOnly, TablesHandler)
+	app.Post("/tables", adminOnly, CreateTable)
+	app.Delete("/tables/:id", adminOnly, DeleteTable)
+	app.Post("/tables/reset", adminOnly, ResetTables)
+
+	// Rutas de Usuarios
+	app.Get("/users", adminOnly, UsersHandler)
+	app.Get("/users/form", adminOnly, GetUserForm)
+	app.Post("/users", adminOnly, CreateUser)
+	app.Get("/users/:id/edit", adminOnly, GetUserEditForm)
+	app.Put("/users/:id", adminOnly, UpdateUser)
+	app.Post("/users/:id/toggle", adminOnly, ToggleUserActive)
+	app.Get("/users/:id/password", adminOnly, GetUserPasswordForm)
+	app.Put("/users/:id/password", adminOnly, ResetUserPassword)
+	app.Delete("/users/:id", adminOnly, DeleteUser)
+
+	// Rutas de Inventario
+	app.Get("/inventory", adminOnly, InventoryHandler)
+	app.Get("/inventory/movements", adminOnly, GetStockMovements)
+	app.Get("/inventory/ingredients/form", adminOnly, GetIngredientForm)
+	app.Post("/inventory/ingredients", adminOnly, CreateIngredient)
+	app.Get("/inventory/ingredients/:id/edit", adminOnly, GetIngredientEditForm)
+	app.Put("/inventory/ingredients/:id", adminOnly, UpdateIngredient)
+	app.Get("/inventory/ingredients/:id/adjust", adminOnly, GetIngredientAdjustForm)
+	app.Post("/inventory/ingredients/:id/adjust", adminOnly, AdjustIngredient)
+	app.Delete("/inventory/ingredients/:id", adminOnly, DeleteIngredient)
+	app.Get("/inventory/valuation", adminOnly, ValuationHandler)
+
+	// Rutas de Compras
+	app.Get("/purchasing", adminOnly, PurchasingHandler)
+	app.Get("/suppliers/form", adminOnly, GetSupplierForm)
+	app.Post("/suppliers", adminOnly, CreateSupplier)
+	app.Get("/suppliers/:id/edit", adminOnly, GetSupplierEditForm)
+	app.Put("/suppliers/:id", adminOnly, UpdateSupplier)
+	app.Delete("/suppliers/:id", adminOnly, DeleteSupplier)
+	app.Get("/purchase-orders", adminOnly, GetPurchaseOrders)
+	app.Get("/purchase-orders/form", adminOnly, GetPurchaseOrderForm)
+	app.Post("/purchase-orders", adminOnly, CreatePurchaseOrder)
+	app.Get("/purchase-orders/:id", adminOnly, GetPurchaseOrder)
+	app.Delete("/purchase-orders/:id", adminOnly, DeletePurchaseOrder)
+	app.Post("/purchase-orders/:id/lines", adminOnly, AddPurchaseOrderLine)
+	app.Delete("/purchase-order-lines/:id", adminOnly, DeletePurchaseOrderLine)
+	app.Post("/purchase-orders/:id/send", adminOnly, SendPurchaseOrder)
+	app.Post("/purchase-orders/:id/cancel", adminOnly, CancelPurchaseOrder)
+	app.Get("/purchase-orders/:id/receive", adminOnly, GetPurchaseOrderReceive)
+	app.Post("/purchase-orders/:id/receive", adminOnly, ReceivePurchaseOrder)
 
 	// Rutas WebSocket
-	app.Get("/ws/orders", websocket.New(wsOrders))
-	app.Get("/ws/kitchen", websocket.New(wsKitchen))
+	app.Get("/ws/orders", waiters, websocket.New(wsOrders))
+	app.Get("/ws/kitchen", cooks, websocket.New(wsKitchen))
 
 	// Iniciar broadcaster
 	go wsBroadcaster()
 
+	// Iniciar la cola de correos
+	go emailWorker()
+
+	// Iniciar los respaldos automáticos
+	go backupScheduler()
+
 	// Iniciar servidor
 	port := os.Getenv("PORT")
 	if port == "" {
@@ -376,20 +591,15 @@ func main() {
 func initStaticData() {
 	// Verificar si hay categorías existentes
 	var categoryCount int64
-	db.Model(&Product{}).Distinct().Select("category").Where("category != ''").Count(&categoryCount)
+	db.Model(&Category{}).Count(&categoryCount)
 
 	if categoryCount < 1 {
 		// Insertar categorías básicas si no hay ninguna
 		categories := []string{"Hamburguesas", "Pizzas", "Ensaladas", "Acompañamientos", "Bebidas", "Postres"}
 		for _, cat := range categories {
-			product := Product{
-				Name:        "Categoría: " + cat,
-				Description: "Categoría inicial",
-				Category:    cat,
-				Price:       0.01,
-				IsAvailable: false,
+			if _, err := findOrCreateCategory(db, cat); err != nil {
+				log.Printf("Error al crear la categoría %s: %v", cat, err)
 			}
-			db.Create(&product)
 		}
 		log.Printf("Se crearon %d categorías iniciales", len(categories))
 	}
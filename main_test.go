@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// newLimitedApp arma una aplicación con la misma lectura del cuerpo y límite que main
+func newLimitedApp() *fiber.App {
+	app := fiber.New(fiber.Config{StreamRequestBody: true, DisablePreParseMultipartForm: true})
+	app.Use(limitRequestBody)
+	app.Post("/login", func(c *fiber.Ctx) error {
+		return c.SendString(c.FormValue("username"))
+	})
+	app.Post("/settings/import", func(c *fiber.Ctx) error {
+		file, err := c.FormFile("file")
+		if err != nil {
+			return c.SendStatus(fiber.StatusBadRequest)
+		}
+		if file.Size > maxImportSize {
+			return c.SendStatus(fiber.StatusRequestEntityTooLarge)
+		}
+		return c.SendString("ok")
+	})
+	return app
+}
+
+// multipartBody arma un formulario con un archivo de size bytes
+func multipartBody(t *testing.T, size int) (*bytes.Buffer, string) {
+	t.Helper()
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	part, err := w.CreateFormFile("file", "configuracion.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := part.Write(bytes.Repeat([]byte{'x'}, size)); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	return &body, w.FormDataContentType()
+}
+
+func TestRequestBodyLimitPerRoute(t *testing.T) {
+	app := newLimitedApp()
+
+	// El límite general de Fiber sigue vigente en las rutas públicas
+	big := "username=" + strings.Repeat("a", fiber.DefaultBodyLimit)
+	req := httptest.NewRequest(fiber.MethodPost, "/login", strings.NewReader(big))
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationForm)
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != fiber.StatusRequestEntityTooLarge {
+		t.Errorf("/login con %d bytes: estado %d, se esperaba 413", len(big), resp.StatusCode)
+	}
+
+	req = httptest.NewRequest(fiber.MethodPost, "/login", strings.NewReader("username=admin"))
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationForm)
+	resp, err = app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := io.ReadAll(resp.Body); resp.StatusCode != fiber.StatusOK || string(data) != "admin" {
+		t.Errorf("/login pequeño: estado %d, cuerpo %q", resp.StatusCode, data)
+	}
+
+	// La importación acepta archivos más grandes que el límite general
+	body, contentType := multipartBody(t, 10<<20)
+	req = httptest.NewRequest(fiber.MethodPost, "/settings/import", body)
+	req.Header.Set(fiber.HeaderContentType, contentType)
+	resp, err = app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != fiber.StatusOK {
+		t.Errorf("importación de 10 MB: estado %d, se esperaba 200", resp.StatusCode)
+	}
+
+	body, contentType = multipartBody(t, maxImportSize+uploadFormOverhead)
+	req = httptest.NewRequest(fiber.MethodPost, "/settings/import", body)
+	req.Header.Set(fiber.HeaderContentType, contentType)
+	resp, err = app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != fiber.StatusRequestEntityTooLarge {
+		t.Errorf("importación demasiado grande: estado %d, se esperaba 413", resp.StatusCode)
+	}
+
+	// Sin Content-Length no se puede comprobar el límite antes de leer
+	req = httptest.NewRequest(fiber.MethodPost, "/login", strings.NewReader("username=admin"))
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationForm)
+	req.ContentLength = -1
+	req.TransferEncoding = []string{"chunked"}
+	resp, err = app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != fiber.StatusLengthRequired {
+		t.Errorf("cuerpo chunked: estado %d, se esperaba 411", resp.StatusCode)
+	}
+}
+
+func TestRequestBodyLimit(t *testing.T) {
+	tests := []struct {
+		method, path string
+		limit        int
+	}{
+		{fiber.MethodPost, "/login", fiber.DefaultBodyLimit},
+		{fiber.MethodPost, "/settings/import", maxImportSize + uploadFormOverhead},
+		{fiber.MethodGet, "/settings/import", fiber.DefaultBodyLimit},
+		{fiber.MethodPost, "/products", maxImageSize + uploadFormOverhead},
+		{fiber.MethodPut, "/products/12", maxImageSize + uploadFormOverhead},
+		{fiber.MethodPut, "/settings/restaurant", maxImageSize + uploadFormOverhead},
+		{fiber.MethodPost, "/orders/3/items", fiber.DefaultBodyLimit},
+	}
+	for _, tt := range tests {
+		if got := requestBodyLimit(tt.method, tt.path); got != tt.limit {
+			t.Errorf("requestBodyLimit(%s %s) = %d, se esperaba %d", tt.method, tt.path, got, tt.limit)
+		}
+	}
+}
@@ -9,16 +9,10 @@ import (
 
 // MenuHandler muestra la página de administración del menú
 func MenuHandler(c *fiber.Ctx) error {
-	// Obtener todas las categorías
-	var categories []string
-	db.Model(&Product{}).Distinct().Order("category").Pluck("category", &categories)
+	sidebar := categorySidebarData("all")
 
 	// Estadísticas del menú
-	var productCount int64
-	db.Model(&Product{}).Count(&productCount)
-
-	var categoryCount int64
-	categoryCount = int64(len(categories))
+	categoryCount := int64(len(sidebar["Categories"].([]Category)))
 
 	// Obtener productos más vendidos
 	type TopProduct struct {
@@ -28,10 +22,9 @@ func MenuHandler(c *fiber.Ctx) error {
 		Count    int64
 	}
 	var topProducts []TopProduct
-	db.Raw(`SELECT p.id, p.name, p.category, COUNT(oi.id) as count 
-           FROM products p 
-           JOIN order_items oi ON p.id = oi.product_id 
-           GROUP BY p.id, p.name, p.category 
+	db.Raw(`SELECT oi.product_id as id, oi.product_name as name, oi.category, COUNT(oi.id) as count 
+           FROM order_items oi 
+           GROUP BY oi.product_id, oi.product_name, oi.category 
            ORDER BY count DESC LIMIT 5`).
 		Scan(&topProducts)
 
@@ -53,8 +46,9 @@ func MenuHandler(c *fiber.Ctx) error {
 	return c.Render("menu", fiber.Map{
 		"Title":         "Administración de Menú",
 		"ActivePage":    "menu",
-		"Categories":    categories,
-		"ProductCount":  productCount,
+		"Categories":    sidebar["Categories"],
+		"ProductCount":  sidebar["ProductCount"],
+		"ProductCounts": sidebar["ProductCounts"],
 		"CategoryCount": categoryCount,
 		"TopProducts":   formattedTopProducts, // Aquí usamos el slice convertido
 		"Products":      products,
@@ -106,8 +100,7 @@ func GetProducts(c *fiber.Ctx) error {
 	query.Find(&products)
 
 	// Obtener todas las categorías para los filtros
-	var categories []string
-	db.Model(&Product{}).Distinct().Order("category").Pluck("category", &categories)
+	categories := loadCategories(db, false)
 
 	return c.Render("partials/product_list", fiber.Map{
 		"Products":   products,
@@ -122,68 +115,8 @@ func GetProducts(c *fiber.Ctx) error {
 	}, "")
 }
 
-// GetCategoryForm muestra el formulario para añadir una categoría
-func GetCategoryForm(c *fiber.Ctx) error {
-	var categories []string
-	db.Model(&Product{}).Distinct().Order("category").Pluck("category", &categories)
-
-	return c.Render("partials/category_form", fiber.Map{
-		"Categories": categories,
-	}, "")
-}
-
-// CreateCategory crea una nueva categoría
-func CreateCategory(c *fiber.Ctx) error {
-	categoryName := strings.TrimSpace(c.FormValue("name"))
-	if categoryName == "" {
-		c.Set("HX-Trigger", `{"showToast": "El nombre de la categoría no puede estar vacío"}`)
-		return c.Status(fiber.StatusBadRequest).SendString("Nombre de categoría requerido")
-	}
-
-	// Verificar si la categoría ya existe
-	var count int64
-	db.Model(&Product{}).Where("category = ?", categoryName).Count(&count)
-
-	if count > 0 {
-		c.Set("HX-Trigger", `{"showToast": "Esta categoría ya existe"}`)
-		return c.Status(fiber.StatusBadRequest).SendString("La categoría ya existe")
-	}
-
-	// Crear un producto vacío con la nueva categoría para registrarla
-	dummyProduct := Product{
-		Name:        "Categoría: " + categoryName,
-		Description: "Categoría temporal - puede eliminar este producto",
-		Category:    categoryName,
-		Price:       0.01,
-		IsAvailable: false,
-	}
-
-	if result := db.Create(&dummyProduct); result.Error != nil {
-		c.Set("HX-Trigger", `{"showToast": "Error al crear la categoría"}`)
-		return c.Status(fiber.StatusInternalServerError).SendString("Error al crear categoría")
-	}
-
-	// Obtener todas las categorías para actualizar la UI
-	var categories []string
-	db.Model(&Product{}).Distinct().Order("category").Pluck("category", &categories)
-
-	var productCount int64
-	db.Model(&Product{}).Count(&productCount)
-
-	c.Set("HX-Trigger", `{"showToast": "Categoría '`+categoryName+`' creada con éxito", "refreshCategories": true, "closeModal": true}`)
-
-	return c.Render("partials/category_sidebar", fiber.Map{
-		"Categories":   categories,
-		"ProductCount": productCount,
-		"Filters": fiber.Map{
-			"Category": "all",
-		},
-	}, "")
-}
-
 func GetProductForm(c *fiber.Ctx) error {
-	var categories []string
-	db.Model(&Product{}).Distinct().Order("category").Pluck("category", &categories)
+	categories := loadCategories(db, false)
 
 	return c.Render("partials/product_form", fiber.Map{
 		"Categories": categories,
@@ -195,35 +128,43 @@ func GetProductForm(c *fiber.Ctx) error {
 func CreateProduct(c *fiber.Ctx) error {
 	name := strings.TrimSpace(c.FormValue("name"))
 	description := strings.TrimSpace(c.FormValue("description"))
-	category := strings.TrimSpace(c.FormValue("category"))
 	priceStr := strings.TrimSpace(c.FormValue("price"))
 
-	if name == "" || category == "" || priceStr == "" {
+	category, categoryErr := productCategory(c)
+	if name == "" || categoryErr != nil || priceStr == "" {
 		c.Set("HX-Trigger", `{"showToast": "Todos los campos obligatorios deben completarse"}`)
 		return c.Status(fiber.StatusBadRequest).SendString("Todos los campos obligatorios son requeridos")
 	}
 
-	price, err := strconv.ParseFloat(priceStr, 64)
+	price, err := ParseMoney(priceStr)
 	if err != nil || price < 0 {
 		c.Set("HX-Trigger", `{"showToast": "El precio debe ser un número válido mayor o igual a cero"}`)
 		return c.Status(fiber.StatusBadRequest).SendString("Precio inválido")
 	}
 
+	imagePath, err := productImage(c)
+	if err != nil {
+		return Error(c, "Imagen no válida: "+err.Error(), fiber.StatusBadRequest)
+	}
+
 	// Crear producto
 	product := Product{
 		Name:        name,
 		Description: description,
-		Category:    category,
+		Category:    category.Name,
+		CategoryID:  &category.ID,
 		Price:       price,
 		IsAvailable: c.FormValue("is_available") == "on",
+		ImagePath:   imagePath,
 	}
 
 	if result := db.Create(&product); result.Error != nil {
+		removeUploadedImage(imagePath)
 		c.Set("HX-Trigger", `{"showToast": "Error al crear el producto"}`)
 		return c.Status(fiber.StatusInternalServerError).SendString("Error al crear producto")
 	}
 
-	c.Set("HX-Trigger", `{"showToast": "Producto '`+name+`' creado exitosamente", "closeModal": true}`)
+	setTrigger(c, fiber.Map{"showToast": "Producto '" + name + "' creado exitosamente", "closeModal": true})
 
 	// Obtener productos actualizados
 	var products []Product
@@ -231,8 +172,7 @@ func CreateProduct(c *fiber.Ctx) error {
 	query.Find(&products)
 
 	// Obtener todas las categorías para los filtros
-	var categories []string
-	db.Model(&Product{}).Distinct().Order("category").Pluck("category", &categories)
+	categories := loadCategories(db, false)
 
 	return c.Render("partials/product_list", fiber.Map{
 		"Products":   products,
@@ -247,6 +187,26 @@ func CreateProduct(c *fiber.Ctx) error {
 	}, "")
 }
 
+// productImage guarda la imagen subida en el formulario de producto, en tamaño completo y
+// miniatura. Devuelve su ruta pública, o vacío si no se subió ninguna.
+func productImage(c *fiber.Ctx) (string, error) {
+	file, err := c.FormFile("image")
+	if err != nil || file.Size == 0 {
+		return "", nil
+	}
+	return saveUploadedImage(file, "product", "jpg", productImageVariants)
+}
+
+// productCategory devuelve la categoría elegida en el formulario de producto
+func productCategory(c *fiber.Ctx) (Category, error) {
+	var category Category
+	id, err := strconv.Atoi(c.FormValue("category"))
+	if err != nil {
+		return category, err
+	}
+	return category, db.First(&category, id).Error
+}
+
 // GetProductEditForm retorna el formulario para editar un producto
 func GetProductEditForm(c *fiber.Ctx) error {
 	id, err := strconv.Atoi(c.Params("id"))
@@ -259,8 +219,7 @@ func GetProductEditForm(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusNotFound).SendString("Producto no encontrado")
 	}
 
-	var categories []string
-	db.Model(&Product{}).Distinct().Order("category").Pluck("category", &categories)
+	categories := loadCategories(db, false)
 
 	return c.Render("partials/product_form", fiber.Map{
 		"Product":    product,
@@ -286,22 +245,41 @@ func UpdateProduct(c *fiber.Ctx) error {
 	// Actualizar campos
 	product.Name = strings.TrimSpace(c.FormValue("name"))
 	product.Description = strings.TrimSpace(c.FormValue("description"))
-	product.Category = strings.TrimSpace(c.FormValue("category"))
+	if category, err := productCategory(c); err == nil {
+		product.Category = category.Name
+		product.CategoryID = &category.ID
+	}
 
-	price, err := strconv.ParseFloat(c.FormValue("price"), 64)
+	price, err := ParseMoney(c.FormValue("price"))
 	if err == nil && price >= 0 {
 		product.Price = price
 	}
 
-	product.IsAvailable = c.FormValue("is_available") == "on"
+	// Cambiar la disponibilidad a mano deja de lado la que se marcó por falta de ingredientes
+	if available := c.FormValue("is_available") == "on"; available != product.IsAvailable {
+		product.IsAvailable = available
+		product.OutOfStock = false
+	}
+
+	// Una imagen nueva o quitar la actual reemplaza los archivos anteriores al guardar
+	imagePath, err := productImage(c)
+	if err != nil {
+		return Error(c, "Imagen no válida: "+err.Error(), fiber.StatusBadRequest)
+	}
+	oldImage := ""
+	if imagePath != "" || c.FormValue("remove_image") == "on" {
+		oldImage, product.ImagePath = product.ImagePath, imagePath
+	}
 
 	// Guardar cambios
 	if result := db.Save(&product); result.Error != nil {
+		removeUploadedImage(imagePath)
 		c.Set("HX-Trigger", `{"showToast": "Error al actualizar el producto"}`)
 		return c.Status(fiber.StatusInternalServerError).SendString("Error al actualizar producto")
 	}
+	removeUploadedImage(oldImage)
 
-	c.Set("HX-Trigger", `{"showToast": "Producto '`+product.Name+`' actualizado correctamente", "closeModal": true, "refreshProducts": true}`)
+	setTrigger(c, fiber.Map{"showToast": "Producto '" + product.Name + "' actualizado correctamente", "closeModal": true, "refreshProducts": true})
 
 	// Redirigir a la lista de productos actualizada
 	return GetProducts(c)
@@ -324,8 +302,11 @@ func DeleteProduct(c *fiber.Ctx) error {
 		db.Model(&Product{}).Where("id = ?", id).Update("is_available", false)
 		c.Set("HX-Trigger", `{"showToast": "El producto está en uso y ha sido marcado como no disponible"}`)
 	} else {
-		// Eliminar si no está en uso
-		db.Delete(&Product{}, id)
+		// Eliminar si no está en uso, junto con su imagen
+		var product Product
+		if db.First(&product, id).Error == nil && db.Delete(&product).Error == nil {
+			removeUploadedImage(product.ImagePath)
+		}
 		c.Set("HX-Trigger", `{"showToast": "Producto eliminado correctamente"}`)
 	}
 
@@ -354,10 +335,12 @@ func BulkAction(c *fiber.Ctx) error {
 	// Realizar la acción correspondiente
 	switch action {
 	case "enable":
-		db.Model(&Product{}).Where("id IN ?", ids).Update("is_available", true)
+		db.Model(&Product{}).Where("id IN ?", ids).
+			Updates(map[string]interface{}{"is_available": true, "out_of_stock": false})
 		c.Set("HX-Trigger", `{"showToast": "Productos habilitados correctamente"}`)
 	case "disable":
-		db.Model(&Product{}).Where("id IN ?", ids).Update("is_available", false)
+		db.Model(&Product{}).Where("id IN ?", ids).
+			Updates(map[string]interface{}{"is_available": false, "out_of_stock": false})
 		c.Set("HX-Trigger", `{"showToast": "Productos deshabilitados correctamente"}`)
 	case "delete":
 		// Verificar si algún producto está usado en órdenes
@@ -369,8 +352,14 @@ func BulkAction(c *fiber.Ctx) error {
 			db.Model(&Product{}).Where("id IN ?", ids).Update("is_available", false)
 			c.Set("HX-Trigger", `{"showToast": "Algunos productos están en uso y han sido marcados como no disponibles"}`)
 		} else {
-			// Eliminar si no están en uso
-			db.Delete(&Product{}, "id IN ?", ids)
+			// Eliminar si no están en uso, junto con sus imágenes
+			var images []string
+			db.Model(&Product{}).Where("id IN ?", ids).Pluck("image_path", &images)
+			if db.Delete(&Product{}, "id IN ?", ids).Error == nil {
+				for _, image := range images {
+					removeUploadedImage(image)
+				}
+			}
 			c.Set("HX-Trigger", `{"showToast": "Productos eliminados correctamente"}`)
 		}
 	default:
@@ -381,21 +370,3 @@ func BulkAction(c *fiber.Ctx) error {
 	// Redirigir a la lista de productos actualizada
 	return GetProducts(c)
 }
-
-// GetCategoryList devuelve la lista de categorías para actualizar el panel lateral
-func GetCategoryList(c *fiber.Ctx) error {
-	var categories []string
-	db.Model(&Product{}).Distinct().Order("category").Pluck("category", &categories)
-
-	// Contar productos por categoría (opcional)
-	var productCount int64
-	db.Model(&Product{}).Count(&productCount)
-
-	return c.Render("partials/category_sidebar", fiber.Map{
-		"Categories":   categories,
-		"ProductCount": productCount,
-		"Filters": fiber.Map{
-			"Category": c.Query("category", "all"),
-		},
-	}, "")
-}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// migration es una migración de datos que se ejecuta una sola vez, después de AutoMigrate.
+// Las marcadas con Before se ejecutan antes, para cambios de esquema que AutoMigrate haría mal.
+type migration struct {
+	ID     string
+	Before bool
+	Run    func(tx *gorm.DB) error
+}
+
+// migrations se aplican en orden; nunca modificar ni reordenar una ya publicada
+var migrations = []migration{
+	{
+		// Copiar precio, nombre y categoría del producto en los ítems existentes
+		ID: "0001_order_item_snapshot",
+		Run: func(tx *gorm.DB) error {
+			return tx.Exec(`
+                UPDATE order_items oi
+                SET unit_price = p.price,
+                    product_name = p.name,
+                    category = p.category
+                FROM products p
+                WHERE p.id = oi.product_id
+                AND (oi.product_name IS NULL OR oi.product_name = '')
+            `).Error
+		},
+	},
+	{
+		// Las órdenes anteriores al desglose de impuestos no tenían impuesto: su subtotal es el total
+		ID: "0002_order_subtotal",
+		Run: func(tx *gorm.DB) error {
+			return tx.Exec(`UPDATE orders SET subtotal = total WHERE subtotal = 0 AND total <> 0`).Error
+		},
+	},
+	{
+		// Los importes pasan de float (unidades) a bigint (centavos). AutoMigrate solo cambiaría
+		// el tipo y truncaría los decimales, así que se convierten antes multiplicando por 100.
+		ID:     "0003_money_minor_units",
+		Before: true,
+		Run: func(tx *gorm.DB) error {
+			columns := map[string][]string{
+				"products":    {"price"},
+				"orders":      {"total", "subtotal", "tax_amount", "service_charge", "tip"},
+				"order_items": {"unit_price"},
+			}
+			for _, table := range []string{"products", "orders", "order_items"} {
+				for _, column := range columns[table] {
+					var dataType string
+					err := tx.Raw(`
+                        SELECT data_type FROM information_schema.columns
+                        WHERE table_schema = current_schema() AND table_name = ? AND column_name = ?
+                    `, table, column).Scan(&dataType).Error
+					if err != nil {
+						return err
+					}
+					// Columna inexistente (base nueva) o ya convertida
+					if dataType == "" || dataType == "bigint" {
+						continue
+					}
+					sql := fmt.Sprintf(`ALTER TABLE %s ALTER COLUMN %s TYPE bigint USING ROUND(%s * 100)::bigint`, table, column, column)
+					if err := tx.Exec(sql).Error; err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		},
+	},
+	{
+		// Las órdenes cerradas antes de registrar pagos se consideran pagadas por su total
+		ID: "0004_completed_order_payments",
+		Run: func(tx *gorm.DB) error {
+			return tx.Exec(`
+                INSERT INTO payments (order_id, method, amount, tendered, change, reference, created_at)
+                SELECT o.id, ?, o.total, 0, 0, 'Registrado antes del control de pagos', COALESCE(o.completed_at, o.updated_at)
+                FROM orders o
+                WHERE o.status = ? AND o.total > 0 AND o.deleted_at IS NULL
+                AND NOT EXISTS (SELECT 1 FROM payments p WHERE p.order_id = o.id)
+            `, PaymentOther, StatusCompleted).Error
+		},
+	},
+	{
+		// Las categorías eran solo el texto de products.category, y las vacías se registraban con
+		// productos "Categoría: X" no disponibles. Se crean las categorías, se enlazan los productos
+		// y se borran esos productos, salvo los que aparezcan en alguna orden. Las impresoras por
+		// categoría, que guardaban el nombre, se enlazan a la categoría creada.
+		ID: "0005_categories",
+		Run: func(tx *gorm.DB) error {
+			err := tx.Exec(`
+                INSERT INTO categories (name, description, color, icon, position, active, created_at, updated_at)
+                SELECT category, '', '', '', ROW_NUMBER() OVER (ORDER BY category), true, NOW(), NOW()
+                FROM (SELECT DISTINCT category FROM products WHERE category <> '') c
+                ON CONFLICT (name) DO NOTHING
+            `).Error
+			if err != nil {
+				return err
+			}
+			err = tx.Exec(`
+                UPDATE products p SET category_id = c.id
+                FROM categories c
+                WHERE c.name = p.category AND p.category_id IS NULL
+            `).Error
+			if err != nil {
+				return err
+			}
+			// Las impresoras por categoría pasan a enlazarse por ID; las de nombres sin categoría no enrutaban nada
+			if tx.Migrator().HasColumn("category_printers", "category") {
+				err = tx.Exec(`
+                UPDATE category_printers cp SET category_id = c.id
+                FROM categories c
+                WHERE c.name = cp.category AND cp.category_id IS NULL
+            `).Error
+				if err != nil {
+					return err
+				}
+				if err := tx.Exec(`DELETE FROM category_printers WHERE category_id IS NULL`).Error; err != nil {
+					return err
+				}
+				if err := tx.Migrator().DropColumn("category_printers", "category"); err != nil {
+					return err
+				}
+			}
+			return tx.Exec(`
+                DELETE FROM products p
+                WHERE p.is_available = false AND p.name = ? || p.category
+                AND NOT EXISTS (SELECT 1 FROM order_items oi WHERE oi.product_id = p.id)
+            `, "Categoría: ").Error
+		},
+	},
+}
+
+// runMigrations aplica, cada una en su propia transacción, las migraciones pendientes
+// de la fase indicada: before=true antes de AutoMigrate y before=false después
+func runMigrations(before bool) error {
+	if err := db.AutoMigrate(&SchemaMigration{}); err != nil {
+		return err
+	}
+
+	var applied []string
+	if err := db.Model(&SchemaMigration{}).Pluck("id", &applied).Error; err != nil {
+		return err
+	}
+	done := make(map[string]bool, len(applied))
+	for _, id := range applied {
+		done[id] = true
+	}
+
+	for _, m := range migrations {
+		if done[m.ID] || m.Before != before {
+			continue
+		}
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := m.Run(tx); err != nil {
+				return err
+			}
+			return tx.Create(&SchemaMigration{ID: m.ID, AppliedAt: time.Now()}).Error
+		})
+		if err != nil {
+			return fmt.Errorf("migración %s: %w", m.ID, err)
+		}
+		log.Printf("Migración %s aplicada", m.ID)
+	}
+	return nil
+}
@@ -11,12 +11,152 @@ type Product struct {
 	ID          uint      `json:"id" gorm:"primaryKey"`
 	Name        string    `json:"name"`
 	Description string    `json:"description"`
-	Price       float64   `json:"price"`
+	Price       Money     `json:"price"`
 	Category    string    `json:"category"`
 	IsAvailable bool      `json:"is_available" gorm:"default:true"`
 	ImagePath   string    `json:"image_path"`
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
+
+	// Categoría del producto; Category guarda su nombre para filtrar y para las comandas
+	// y se actualiza al renombrarla (ver UpdateCategory)
+	CategoryID *uint `json:"category_id" gorm:"index"`
+
+	// Grupos de modificadores que se eligen al agregarlo a una orden (ver modifiers.go)
+	ModifierGroups []ModifierGroup `json:"modifier_groups,omitempty" gorm:"foreignKey:ProductID;constraint:OnDelete:CASCADE"`
+
+	// Componentes si es un combo; el combo se vende al precio del producto (ver combos.go)
+	ComboSlots []ComboSlot `json:"combo_slots,omitempty" gorm:"foreignKey:ComboID;constraint:OnDelete:CASCADE"`
+
+	// Receta para descontar ingredientes (ver stock.go). OutOfStock indica que el inventario lo
+	// marcó no disponible por falta de un ingrediente y que vuelve a estarlo al reponerlo.
+	Recipe     []RecipeLine `json:"recipe,omitempty" gorm:"foreignKey:ProductID;constraint:OnDelete:CASCADE"`
+	OutOfStock bool         `json:"out_of_stock" gorm:"default:false"`
+}
+
+// Ingredient es un insumo del inventario; OnHand y LowStock se expresan en Unit (g, ml, unidad...)
+type Ingredient struct {
+	ID        uint            `json:"id" gorm:"primaryKey"`
+	Name      string          `json:"name" gorm:"uniqueIndex"`
+	Unit      string          `json:"unit"`
+	OnHand    float64         `json:"on_hand"`
+	LowStock  float64         `json:"low_stock"` // Umbral de alerta; 0 = sin alerta
+	Movements []StockMovement `json:"-" gorm:"foreignKey:IngredientID;constraint:OnDelete:CASCADE"`
+	CreatedAt time.Time       `json:"created_at"`
+	UpdatedAt time.Time       `json:"updated_at"`
+
+	// Costo por unidad de la última recepción de compra; valoriza las existencias
+	LastCost Money `json:"last_cost" gorm:"default:0"`
+}
+
+// RecipeLine es la cantidad de un ingrediente que lleva una unidad de un producto
+type RecipeLine struct {
+	ID           uint       `json:"id" gorm:"primaryKey"`
+	ProductID    uint       `json:"product_id" gorm:"index"`
+	IngredientID uint       `json:"ingredient_id" gorm:"index"`
+	Ingredient   Ingredient `json:"ingredient" gorm:"foreignKey:IngredientID"`
+	Quantity     float64    `json:"quantity"`
+}
+
+// StockMovement registra un cambio de existencias de un ingrediente
+type StockMovement struct {
+	ID           uint      `json:"id" gorm:"primaryKey"`
+	IngredientID uint      `json:"ingredient_id" gorm:"index"`
+	Quantity     float64   `json:"quantity"` // Positivo si entra, negativo si sale
+	Reason       string    `json:"reason"`   // Ver las constantes Stock* en stock.go
+	OrderID      *uint     `json:"order_id" gorm:"index"`
+	UserID       *uint     `json:"user_id"`
+	User         *User     `json:"user,omitempty" gorm:"foreignKey:UserID"`
+	Notes        string    `json:"notes"`
+	CreatedAt    time.Time `json:"created_at"`
+
+	// Recepción de una orden de compra, con el costo por unidad recibido
+	PurchaseOrderID *uint `json:"purchase_order_id" gorm:"index"`
+	UnitCost        Money `json:"unit_cost" gorm:"default:0"`
+}
+
+// Supplier es un proveedor de ingredientes
+type Supplier struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	Name      string    `json:"name" gorm:"uniqueIndex"`
+	Contact   string    `json:"contact"`
+	Phone     string    `json:"phone"`
+	Email     string    `json:"email"`
+	Notes     string    `json:"notes"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// PurchaseOrder es un pedido de ingredientes a un proveedor. Se arma en borrador, se envía y
+// se recibe en una o varias entregas; cada recepción suma a las existencias.
+type PurchaseOrder struct {
+	ID          uint                `json:"id" gorm:"primaryKey"`
+	SupplierID  uint                `json:"supplier_id" gorm:"index"`
+	Supplier    Supplier            `json:"supplier" gorm:"foreignKey:SupplierID"`
+	Status      string              `json:"status" gorm:"default:draft"` // Ver las constantes Purchase* en purchasing.go
+	Notes       string              `json:"notes"`
+	Lines       []PurchaseOrderLine `json:"lines" gorm:"foreignKey:PurchaseOrderID;constraint:OnDelete:CASCADE"`
+	CreatedByID *uint               `json:"created_by_id"`
+	CreatedBy   *User               `json:"created_by,omitempty" gorm:"foreignKey:CreatedByID"`
+	OrderedAt   *time.Time          `json:"ordered_at"`
+	ReceivedAt  *time.Time          `json:"received_at"` // Cuando se cerró la recepción
+	CreatedAt   time.Time           `json:"created_at"`
+	UpdatedAt   time.Time           `json:"updated_at"`
+}
+
+// PurchaseOrderLine es un ingrediente pedido; UnitCost empieza como el costo esperado y
+// queda con el de la última recepción
+type PurchaseOrderLine struct {
+	ID               uint       `json:"id" gorm:"primaryKey"`
+	PurchaseOrderID  uint       `json:"purchase_order_id" gorm:"index"`
+	IngredientID     uint       `json:"ingredient_id" gorm:"index"`
+	Ingredient       Ingredient `json:"ingredient" gorm:"foreignKey:IngredientID"`
+	Quantity         float64    `json:"quantity"`
+	UnitCost         Money      `json:"unit_cost"` // Costo pedido; lo recibido conserva su costo en StockMovement
+	ReceivedQuantity float64    `json:"received_quantity" gorm:"default:0"`
+
+	// Costo de lo recibido según las recepciones; lo calcula loadPurchaseOrder
+	ReceivedCost Money `json:"received_cost" gorm:"-"`
+}
+
+// ComboSlot es un componente de un combo: un producto fijo (ProductID) o uno a elegir entre
+// los de una categoría (CategoryID), por ejemplo "Acompañamiento" de Acompañamientos. No son
+// claves foráneas para poder borrar productos y categorías; el combo avisa al venderse.
+type ComboSlot struct {
+	ID         uint      `json:"id" gorm:"primaryKey"`
+	ComboID    uint      `json:"combo_id" gorm:"index"`
+	Name       string    `json:"name"`
+	ProductID  *uint     `json:"product_id"`
+	CategoryID *uint     `json:"category_id"`
+	Position   int       `json:"position"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// ModifierGroup es un grupo de opciones de un producto, por ejemplo "Tamaño" (obligatorio,
+// una sola opción) o "Extras" (varias opciones)
+type ModifierGroup struct {
+	ID        uint             `json:"id" gorm:"primaryKey"`
+	ProductID uint             `json:"product_id" gorm:"index"`
+	Name      string           `json:"name"`
+	Required  bool             `json:"required"` // Hay que elegir al menos una opción
+	Multiple  bool             `json:"multiple"` // Se puede elegir más de una opción
+	Position  int              `json:"position"`
+	Options   []ModifierOption `json:"options" gorm:"foreignKey:GroupID;constraint:OnDelete:CASCADE"`
+	CreatedAt time.Time        `json:"created_at"`
+	UpdatedAt time.Time        `json:"updated_at"`
+}
+
+// ModifierOption es una opción de un grupo; PriceDelta se suma al precio del producto
+// (puede ser cero o negativo, por ejemplo "Sin cebolla" o "Chico")
+type ModifierOption struct {
+	ID         uint      `json:"id" gorm:"primaryKey"`
+	GroupID    uint      `json:"group_id" gorm:"index"`
+	Name       string    `json:"name"`
+	PriceDelta Money     `json:"price_delta"`
+	Position   int       `json:"position"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
 }
 
 // Category representa una categoría de productos
@@ -25,6 +165,14 @@ type Category struct {
 	Name      string    `json:"name" gorm:"unique"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
+
+	// Presentación en el menú: Position ordena las categorías (menor primero), Color es "#rrggbb"
+	// e Icon un nombre de Bootstrap Icons ("cup-hot"). Las inactivas no se ofrecen al tomar pedidos.
+	Description string `json:"description"`
+	Color       string `json:"color"`
+	Icon        string `json:"icon"`
+	Position    int    `json:"position" gorm:"default:0"`
+	Active      bool   `json:"active" gorm:"default:true"`
 }
 
 // Orden contiene los detalles de la orden
@@ -37,23 +185,49 @@ type Category struct {
 //   - "cancelled": Cancelada
 //
 // El flujo es: pending -> in_progress -> ready -> to_pay -> completed
+// Las transiciones permitidas están en orderTransitions (orderstate.go) y se aplican con TransitionOrder.
 // Se puede agregar productos en cualquier estado excepto completed/cancelled, pero sólo los nuevos ítems son editables antes de enviarse a cocina.
 type Order struct {
 	ID        uint           `json:"id" gorm:"primaryKey"`
 	TableNum  int            `json:"table_num"`
 	Status    string         `json:"status"` // "pending", "in_progress", "ready", "to_pay", "completed", "cancelled"
-	Total     float64        `json:"total"`
+	Total     Money          `json:"total"`  // Total a cobrar (ver priceOrder en pricing.go)
 	Items     []OrderItem    `json:"items" gorm:"foreignKey:OrderID"`
+	Payments  []Payment      `json:"payments" gorm:"foreignKey:OrderID"`
+	Checks    []OrderCheck   `json:"checks" gorm:"foreignKey:OrderID"`
 	Notes     string         `json:"notes"`
 	CreatedAt time.Time      `json:"created_at"`
 	UpdatedAt time.Time      `json:"updated_at"`
 	DeletedAt gorm.DeletedAt `json:"deleted_at" gorm:"index"`
 
+	// Desglose del total; TaxRate y PricesIncludeTax guardan la configuración usada
+	Subtotal         Money   `json:"subtotal"`
+	TaxRate          float64 `json:"tax_rate"`
+	PricesIncludeTax bool    `json:"prices_include_tax"`
+	TaxAmount        Money   `json:"tax_amount"`
+	ServiceCharge    Money   `json:"service_charge"`
+	Tip              Money   `json:"tip"`
+
+	// Reembolsado después de cerrar la orden: lo cobrado neto es Total - Refunded (ver NetTotal)
+	Refunded Money `json:"refunded" gorm:"default:0"`
+
 	// Nuevos campos para tracking avanzado
 	SentToKitchenAt    *time.Time `json:"sent_to_kitchen_at"`
 	CookingCompletedAt *time.Time `json:"cooking_completed_at"`
 	DeliveredAt        *time.Time `json:"delivered_at"`
 	CompletedAt        *time.Time `json:"completed_at"`
+
+	// Personal que realizó cada paso de la orden
+	CreatedByID       *uint `json:"created_by_id"`
+	CreatedBy         *User `json:"created_by,omitempty" gorm:"foreignKey:CreatedByID"`
+	SentToKitchenByID *uint `json:"sent_to_kitchen_by_id"`
+	SentToKitchenBy   *User `json:"sent_to_kitchen_by,omitempty" gorm:"foreignKey:SentToKitchenByID"`
+	DeliveredByID     *uint `json:"delivered_by_id"`
+	DeliveredBy       *User `json:"delivered_by,omitempty" gorm:"foreignKey:DeliveredByID"`
+	CompletedByID     *uint `json:"completed_by_id"`
+	CompletedBy       *User `json:"completed_by,omitempty" gorm:"foreignKey:CompletedByID"`
+	CancelledByID     *uint `json:"cancelled_by_id"`
+	CancelledBy       *User `json:"cancelled_by,omitempty" gorm:"foreignKey:CancelledByID"`
 }
 
 // OrderItem representa un producto en una orden
@@ -71,6 +245,111 @@ type OrderItem struct {
 	DeliveredAt     *time.Time `json:"delivered_at"`
 	CreatedAt       time.Time  `json:"created_at"`
 	UpdatedAt       time.Time  `json:"updated_at"`
+
+	// Copia del producto al momento de agregarlo; totales y reportes usan estos
+	// valores para que editar el menú no cambie órdenes existentes
+	UnitPrice   Money  `json:"unit_price"`
+	ProductName string `json:"product_name"`
+	Category    string `json:"category"`
+
+	// Asiento del comensal (0 = compartido) y cuenta separada a la que se asignó (ver checks.go)
+	Seat    int   `json:"seat" gorm:"default:0"`
+	CheckID *uint `json:"check_id" gorm:"index"`
+
+	// Personal que agregó y preparó el ítem
+	AddedByID    *uint `json:"added_by_id"`
+	AddedBy      *User `json:"added_by,omitempty" gorm:"foreignKey:AddedByID"`
+	PreparedByID *uint `json:"prepared_by_id"`
+	PreparedBy   *User `json:"prepared_by,omitempty" gorm:"foreignKey:PreparedByID"`
+
+	// Modificadores elegidos (ver modifiers.go). UnitPrice ya incluye sus diferencias de precio;
+	// ModifierKey identifica la combinación para no unir ítems con modificadores distintos y
+	// ModifierNames es la copia que se muestra en comandas y recibos.
+	Modifiers     []OrderItemModifier `json:"modifiers,omitempty" gorm:"foreignKey:OrderItemID;constraint:OnDelete:CASCADE"`
+	ModifierKey   string              `json:"modifier_key" gorm:"default:''"`
+	ModifierNames string              `json:"modifier_names"`
+
+	// Combos (ver combos.go): el ítem del combo lleva el precio y el cliente solo ve esa línea;
+	// cocina prepara sus componentes, ítems con precio cero que apuntan a él con ParentItemID
+	IsCombo      bool        `json:"is_combo" gorm:"default:false"`
+	ParentItemID *uint       `json:"parent_item_id" gorm:"index"`
+	Components   []OrderItem `json:"components,omitempty" gorm:"foreignKey:ParentItemID;constraint:OnDelete:CASCADE"`
+
+	// Unidades del ítem cuyos ingredientes ya se descontaron del inventario (ver stock.go)
+	StockDeducted int `json:"stock_deducted" gorm:"default:0"`
+}
+
+// OrderItemModifier es la copia de una opción elegida para un ítem, con su grupo y precio
+// de ese momento; OptionID no es clave foránea para poder borrar opciones del menú
+type OrderItemModifier struct {
+	ID          uint   `json:"id" gorm:"primaryKey"`
+	OrderItemID uint   `json:"order_item_id" gorm:"index"`
+	OptionID    uint   `json:"option_id"`
+	GroupName   string `json:"group_name"`
+	Name        string `json:"name"`
+	PriceDelta  Money  `json:"price_delta"`
+}
+
+// Payment es un cobro registrado sobre una orden; una orden puede tener varios pagos parciales.
+// Method puede ser "cash", "card" u "other". Los reembolsos son pagos con Amount negativo
+// que apuntan al pago original con RefundOfID.
+type Payment struct {
+	ID          uint      `json:"id" gorm:"primaryKey"`
+	OrderID     uint      `json:"order_id" gorm:"index"`
+	Method      string    `json:"method"`
+	Amount      Money     `json:"amount"`    // Monto aplicado a la orden (negativo en reembolsos)
+	Tendered    Money     `json:"tendered"`  // Efectivo recibido
+	Change      Money     `json:"change"`    // Cambio entregado: Tendered - Amount
+	Reference   string    `json:"reference"` // Autorización de tarjeta u otra referencia
+	RefundOfID  *uint     `json:"refund_of_id" gorm:"index"`
+	Reason      string    `json:"reason"`
+	CreatedByID *uint     `json:"created_by_id"`
+	CreatedBy   *User     `json:"created_by,omitempty" gorm:"foreignKey:CreatedByID"`
+	CreatedAt   time.Time `json:"created_at"`
+
+	// Cuenta separada que se cobró, si la orden está dividida
+	CheckID *uint       `json:"check_id" gorm:"index"`
+	Check   *OrderCheck `json:"check,omitempty" gorm:"foreignKey:CheckID"`
+}
+
+// OrderCheck es una cuenta separada de una orden dividida. Cada cuenta se cobra por su lado;
+// su importe sale de los ítems asignados más una parte igual de los no asignados (ver splitChecks).
+type OrderCheck struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	OrderID   uint      `json:"order_id" gorm:"index"`
+	Number    int       `json:"number"`
+	Label     string    `json:"label"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// OrderEvent es un registro de solo inserción de los cambios de una orden
+// Type puede ser:
+//   - "status": transición de estado (FromStatus -> ToStatus)
+//   - "item_added": se agregó un producto
+//   - "item_removed": se eliminó un producto
+//   - "item_quantity": cambió la cantidad de un producto (OldQuantity -> NewQuantity)
+//   - "table_transfer": la orden cambió de mesa (FromTable -> ToTable)
+//   - "order_merge": se unió otra orden (RelatedOrderID); en la orden absorbida, ToTable es la mesa destino
+type OrderEvent struct {
+	ID          uint      `json:"id" gorm:"primaryKey"`
+	OrderID     uint      `json:"order_id" gorm:"index"`
+	Type        string    `json:"type"`
+	FromStatus  string    `json:"from_status"`
+	ToStatus    string    `json:"to_status"`
+	ItemID      *uint     `json:"item_id"`
+	ProductID   *uint     `json:"product_id"`
+	ProductName string    `json:"product_name"`
+	OldQuantity int       `json:"old_quantity"`
+	NewQuantity int       `json:"new_quantity"`
+	ActorID     *uint     `json:"actor_id"`
+	Actor       *User     `json:"actor,omitempty" gorm:"foreignKey:ActorID"`
+	Reason      string    `json:"reason"`
+	CreatedAt   time.Time `json:"created_at"`
+
+	// Cambios de mesa y órdenes unidas
+	FromTable      int   `json:"from_table"`
+	ToTable        int   `json:"to_table"`
+	RelatedOrderID *uint `json:"related_order_id"`
 }
 
 // Settings almacena la configuración de la aplicación
@@ -91,6 +370,57 @@ type Settings struct {
 	CurrencySymbol string    `json:"currency_symbol" gorm:"default:'$'"`
 	CreatedAt      time.Time `json:"created_at"`
 	UpdatedAt      time.Time `json:"updated_at"`
+
+	// Precios del menú con impuesto incluido y cargo por servicio (fracción del subtotal)
+	PricesIncludeTax  bool    `json:"prices_include_tax" gorm:"default:false"`
+	ServiceChargeRate float64 `json:"service_charge_rate" gorm:"default:0"`
+
+	// Impresora de comandas para las categorías sin impresora propia (ver CategoryPrinter)
+	KitchenPrinter string `json:"kitchen_printer"`
+
+	// Servidor SMTP para enviar recibos por correo; SMTPFrom vacío usa Email
+	SMTPHost     string `json:"smtp_host"`
+	SMTPPort     int    `json:"smtp_port" gorm:"default:587"`
+	SMTPUsername string `json:"smtp_username"`
+	SMTPPassword string `json:"-"`
+	SMTPFrom     string `json:"smtp_from"`
+
+	// Respaldos automáticos: horario tipo cron (vacío = desactivado) y cuántos conservar
+	// (uno por día de los últimos BackupKeepDaily días y uno por semana de las últimas BackupKeepWeekly)
+	BackupSchedule   string     `json:"backup_schedule"`
+	BackupKeepDaily  int        `json:"backup_keep_daily" gorm:"default:7"`
+	BackupKeepWeekly int        `json:"backup_keep_weekly" gorm:"default:4"`
+	BackupLastRunAt  *time.Time `json:"backup_last_run_at"`
+	BackupLastError  string     `json:"backup_last_error"`
+}
+
+// CategoryPrinter envía las comandas de una categoría a su propia impresora,
+// por ejemplo las bebidas a la barra; Station es el nombre que se imprime en la comanda
+// (vacío = el nombre de la categoría). Se enlaza por CategoryID para sobrevivir a los renombres.
+type CategoryPrinter struct {
+	ID         uint      `json:"id" gorm:"primaryKey"`
+	CategoryID uint      `json:"category_id" gorm:"uniqueIndex"`
+	Category   *Category `json:"category,omitempty" gorm:"constraint:OnDelete:CASCADE"`
+	Station    string    `json:"station"`
+	Printer    string    `json:"printer"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// EmailDelivery es un recibo enviado por correo; la cola de mailer.go lo reintenta hasta enviarlo
+type EmailDelivery struct {
+	ID            uint       `json:"id" gorm:"primaryKey"`
+	OrderID       uint       `json:"order_id" gorm:"index"`
+	To            string     `json:"to"`
+	Status        string     `json:"status" gorm:"index"` // "pending", "sent", "failed"
+	Attempts      int        `json:"attempts"`
+	LastError     string     `json:"last_error"`
+	NextAttemptAt time.Time  `json:"next_attempt_at"`
+	SentAt        *time.Time `json:"sent_at"`
+	CreatedByID   *uint      `json:"created_by_id"`
+	CreatedBy     *User      `json:"created_by,omitempty" gorm:"foreignKey:CreatedByID"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
 }
 
 // Table representa una mesa en el restaurante
@@ -111,6 +441,12 @@ type Backup struct {
 	FilePath  string    `json:"file_path"`
 	Size      int64     `json:"size"`
 	CreatedAt time.Time `json:"created_at"`
+
+	// SHA-256 del archivo, para detectar cambios antes de restaurarlo
+	Checksum string `json:"checksum"`
+
+	// Creado por el programador de respaldos; solo estos se podan por retención
+	Automatic bool `json:"automatic" gorm:"default:false"`
 }
 
 // User representa un usuario del sistema
@@ -127,3 +463,9 @@ type User struct {
 	UpdatedAt time.Time      `json:"updated_at"`
 	DeletedAt gorm.DeletedAt `json:"deleted_at" gorm:"index"`
 }
+
+// SchemaMigration registra las migraciones de datos ya aplicadas (ver migrations.go)
+type SchemaMigration struct {
+	ID        string    `json:"id" gorm:"primaryKey"`
+	AppliedAt time.Time `json:"applied_at"`
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// loadModifierGroups devuelve los grupos de modificadores del producto con sus opciones, en orden
+func loadModifierGroups(tx *gorm.DB, productID uint) []ModifierGroup {
+	var groups []ModifierGroup
+	tx.Where("product_id = ?", productID).Order("position, id").
+		Preload("Options", func(tx *gorm.DB) *gorm.DB {
+			return tx.Order("position, id")
+		}).
+		Find(&groups)
+	return groups
+}
+
+// formModifierOptions lee las opciones elegidas en el formulario de agregar producto:
+// los grupos de una sola opción envían modifier_group_<id> y los de varias, modifiers
+func formModifierOptions(c *fiber.Ctx) []uint {
+	var ids []uint
+	c.Request().PostArgs().VisitAll(func(key, value []byte) {
+		name := string(key)
+		if name != "modifiers" && !strings.HasPrefix(name, "modifier_group_") {
+			return
+		}
+		if id, err := strconv.ParseUint(string(value), 10, 64); err == nil && id > 0 {
+			ids = append(ids, uint(id))
+		}
+	})
+	return ids
+}
+
+// resolveModifiers valida las opciones elegidas contra los grupos del producto y devuelve
+// sus copias para el ítem, en el orden del producto
+func resolveModifiers(groups []ModifierGroup, optionIDs []uint) ([]OrderItemModifier, error) {
+	chosen := make(map[uint]bool, len(optionIDs))
+	for _, id := range optionIDs {
+		chosen[id] = true
+	}
+
+	var modifiers []OrderItemModifier
+	for _, group := range groups {
+		count := 0
+		for _, option := range group.Options {
+			if !chosen[option.ID] {
+				continue
+			}
+			delete(chosen, option.ID)
+			count++
+			modifiers = append(modifiers, OrderItemModifier{
+				OptionID:   option.ID,
+				GroupName:  group.Name,
+				Name:       option.Name,
+				PriceDelta: option.PriceDelta,
+			})
+		}
+		if group.Required && count == 0 && len(group.Options) > 0 {
+			return nil, fmt.Errorf("elige una opción de %s", group.Name)
+		}
+		if !group.Multiple && count > 1 {
+			return nil, fmt.Errorf("elige solo una opción de %s", group.Name)
+		}
+	}
+	if len(chosen) > 0 {
+		return nil, errors.New("hay opciones que no son de este producto")
+	}
+	return modifiers, nil
+}
+
+// applyModifiers guarda en el ítem los modificadores elegidos y suma sus diferencias al precio
+// unitario, que ya debe tener el precio del producto (ver snapshotProduct)
+func (item *OrderItem) applyModifiers(modifiers []OrderItemModifier) {
+	ids := make([]string, 0, len(modifiers))
+	names := make([]string, 0, len(modifiers))
+	for _, modifier := range modifiers {
+		item.UnitPrice += modifier.PriceDelta
+		ids = append(ids, strconv.FormatUint(uint64(modifier.OptionID), 10))
+		names = append(names, modifier.Name)
+	}
+	sort.Strings(ids)
+	item.Modifiers = modifiers
+	item.ModifierKey = strings.Join(ids, ",")
+	item.ModifierNames = strings.Join(names, ", ")
+}
+
+// copyModifiers devuelve copias de los modificadores para otro ítem
+func copyModifiers(modifiers []OrderItemModifier) []OrderItemModifier {
+	copies := make([]OrderItemModifier, 0, len(modifiers))
+	for _, modifier := range modifiers {
+		modifier.ID, modifier.OrderItemID = 0, 0
+		copies = append(copies, modifier)
+	}
+	return copies
+}
+
+// GetProductModifierPicker devuelve los campos para elegir los modificadores al agregar un producto
+// y, si es un combo, los productos de cada componente
+func GetProductModifierPicker(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).SendString("ID inválido")
+	}
+	return c.Render("partials/modifier_picker", fiber.Map{
+		"Slots":  comboPickerSlots(db, uint(id)),
+		"Groups": loadModifierGroups(db, uint(id)),
+	}, "")
+}
+
+// productModifiersData arma los datos del partial de administración de modificadores
+func productModifiersData(productID uint) (fiber.Map, error) {
+	var product Product
+	if err := db.First(&product, productID).Error; err != nil {
+		return nil, err
+	}
+	return fiber.Map{
+		"Product": product,
+		"Groups":  loadModifierGroups(db, productID),
+	}, nil
+}
+
+// renderProductModifiers responde con la administración de modificadores del producto
+func renderProductModifiers(c *fiber.Ctx, productID uint) error {
+	data, err := productModifiersData(productID)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).SendString("Producto no encontrado")
+	}
+	return c.Render("partials/product_modifiers", data, "")
+}
+
+// GetProductModifiers muestra los grupos de modificadores de un producto para editarlos
+func GetProductModifiers(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).SendString("ID inválido")
+	}
+	return renderProductModifiers(c, uint(id))
+}
+
+// CreateModifierGroup agrega un grupo de modificadores al final de los del producto
+func CreateModifierGroup(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return Error(c, "ID de producto inválido", fiber.StatusBadRequest)
+	}
+	var product Product
+	if result := db.First(&product, id); result.Error != nil {
+		return Error(c, "Producto no encontrado", fiber.StatusNotFound)
+	}
+
+	group := ModifierGroup{
+		ProductID: product.ID,
+		Name:      strings.TrimSpace(c.FormValue("name")),
+		Required:  c.FormValue("required") == "on",
+		Multiple:  c.FormValue("multiple") == "on",
+	}
+	if group.Name == "" {
+		return Error(c, "El nombre del grupo es obligatorio", fiber.StatusBadRequest)
+	}
+	db.Model(&ModifierGroup{}).Where("product_id = ?", product.ID).Select("COALESCE(MAX(position), 0) + 1").Scan(&group.Position)
+	if err := db.Create(&group).Error; err != nil {
+		return Error(c, "Error al crear el grupo", fiber.StatusInternalServerError)
+	}
+
+	c.Set("HX-Trigger", `{"showToast": "Grupo agregado", "toastType": "success"}`)
+	return renderProductModifiers(c, product.ID)
+}
+
+// DeleteModifierGroup elimina un grupo con sus opciones; los ítems ya pedidos conservan su copia
+func DeleteModifierGroup(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return Error(c, "ID de grupo inválido", fiber.StatusBadRequest)
+	}
+	var group ModifierGroup
+	if result := db.First(&group, id); result.Error != nil {
+		return Error(c, "Grupo no encontrado", fiber.StatusNotFound)
+	}
+	if err := db.Delete(&group).Error; err != nil {
+		return Error(c, "Error al eliminar el grupo", fiber.StatusInternalServerError)
+	}
+
+	c.Set("HX-Trigger", `{"showToast": "Grupo eliminado", "toastType": "success"}`)
+	return renderProductModifiers(c, group.ProductID)
+}
+
+// CreateModifierOption agrega una opción al final de un grupo
+func CreateModifierOption(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return Error(c, "ID de grupo inválido", fiber.StatusBadRequest)
+	}
+	var group ModifierGroup
+	if result := db.First(&group, id); result.Error != nil {
+		return Error(c, "Grupo no encontrado", fiber.StatusNotFound)
+	}
+
+	option := ModifierOption{GroupID: group.ID, Name: strings.TrimSpace(c.FormValue("name"))}
+	if option.Name == "" {
+		return Error(c, "El nombre de la opción es obligatorio", fiber.StatusBadRequest)
+	}
+	if value := strings.TrimSpace(c.FormValue("price_delta")); value != "" {
+		if option.PriceDelta, err = ParseMoney(value); err != nil {
+			return Error(c, "Diferencia de precio inválida", fiber.StatusBadRequest)
+		}
+	}
+	db.Model(&ModifierOption{}).Where("group_id = ?", group.ID).Select("COALESCE(MAX(position), 0) + 1").Scan(&option.Position)
+	if err := db.Create(&option).Error; err != nil {
+		return Error(c, "Error al crear la opción", fiber.StatusInternalServerError)
+	}
+
+	return renderProductModifiers(c, group.ProductID)
+}
+
+// DeleteModifierOption elimina una opción de un grupo
+func DeleteModifierOption(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return Error(c, "ID de opción inválido", fiber.StatusBadRequest)
+	}
+	var option ModifierOption
+	if result := db.First(&option, id); result.Error != nil {
+		return Error(c, "Opción no encontrada", fiber.StatusNotFound)
+	}
+	var group ModifierGroup
+	db.First(&group, option.GroupID)
+	if err := db.Delete(&option).Error; err != nil {
+		return Error(c, "Error al eliminar la opción", fiber.StatusInternalServerError)
+	}
+
+	return renderProductModifiers(c, group.ProductID)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Money es un importe en centavos (unidades menores de la moneda).
+// Todos los importes se guardan y suman como enteros para evitar los errores
+// de redondeo de float64; solo se convierten a decimal al mostrarlos.
+type Money int64
+
+// String devuelve el importe con dos decimales, por ejemplo "1234.50"
+func (m Money) String() string {
+	sign := ""
+	if m < 0 {
+		sign = "-"
+		m = -m
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, int64(m)/100, int64(m)%100)
+}
+
+// FormatMoney muestra un importe con el símbolo de moneda de la configuración, por ejemplo "$12.50"
+func (s Settings) FormatMoney(amount Money) string {
+	return s.CurrencySymbol + amount.String()
+}
+
+// Mul multiplica el importe por una cantidad de unidades
+func (m Money) Mul(quantity int) Money {
+	return m * Money(quantity)
+}
+
+// MulRate aplica una tasa (por ejemplo 0.16) redondeando al centavo más cercano
+func (m Money) MulRate(rate float64) Money {
+	return Money(math.Round(float64(m) * rate))
+}
+
+// MarshalJSON expresa el importe como número decimal (12.50) para mantener el formato de la API
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// UnmarshalJSON acepta un número o una cadena decimal
+func (m *Money) UnmarshalJSON(data []byte) error {
+	value, err := ParseMoney(strings.Trim(string(data), `"`))
+	if err != nil {
+		return err
+	}
+	*m = value
+	return nil
+}
+
+// ParseMoney convierte un texto decimal ("12", "12.5", "12.50") en centavos sin pasar por float64
+func ParseMoney(s string) (Money, error) {
+	s = strings.TrimSpace(s)
+	negative := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(s, "-")
+
+	whole, fraction, _ := strings.Cut(s, ".")
+	if !isDigits(whole) || !isDigits(fraction) || whole+fraction == "" {
+		return 0, fmt.Errorf("importe inválido %q", s)
+	}
+	if whole == "" {
+		whole = "0"
+	}
+	if len(fraction) > 2 {
+		return 0, fmt.Errorf("importe inválido %q: más de dos decimales", s)
+	}
+	fraction += strings.Repeat("0", 2-len(fraction))
+
+	units, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil || units > math.MaxInt64/100-1 {
+		return 0, fmt.Errorf("importe inválido %q", s)
+	}
+	cents, err := strconv.ParseInt(fraction, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("importe inválido %q", s)
+	}
+
+	amount := Money(units*100 + cents)
+	if negative {
+		amount = -amount
+	}
+	return amount, nil
+}
+
+// isDigits indica si s contiene solo dígitos ASCII; ParseInt aceptaría también signos
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// formatRate muestra una tasa como porcentaje, por ejemplo 0.16 -> "16" y 0.105 -> "10.5"
+func formatRate(rate float64) string {
+	return strconv.FormatFloat(math.Round(rate*10000)/100, 'f', -1, 64)
+}
@@ -0,0 +1,76 @@
+package main
+
+import "testing"
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		input string
+		want  Money
+		ok    bool
+	}{
+		{"12", 1200, true},
+		{"12.5", 1250, true},
+		{"12.50", 1250, true},
+		{"0.05", 5, true},
+		{".5", 50, true},
+		{"7.", 700, true},
+		{" 3.25 ", 325, true},
+		{"-5", -500, true},
+		{"-0.75", -75, true},
+		{"", 0, false},
+		{".", 0, false},
+		{"-", 0, false},
+		{"+5", 0, false},
+		{"--5", 0, false},
+		{"1.+5", 0, false},
+		{"1.-5", 0, false},
+		{"-+5", 0, false},
+		{"1.234", 0, false},
+		{"1,50", 0, false},
+		{"1.5.0", 0, false},
+		{"abc", 0, false},
+		{"1e3", 0, false},
+		{"99999999999999999999", 0, false},
+	}
+	for _, tt := range tests {
+		got, err := ParseMoney(tt.input)
+		if tt.ok && err != nil {
+			t.Errorf("ParseMoney(%q): error inesperado %v", tt.input, err)
+			continue
+		}
+		if !tt.ok && err == nil {
+			t.Errorf("ParseMoney(%q) = %v, se esperaba un error", tt.input, got)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseMoney(%q) = %v, se esperaba %v", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestMoneyString(t *testing.T) {
+	tests := []struct {
+		amount Money
+		want   string
+	}{
+		{0, "0.00"},
+		{5, "0.05"},
+		{1250, "12.50"},
+		{-75, "-0.75"},
+		{-123456, "-1234.56"},
+	}
+	for _, tt := range tests {
+		if got := tt.amount.String(); got != tt.want {
+			t.Errorf("Money(%d).String() = %q, se esperaba %q", int64(tt.amount), got, tt.want)
+		}
+	}
+}
+
+func TestSettingsFormatMoney(t *testing.T) {
+	if got := (Settings{CurrencySymbol: "€"}).FormatMoney(1250); got != "€12.50" {
+		t.Errorf("FormatMoney = %q, se esperaba €12.50", got)
+	}
+	if got := (Settings{CurrencySymbol: "$"}).FormatMoney(-75); got != "$-0.75" {
+		t.Errorf("FormatMoney = %q, se esperaba $-0.75", got)
+	}
+}
@@ -1,13 +1,17 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"log"
+	"net/mail"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // CreateOrder crea una nueva orden para una mesa
@@ -17,35 +21,45 @@ func CreateOrder(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusBadRequest).SendString("Número de mesa inválido")
 	}
 
-	// Verificar que la mesa exista y no esté ocupada
-	var table Table
-	if result := db.Where("number = ?", tableNum).First(&table); result.Error != nil {
-		return c.Status(fiber.StatusNotFound).SendString("Mesa no encontrada")
-	}
-
-	if table.Occupied {
-		return c.Status(fiber.StatusBadRequest).SendString("La mesa ya está ocupada")
-	}
-
-	// Crear la orden directamente con estado "in_progress"
+	// La orden se crea pendiente; pasa a cocina con ProcessOrder
 	order := Order{
-		TableNum:  tableNum,
-		Status:    "pending",
-		Total:     0,
-		Notes:     c.FormValue("notes"),
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
-	}
+		TableNum:    tableNum,
+		Status:      StatusPending,
+		Total:       0,
+		Notes:       c.FormValue("notes"),
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
+		CreatedByID: currentUserID(c),
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		// Bloquear la mesa para que dos meseros no abran órdenes en ella a la vez
+		var table Table
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("number = ?", tableNum).First(&table).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return fiber.NewError(fiber.StatusNotFound, "Mesa no encontrada")
+			}
+			return err
+		}
+		if table.Occupied {
+			return fiber.NewError(fiber.StatusBadRequest, "La mesa ya está ocupada")
+		}
 
-	if err := db.Create(&order).Error; err != nil {
-		log.Printf("Error al crear la orden: %v", err)
-		return c.Status(fiber.StatusInternalServerError).SendString("Error al crear la orden")
-	}
+		if err := tx.Create(&order).Error; err != nil {
+			return err
+		}
+		if err := recordStatusChange(tx, order.ID, "", order.Status, order.CreatedByID, ""); err != nil {
+			return err
+		}
 
-	// Marcar la mesa como ocupada y vincular la orden
-	table.Occupied = true
-	table.OrderID = &order.ID
-	db.Save(&table)
+		// Marcar la mesa como ocupada y vincular la orden
+		table.Occupied = true
+		table.OrderID = &order.ID
+		return tx.Save(&table).Error
+	})
+	if err != nil {
+		return orderErrorResponse(c, err)
+	}
 
 	// Redireccionar a la página de edición de la orden
 	c.Set("HX-Redirect", fmt.Sprintf("/order/%d", order.ID))
@@ -61,7 +75,7 @@ func OrdersHandler(c *fiber.Ctx) error {
 
 	switch status {
 	case "active":
-		query = query.Where("status IN ?", []string{"pending", "in_progress", "ready", "to_pay"})
+		query = query.Where("status IN ?", activeOrderStatuses)
 	case "completed":
 		query = query.Where("status = ?", "completed")
 	case "cancelled":
@@ -69,7 +83,7 @@ func OrdersHandler(c *fiber.Ctx) error {
 	case "all":
 		// no filter
 	default:
-		query = query.Where("status IN ?", []string{"pending", "in_progress", "ready", "to_pay"})
+		query = query.Where("status IN ?", activeOrderStatuses)
 	}
 
 	if search != "" {
@@ -77,7 +91,7 @@ func OrdersHandler(c *fiber.Ctx) error {
 	}
 
 	var orders []Order
-	query.Order("created_at desc").Preload("Items").Preload("Items.Product").Find(&orders)
+	query.Order("created_at desc").Preload("Items", orderLines).Preload("Items.Product").Find(&orders)
 
 	// Obtener mesas disponibles para el modal de nueva orden
 	var availableTables []Table
@@ -100,54 +114,60 @@ func UpdateOrderItem(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusBadRequest).SendString("ID de ítem inválido")
 	}
 
-	var item OrderItem
-	if err := db.First(&item, itemID).Error; err != nil {
-		return c.Status(fiber.StatusNotFound).SendString("Ítem no encontrado")
-	}
-
-	// Get the form values
 	quantity, err := strconv.Atoi(c.FormValue("quantity"))
 	if err != nil || quantity < 1 {
 		return c.Status(fiber.StatusBadRequest).SendString("Cantidad inválida")
 	}
 
-	// Update the item
-	item.Quantity = quantity
-	item.Notes = c.FormValue("notes")
-	if err := db.Save(&item).Error; err != nil {
-		return c.Status(fiber.StatusInternalServerError).SendString("Error al actualizar el ítem")
+	var item OrderItem
+	if err := db.First(&item, itemID).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).SendString("Ítem no encontrado")
 	}
 
-	// Recalcular total de la orden
 	var order Order
-	if err := db.First(&order, item.OrderID).Error; err != nil {
-		return c.Status(fiber.StatusNotFound).SendString("Orden no encontrada")
-	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := lockOrder(tx, item.OrderID, &order); err != nil {
+			return err
+		}
+		if !isOrderEditable(order.Status) {
+			return errOrderClosed(order)
+		}
+
+		// Releer el ítem con la orden bloqueada por si otro mesero lo modificó
+		if err := tx.Where("order_id = ?", order.ID).First(&item, itemID).Error; err != nil {
+			return fiber.NewError(fiber.StatusNotFound, "Ítem no encontrado")
+		}
+		if item.ParentItemID != nil {
+			return errComboComponent
+		}
+		oldQuantity := item.Quantity
 
-	var allItems []OrderItem
-	db.Where("order_id = ?", item.OrderID).Preload("Product").Find(&allItems)
+		item.Quantity = quantity
+		item.Notes = c.FormValue("notes")
+		if err := tx.Save(&item).Error; err != nil {
+			return err
+		}
+		if err := syncComponents(tx, item); err != nil {
+			return err
+		}
+		if quantity != oldQuantity {
+			if err := recordItemEvent(tx, EventItemQuantity, item, oldQuantity, quantity, currentUserID(c), ""); err != nil {
+				return err
+			}
+		}
 
-	total := 0.0
-	for _, i := range allItems {
-		total += i.Product.Price * float64(i.Quantity)
+		if err := recalculateOrderTotals(tx, &order); err != nil {
+			return err
+		}
+		return deductSentOrderStock(tx, order, currentUserID(c))
+	})
+	if err != nil {
+		return orderErrorResponse(c, err)
 	}
-
-	order.Total = total
-	db.Save(&order)
+	broadcastOrderUpdate(order)
 
 	c.Set("HX-Trigger", `{"showToast": "Ítem actualizado"}`)
-
-	// Cargar la orden completa con sus items para la vista actualizada
-	// Agregamos ORDER BY id para mantener un orden consistente
-	db.Preload("Items", func(db *gorm.DB) *gorm.DB {
-		return db.Order("id ASC")
-	}).Preload("Items.Product").First(&order, item.OrderID)
-
-	// Return the updated order items with the OrderID explicitly included
-	return c.Render("partials/order_items", fiber.Map{
-		"Order":   order,
-		"OrderID": order.ID,
-	}, "")
+	return renderOrderItems(c, order.ID)
 }
 
 // RemoveOrderItem elimina un item de la orden
@@ -162,39 +182,14 @@ func RemoveOrderItem(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusBadRequest).SendString("ID de ítem inválido")
 	}
 
-	// Buscar el item para obtener información antes de eliminarlo
-	var orderItem OrderItem
-	if err := db.Preload("Product").First(&orderItem, itemID).Error; err != nil {
-		return c.Status(fiber.StatusNotFound).SendString("Ítem no encontrado")
-	}
-
-	// Verificar que pertenezca a esta orden
-	if orderItem.OrderID != uint(orderID) {
-		return c.Status(fiber.StatusBadRequest).SendString("Este ítem no pertenece a la orden especificada")
-	}
-
-	// Actualizar total de la orden
-	var order Order
-	db.First(&order, orderID)
-	order.Total -= orderItem.Product.Price * float64(orderItem.Quantity)
-	if order.Total < 0 {
-		order.Total = 0 // Evitar totales negativos
+	order, err := removeItem(uint(orderID), uint(itemID), currentUserID(c), actionReason(c))
+	if err != nil {
+		return orderErrorResponse(c, err)
 	}
-	db.Save(&order)
-
-	// Eliminar el item
-	db.Delete(&orderItem)
-
-	// Cargar la orden actualizada con sus items, manteniendo el orden por ID
-	db.Preload("Items", func(db *gorm.DB) *gorm.DB {
-		return db.Order("id ASC")
-	}).Preload("Items.Product").First(&order, orderID)
+	broadcastOrderUpdate(order)
 
 	c.Set("HX-Trigger", `{"showToast": "Producto eliminado de la orden"}`)
-	return c.Render("partials/order_items", fiber.Map{
-		"Order":   order,
-		"OrderID": order.ID,
-	}, "")
+	return renderOrderItems(c, order.ID)
 }
 
 func GetOrder(c *fiber.Ctx) error {
@@ -204,37 +199,57 @@ func GetOrder(c *fiber.Ctx) error {
 	}
 
 	var order Order
-	result := db.Preload("Items", func(db *gorm.DB) *gorm.DB {
-		return db.Order("id ASC")
-	}).Preload("Items.Product").First(&order, id)
+	result := preloadPayments(db).Preload("Items", func(db *gorm.DB) *gorm.DB {
+		return orderLines(db).Order("id ASC")
+	}).Preload("Items.Product").
+		Preload("Items.AddedBy", unscopedUsers).
+		Preload("Items.PreparedBy", unscopedUsers).
+		Preload("CreatedBy", unscopedUsers).
+		Preload("SentToKitchenBy", unscopedUsers).
+		Preload("DeliveredBy", unscopedUsers).
+		Preload("CompletedBy", unscopedUsers).
+		Preload("CancelledBy", unscopedUsers).
+		First(&order, id)
 
 	if result.Error != nil {
 		return c.Status(fiber.StatusNotFound).SendString("Orden no encontrada")
 	}
 
-	// Obtener productos disponibles para añadir
+	// Obtener productos disponibles de las categorías activas, en el orden del menú
 	var products []Product
-	db.Where("is_available = ?", true).Order("category, name").Find(&products)
+	db.Joins("JOIN categories ON categories.id = products.category_id AND categories.active = ?", true).
+		Where("products.is_available = ?", true).
+		Order("categories.position, categories.name, products.name").
+		Find(&products)
 
 	// Agrupar productos por categoría
 	productsByCategory := make(map[string][]Product)
+	var categories []string
 	for _, product := range products {
+		if _, ok := productsByCategory[product.Category]; !ok {
+			categories = append(categories, product.Category)
+		}
 		productsByCategory[product.Category] = append(productsByCategory[product.Category], product)
 	}
 
-	// Obtener categorías ordenadas
-	var categories []string
-	db.Model(&Product{}).Where("is_available = ?", true).Distinct().Order("category").Pluck("category", &categories)
-
-	// Recalcular total por si acaso
-	total := 0.0
-	for _, item := range order.Items {
-		total += item.Product.Price * float64(item.Quantity)
+	// Recalcular el desglose por si cambió la configuración de impuestos (solo órdenes abiertas
+	// cuyas cuentas separadas no tengan pagos, ver recalculateOrderTotals)
+	if paid, _ := hasPaidChecks(db, order.ID); isOrderEditable(order.Status) && !paid {
+		settings := loadSettings(db)
+		pricing := priceOrder(order.Items, order.Tip, settings)
+		if pricing.Total != order.Total || pricing.TaxAmount != order.TaxAmount {
+			if err := recalculateOrderTotals(db, &order); err != nil {
+				log.Printf("Error al recalcular el total de la orden #%d: %v", order.ID, err)
+			}
+		}
 	}
 
-	if total != order.Total {
-		order.Total = total
-		db.Save(&order)
+	// Mesas libres y otras órdenes abiertas para cambiar de mesa o unir órdenes
+	var freeTables []Table
+	var openOrders []Order
+	if isOrderEditable(order.Status) {
+		db.Where("occupied = ?", false).Order("number").Find(&freeTables)
+		db.Where("status IN ? AND id <> ?", activeOrderStatuses, order.ID).Order("table_num").Find(&openOrders)
 	}
 
 	return c.Render("order", fiber.Map{
@@ -249,10 +264,14 @@ func GetOrder(c *fiber.Ctx) error {
 		"TableNum":           order.TableNum,
 		"Total":              order.Total,
 		"ItemCount":          len(order.Items),
+		"PaymentData":        orderPaymentsData(c, order),
+		"FreeTables":         freeTables,
+		"OpenOrders":         openOrders,
+		"EmailDeliveries":    emailDeliveriesData(order.ID),
 	})
 }
 
-// CompleteOrder marca una orden como completada
+// CompleteOrder marca una orden por cobrar como completada
 func CompleteOrder(c *fiber.Ctx) error {
 	id, err := strconv.Atoi(c.Params("id"))
 	if err != nil {
@@ -266,77 +285,11 @@ func CompleteOrder(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusNotFound).SendString("Orden no encontrada")
 	}
 
-	// Verificar que la orden esté en proceso
-	if order.Status != "in_progress" {
-		log.Printf("La orden #%d no está en proceso", id)
-		return c.Status(fiber.StatusBadRequest).SendString("Solo se pueden completar órdenes en proceso")
-	}
-
-	// Refuerzo: asegurar que todos los ítems tengan CookingFinished y CookingTime
-	var items []OrderItem
-	db.Where("order_id = ?", order.ID).Find(&items)
-	now := time.Now()
-	allReady := true
-	for _, item := range items {
-		if !item.IsReady {
-			item.IsReady = true
-			if item.CookingStarted == nil {
-				item.CookingStarted = &now
-			}
-			item.CookingFinished = &now
-			cookingTime := int(now.Sub(*item.CookingStarted).Seconds())
-			if cookingTime < 0 {
-				cookingTime = 0
-			}
-			item.CookingTime = cookingTime
-		}
-		if item.DeliveredAt == nil {
-			item.DeliveredAt = &now
-		}
-		db.Save(&item)
-		if !item.IsReady {
-			allReady = false
-		}
-	}
-	// Si todos los ítems están listos, marcar CookingCompletedAt
-	if allReady && order.CookingCompletedAt == nil {
-		order.CookingCompletedAt = &now
-	}
-	// Marcar la orden como completada
-	log.Printf("Marcando orden #%d como completada", id)
-	order.Status = "completed"
-	order.UpdatedAt = now
-	if order.CompletedAt == nil {
-		order.CompletedAt = &now
-	}
-	if order.DeliveredAt == nil {
-		order.DeliveredAt = &now
-	}
-	if err := db.Save(&order).Error; err != nil {
-		log.Printf("Error al actualizar estado de orden: %v", err)
-		return c.Status(fiber.StatusInternalServerError).SendString("Error al completar la orden")
-	}
-
-	// Liberar la mesa asociada
-	if err := db.Model(&Table{}).Where("number = ?", order.TableNum).Updates(map[string]interface{}{
-		"occupied": false,
-		"order_id": nil,
-	}).Error; err != nil {
-		log.Printf("Error al liberar mesa: %v", err)
-	} else {
-		log.Printf("Mesa %d liberada", order.TableNum)
+	if err := TransitionOrder(&order, StatusCompleted, currentUserID(c), ""); err != nil {
+		return orderErrorResponse(c, err)
 	}
 
-	// --- Notificación WebSocket ---
-	wsBroadcast <- WSMessage{
-		Type:    "order_update",
-		Payload: order,
-	}
-	wsBroadcast <- WSMessage{
-		Type:    "kitchen_update",
-		Payload: order,
-	}
-	// -----------------------------
+	autoPrintReceipt(order.ID)
 
 	c.Set("HX-Trigger", `{"showToast": "Orden completada correctamente"}`)
 	c.Set("HX-Redirect", "/orders")
@@ -357,42 +310,10 @@ func CancelOrder(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusNotFound).SendString("Orden no encontrada")
 	}
 
-	// No permitir cancelar órdenes completadas
-	if order.Status == "completed" {
-		log.Printf("La orden #%d ya está completada y no se puede cancelar", id)
-		return c.Status(fiber.StatusBadRequest).SendString("No se pueden cancelar órdenes completadas")
-	}
-
-	// Marcar la orden como cancelada
-	log.Printf("Cancelando orden #%d", id)
-	order.Status = "cancelled"
-	order.UpdatedAt = time.Now()
-	if err := db.Save(&order).Error; err != nil {
-		log.Printf("Error al cancelar orden: %v", err)
-		return c.Status(fiber.StatusInternalServerError).SendString("Error al cancelar la orden")
-	}
-
-	// Liberar la mesa asociada
-	if err := db.Model(&Table{}).Where("number = ?", order.TableNum).Updates(map[string]interface{}{
-		"occupied": false,
-		"order_id": nil,
-	}).Error; err != nil {
-		log.Printf("Error al liberar mesa: %v", err)
-	} else {
-		log.Printf("Mesa %d liberada", order.TableNum)
+	if err := TransitionOrder(&order, StatusCancelled, currentUserID(c), actionReason(c)); err != nil {
+		return orderErrorResponse(c, err)
 	}
 
-	// --- Notificación WebSocket ---
-	wsBroadcast <- WSMessage{
-		Type:    "order_update",
-		Payload: order,
-	}
-	wsBroadcast <- WSMessage{
-		Type:    "kitchen_update",
-		Payload: order,
-	}
-	// -----------------------------
-
 	c.Set("HX-Trigger", `{"showToast": "Orden cancelada"}`)
 	c.Set("HX-Redirect", "/orders")
 	return c.SendString("Orden cancelada correctamente")
@@ -417,70 +338,147 @@ func AddItemToOrder(c *fiber.Ctx) error {
 
 	notes := c.FormValue("notes")
 
-	// Verificar que existan orden y producto
-	var order Order
-	if result := db.First(&order, orderID); result.Error != nil {
-		return c.Status(fiber.StatusNotFound).SendString("Orden no encontrada")
+	// Asiento del comensal; 0 o vacío = compartido
+	seat, err := strconv.Atoi(c.FormValue("seat", "0"))
+	if err != nil || seat < 0 {
+		seat = 0
 	}
 
 	var product Product
 	if result := db.First(&product, productID); result.Error != nil {
 		return c.Status(fiber.StatusNotFound).SendString("Producto no encontrado")
 	}
+	if !product.IsAvailable {
+		return Error(c, product.Name+" no está disponible", fiber.StatusBadRequest)
+	}
 
-	// Buscar ítem existente, pero sin usar log cuando no se encuentra
-	var existingItem OrderItem
-	var count int64
-	db.Model(&OrderItem{}).Where("order_id = ? AND product_id = ?", orderID, productID).Count(&count)
+	// Modificadores elegidos; su diferencia de precio va en el precio unitario
+	modifiers, err := resolveModifiers(loadModifierGroups(db, product.ID), formModifierOptions(c))
+	if err != nil {
+		return Error(c, "No se pudo agregar: "+err.Error(), fiber.StatusBadRequest)
+	}
+	var priced OrderItem
+	priced.snapshotProduct(product)
+	priced.applyModifiers(modifiers)
 
-	if count > 0 {
-		// El producto ya existe, actualizar cantidad y notas
-		db.Where("order_id = ? AND product_id = ?", orderID, productID).First(&existingItem)
-		existingItem.Quantity += quantity
-		existingItem.Notes = notes
-		if err := db.Save(&existingItem).Error; err != nil {
-			log.Printf("Error al actualizar ítem existente: %v", err)
-			return c.Status(fiber.StatusInternalServerError).SendString("Error al actualizar el ítem")
+	// Si es un combo, los productos elegidos para cada componente
+	var components []OrderItem
+	if slots := loadComboSlots(db, product.ID); len(slots) > 0 {
+		if components, err = resolveCombo(db, slots, formComboChoices(c)); err != nil {
+			return Error(c, "No se pudo agregar: "+err.Error(), fiber.StatusBadRequest)
 		}
-		log.Printf("Actualizado producto #%d en orden #%d, nueva cantidad: %d", productID, orderID, existingItem.Quantity)
-	} else {
-		// Crear un nuevo item
-		log.Printf("Agregando producto #%d a la orden #%d, cantidad: %d", productID, orderID, quantity)
-		newItem := OrderItem{
-			OrderID:   uint(orderID),
-			ProductID: uint(productID),
-			Quantity:  quantity,
-			Notes:     notes,
-			CreatedAt: time.Now(),
-			UpdatedAt: time.Now(),
+		priced.applyCombo(components)
+	}
+
+	var order Order
+	var added OrderItem         // Lo agregado
+	var ticketItems []OrderItem // Sus líneas para la comanda de adición
+	sentToKitchen := false
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := lockOrder(tx, uint(orderID), &order); err != nil {
+			return err
 		}
-		if err := db.Create(&newItem).Error; err != nil {
-			log.Printf("Error al crear nuevo ítem: %v", err)
-			return c.Status(fiber.StatusInternalServerError).SendString("Error al añadir el producto")
+		if !isOrderEditable(order.Status) {
+			return errOrderClosed(order)
 		}
-	}
+		sentToKitchen = order.SentToKitchenAt != nil
 
-	// Actualizar total de la orden
-	var items []OrderItem
-	db.Where("order_id = ?", orderID).Preload("Product").Find(&items)
+		// El inventario pudo agotar el producto o un componente después de mostrar la página
+		productIDs := []uint{product.ID}
+		for _, component := range components {
+			productIDs = append(productIDs, component.ProductID)
+		}
+		if err := checkProductsAvailable(tx, productIDs); err != nil {
+			return err
+		}
 
-	total := 0.0
-	for _, item := range items {
-		total += item.Product.Price * float64(item.Quantity)
-	}
+		// Buscar un ítem existente del mismo producto, modificadores, componentes, precio y asiento que
+		// cocina aún no terminó; si ya está listo o cambió el precio, lo agregado va en un ítem nuevo
+		var existingItem OrderItem
+		result := orderLines(tx).Where("order_id = ? AND product_id = ? AND modifier_key = ? AND unit_price = ? AND seat = ? AND is_ready = ?", order.ID, productID, priced.ModifierKey, priced.UnitPrice, seat, false).Limit(1).Find(&existingItem)
+		if result.Error != nil {
+			return result.Error
+		}
 
-	order.Total = total
-	if err := db.Save(&order).Error; err != nil {
-		log.Printf("Error al actualizar total de orden: %v", err)
+		if result.RowsAffected > 0 {
+			// El producto ya existe, actualizar cantidad y notas
+			oldQuantity := existingItem.Quantity
+			existingItem.Quantity += quantity
+			existingItem.Notes = notes
+			if err := tx.Save(&existingItem).Error; err != nil {
+				return err
+			}
+			if err := syncComponents(tx, existingItem); err != nil {
+				return err
+			}
+			if err := recordItemEvent(tx, EventItemQuantity, existingItem, oldQuantity, existingItem.Quantity, currentUserID(c), ""); err != nil {
+				return err
+			}
+			added = existingItem
+			added.Quantity = quantity
+			log.Printf("Actualizado producto #%d en orden #%d, nueva cantidad: %d", productID, orderID, existingItem.Quantity)
+		} else {
+			// Crear un nuevo item
+			log.Printf("Agregando producto #%d a la orden #%d, cantidad: %d", productID, orderID, quantity)
+			newItem := OrderItem{
+				OrderID:   order.ID,
+				ProductID: uint(productID),
+				Quantity:  quantity,
+				Notes:     notes,
+				Seat:      seat,
+				CreatedAt: time.Now(),
+				UpdatedAt: time.Now(),
+				AddedByID: currentUserID(c),
+			}
+			newItem.snapshotProduct(product)
+			newItem.applyModifiers(copyModifiers(modifiers))
+			if components != nil {
+				newItem.applyCombo(components)
+			}
+			// Si la orden ya fue enviada a cocina, el nuevo ítem empieza a prepararse ahora
+			if order.SentToKitchenAt != nil {
+				newItem.CookingStarted = ptrTime(time.Now())
+			}
+			if err := tx.Create(&newItem).Error; err != nil {
+				return err
+			}
+			if err := createComponents(tx, newItem, components); err != nil {
+				return err
+			}
+			if err := recordItemEvent(tx, EventItemAdded, newItem, 0, quantity, currentUserID(c), ""); err != nil {
+				return err
+			}
+			added = newItem
+		}
+		if ticketItems, err = comboTicketItems(tx, added); err != nil {
+			return err
+		}
+
+		if err := recalculateOrderTotals(tx, &order); err != nil {
+			return err
+		}
+		if err := deductSentOrderStock(tx, order, currentUserID(c)); err != nil {
+			return err
+		}
+
+		// Una orden lista o por cobrar vuelve a cocina cuando se agregan productos
+		if order.Status == StatusReady || order.Status == StatusToPay {
+			return applyTransition(tx, &order, StatusInProgress, currentUserID(c), "Productos agregados")
+		}
+		return nil
+	})
+	if err != nil {
+		return orderErrorResponse(c, err)
 	}
+	broadcastOrderUpdate(order)
 
-	// Devolver la vista actualizada
-	db.Preload("Items").Preload("Items.Product").First(&order, orderID)
+	// Si la orden ya estaba en cocina, solo se imprime lo nuevo
+	if sentToKitchen {
+		printKitchenTickets(order, ticketItems, true)
+	}
 
 	c.Set("HX-Trigger", `{"showToast": "Producto añadido a la orden"}`)
-	return c.Render("partials/order_items", fiber.Map{
-		"Order": order,
-	}, "")
+	return renderOrderItems(c, order.ID)
 }
 
 // RemoveItemFromOrder elimina un item de la orden
@@ -495,36 +493,50 @@ func RemoveItemFromOrder(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusBadRequest).SendString("ID de item inválido")
 	}
 
-	// Buscar el item para obtener información antes de eliminarlo
-	var orderItem OrderItem
-	if result := db.Preload("Product").First(&orderItem, itemID); result.Error != nil {
-		return c.Status(fiber.StatusNotFound).SendString("Item no encontrado")
+	order, err := removeItem(uint(orderID), uint(itemID), currentUserID(c), actionReason(c))
+	if err != nil {
+		return orderErrorResponse(c, err)
 	}
+	broadcastOrderUpdate(order)
 
-	// Verificar que pertenezca a esta orden
-	if orderItem.OrderID != uint(orderID) {
-		return c.Status(fiber.StatusBadRequest).SendString("El item no pertenece a esta orden")
-	}
+	c.Set("HX-Trigger", `{"showToast": "Producto eliminado de la orden"}`)
+	return renderOrderItems(c, order.ID)
+}
 
-	// Actualizar total de la orden
+// removeItem elimina un ítem de la orden en una transacción con la orden bloqueada
+// y recalcula el total. Devuelve la orden actualizada.
+func removeItem(orderID, itemID uint, actorID *uint, reason string) (Order, error) {
 	var order Order
-	db.First(&order, orderID)
-	order.Total -= orderItem.Product.Price * float64(orderItem.Quantity)
-	if order.Total < 0 {
-		order.Total = 0 // Evitar totales negativos
-	}
-	db.Save(&order)
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := lockOrder(tx, orderID, &order); err != nil {
+			return err
+		}
+		if !isOrderEditable(order.Status) {
+			return errOrderClosed(order)
+		}
 
-	// Eliminar el item
-	db.Delete(&orderItem)
+		var item OrderItem
+		if err := tx.First(&item, itemID).Error; err != nil {
+			return fiber.NewError(fiber.StatusNotFound, "Ítem no encontrado")
+		}
+		if item.OrderID != order.ID {
+			return fiber.NewError(fiber.StatusBadRequest, "El ítem no pertenece a esta orden")
+		}
+		if item.ParentItemID != nil {
+			return errComboComponent
+		}
 
-	// Cargar la orden actualizada con sus items
-	db.Preload("Items").Preload("Items.Product").First(&order, orderID)
+		// Los componentes de un combo se borran con él
+		if err := tx.Delete(&item).Error; err != nil {
+			return err
+		}
+		if err := recordItemEvent(tx, EventItemRemoved, item, item.Quantity, 0, actorID, reason); err != nil {
+			return err
+		}
 
-	c.Set("HX-Trigger", `{"showToast": "Producto eliminado de la orden"}`)
-	return c.Render("partials/order_items", fiber.Map{
-		"Order": order,
-	}, "")
+		return recalculateOrderTotals(tx, &order)
+	})
+	return order, err
 }
 
 // UpdateOrderItemQuantity actualiza la cantidad de un ítem
@@ -544,56 +556,94 @@ func UpdateOrderItemQuantity(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusBadRequest).SendString("Acción inválida")
 	}
 
-	// Encontrar el item
-	var item OrderItem
-	if err := db.First(&item, itemID).Error; err != nil {
-		return c.Status(fiber.StatusNotFound).SendString("Ítem no encontrado")
-	}
+	var order Order
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := lockOrder(tx, uint(orderID), &order); err != nil {
+			return err
+		}
+		if !isOrderEditable(order.Status) {
+			return errOrderClosed(order)
+		}
 
-	// Verificar que el item pertenezca a esta orden
-	if item.OrderID != uint(orderID) {
-		return c.Status(fiber.StatusBadRequest).SendString("El ítem no pertenece a esta orden")
-	}
+		var item OrderItem
+		if err := tx.First(&item, itemID).Error; err != nil {
+			return fiber.NewError(fiber.StatusNotFound, "Ítem no encontrado")
+		}
+		if item.OrderID != order.ID {
+			return fiber.NewError(fiber.StatusBadRequest, "El ítem no pertenece a esta orden")
+		}
+		if item.ParentItemID != nil {
+			return errComboComponent
+		}
 
-	// Actualizar la cantidad según la acción
-	if action == "increase" {
-		item.Quantity++
-		db.Save(&item)
-	} else if action == "decrease" {
-		if item.Quantity > 1 {
-			item.Quantity--
-			db.Save(&item)
+		// Actualizar la cantidad según la acción
+		oldQuantity := item.Quantity
+		if action == "increase" {
+			item.Quantity++
 		} else {
-			// Si la cantidad llega a 0, eliminar el ítem
-			db.Delete(&item)
+			item.Quantity--
 		}
-	}
-
-	// Recalcular total
-	var order Order
-	db.First(&order, orderID)
 
-	var allItems []OrderItem
-	db.Where("order_id = ?", orderID).Preload("Product").Find(&allItems)
+		if item.Quantity < 1 {
+			// Si la cantidad llega a 0, eliminar el ítem
+			if err := tx.Delete(&item).Error; err != nil {
+				return err
+			}
+			if err := recordItemEvent(tx, EventItemRemoved, item, oldQuantity, 0, currentUserID(c), ""); err != nil {
+				return err
+			}
+		} else {
+			if err := tx.Save(&item).Error; err != nil {
+				return err
+			}
+			if err := syncComponents(tx, item); err != nil {
+				return err
+			}
+			if err := recordItemEvent(tx, EventItemQuantity, item, oldQuantity, item.Quantity, currentUserID(c), ""); err != nil {
+				return err
+			}
+		}
 
-	total := 0.0
-	for _, item := range allItems {
-		total += item.Product.Price * float64(item.Quantity)
+		if err := recalculateOrderTotals(tx, &order); err != nil {
+			return err
+		}
+		return deductSentOrderStock(tx, order, currentUserID(c))
+	})
+	if err != nil {
+		return orderErrorResponse(c, err)
 	}
-
-	order.Total = total
-	db.Save(&order)
+	broadcastOrderUpdate(order)
 
 	// Notificar éxito
 	c.Set("HX-Trigger", `{"showToast": "Cantidad actualizada"}`)
+	return renderOrderItems(c, order.ID)
+}
+
+// snapshotProduct copia en el ítem el precio, nombre y categoría actuales del producto
+func (item *OrderItem) snapshotProduct(product Product) {
+	item.UnitPrice = product.Price
+	item.ProductName = product.Name
+	item.Category = product.Category
+}
+
+// renderOrderItems devuelve la tabla de ítems de la orden, ordenados por ID
+func renderOrderItems(c *fiber.Ctx, orderID uint) error {
+	var order Order
+	db.Preload("Items", func(db *gorm.DB) *gorm.DB {
+		return orderLines(db).Order("id ASC")
+	}).Preload("Items.Product").
+		Preload("Items.AddedBy", unscopedUsers).
+		Preload("Items.PreparedBy", unscopedUsers).
+		Preload("Checks").
+		First(&order, orderID)
 
 	return c.Render("partials/order_items", fiber.Map{
-		"Order": order,
-		"Items": allItems,
+		"Order":   order,
+		"OrderID": order.ID,
 	}, "")
 }
 
-// PrintOrder genera un ticket de orden
+// PrintOrder imprime el ticket de cobro de la orden en la impresora predeterminada
 func PrintOrder(c *fiber.Ctx) error {
 	id, err := strconv.Atoi(c.Params("id"))
 	if err != nil {
@@ -601,45 +651,56 @@ func PrintOrder(c *fiber.Ctx) error {
 	}
 
 	var order Order
-	result := db.Preload("Items").Preload("Items.Product").First(&order, id)
-	if result.Error != nil {
+	if result := db.First(&order, id); result.Error != nil {
 		return c.Status(fiber.StatusNotFound).SendString("Orden no encontrada")
 	}
 
-	// Simulación de impresión - en producción conectarías con una API de impresora
-	c.Set("HX-Trigger", `{"showToast": "Imprimiendo ticket para orden #`+strconv.Itoa(id)+`"}`)
-
-	// Código para generar ticket en formato real...
-	// sendToDefaultPrinter("Orden #" + strconv.Itoa(id))
+	if err := printReceipt(order.ID); err != nil {
+		log.Printf("Error al imprimir el ticket de la orden #%d: %v", order.ID, err)
+		return Error(c, "No se pudo imprimir el ticket. Revisa la configuración de la impresora", fiber.StatusBadGateway)
+	}
 
-	return c.SendString("Imprimiendo ticket...")
+	setTrigger(c, fiber.Map{"showToast": "Ticket de la orden #" + strconv.Itoa(id) + " enviado a la impresora"})
+	return c.SendString("Ticket impreso")
 }
 
-// EmailOrder envía la orden por correo
+// EmailOrder pone en cola el recibo de una orden cobrada para enviarlo por correo
 func EmailOrder(c *fiber.Ctx) error {
 	id, err := strconv.Atoi(c.Params("id"))
 	if err != nil {
 		return c.Status(fiber.StatusBadRequest).SendString("ID inválido")
 	}
 
-	email := c.FormValue("email")
-	if email == "" {
-		return c.Status(fiber.StatusBadRequest).SendString("Email requerido")
+	address, err := mail.ParseAddress(strings.TrimSpace(c.FormValue("email")))
+	if err != nil {
+		return Error(c, "Correo electrónico inválido", fiber.StatusBadRequest)
 	}
 
 	var order Order
-	result := db.Preload("Items").Preload("Items.Product").First(&order, id)
-	if result.Error != nil {
+	if result := db.First(&order, id); result.Error != nil {
 		return c.Status(fiber.StatusNotFound).SendString("Orden no encontrada")
 	}
+	if order.Status != StatusCompleted {
+		return Error(c, "Solo se envían recibos de órdenes cobradas", fiber.StatusConflict)
+	}
+	if loadSettings(db).SMTPHost == "" {
+		return Error(c, "Configura el servidor de correo en Configuración", fiber.StatusConflict)
+	}
 
-	// Simulación de envío de correo - en producción conectarías con un servicio SMTP
-	c.Set("HX-Trigger", `{"showToast": "Recibo enviado a `+email+`"}`)
-
-	// Código para enviar email real...
-	// sendEmail(email, "Su recibo de Resto", generateReceipt(order))
+	delivery := EmailDelivery{
+		OrderID:       order.ID,
+		To:            address.Address,
+		Status:        EmailPending,
+		NextAttemptAt: time.Now(),
+		CreatedByID:   currentUserID(c),
+	}
+	if err := db.Create(&delivery).Error; err != nil {
+		return Error(c, "Error al preparar el envío", fiber.StatusInternalServerError)
+	}
+	queueEmailDelivery(delivery.ID)
 
-	return c.SendString("Email enviado")
+	setTrigger(c, fiber.Map{"showToast": "Enviando recibo a " + delivery.To, "toastType": "success"})
+	return c.Render("partials/email_deliveries", emailDeliveriesData(order.ID), "")
 }
 
 // DuplicateOrder crea una copia de una orden existente
@@ -651,35 +712,87 @@ func DuplicateOrder(c *fiber.Ctx) error {
 
 	// Obtener la orden original
 	var originalOrder Order
-	result := db.Preload("Items").Preload("Items.Product").First(&originalOrder, id)
+	result := db.Preload("Items").Preload("Items.Product").Preload("Items.Modifiers").First(&originalOrder, id)
 	if result.Error != nil {
 		return c.Status(fiber.StatusNotFound).SendString("Orden no encontrada")
 	}
 
 	// Crear nueva orden
 	newOrder := Order{
-		TableNum:  originalOrder.TableNum,
-		Status:    "pending",
-		Total:     0,
-		Notes:     originalOrder.Notes,
-		CreatedAt: time.Now(),
-	}
-	db.Create(&newOrder)
+		TableNum:    originalOrder.TableNum,
+		Status:      StatusPending,
+		Total:       0,
+		Notes:       originalOrder.Notes,
+		CreatedAt:   time.Now(),
+		CreatedByID: currentUserID(c),
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&newOrder).Error; err != nil {
+			return err
+		}
+		if err := recordStatusChange(tx, newOrder.ID, "", newOrder.Status, newOrder.CreatedByID, "Duplicada de la orden #"+strconv.Itoa(id)); err != nil {
+			return err
+		}
 
-	// Duplicar los items
-	for _, item := range originalOrder.Items {
-		newItem := OrderItem{
-			OrderID:   newOrder.ID,
-			ProductID: item.ProductID,
-			Quantity:  item.Quantity,
-			Notes:     item.Notes,
+		// Duplicar los items; los componentes de cada combo se copian junto con él
+		components := make(map[uint][]OrderItem)
+		for _, item := range originalOrder.Items {
+			if item.ParentItemID != nil {
+				components[*item.ParentItemID] = append(components[*item.ParentItemID], item)
+			}
+		}
+		for _, item := range originalOrder.Items {
+			if item.ParentItemID != nil {
+				continue
+			}
+			newItem := OrderItem{
+				OrderID:   newOrder.ID,
+				ProductID: item.ProductID,
+				Quantity:  item.Quantity,
+				Notes:     item.Notes,
+				AddedByID: currentUserID(c),
+				// Si el producto ya no existe se conserva la copia original
+				UnitPrice:   item.UnitPrice,
+				ProductName: item.ProductName,
+				Category:    item.Category,
+			}
+			if item.Product.ID != 0 {
+				newItem.snapshotProduct(item.Product)
+				newItem.applyModifiers(copyModifiers(item.Modifiers))
+			} else {
+				newItem.Modifiers = copyModifiers(item.Modifiers)
+				newItem.ModifierKey, newItem.ModifierNames = item.ModifierKey, item.ModifierNames
+			}
+			var newComponents []OrderItem
+			for _, component := range components[item.ID] {
+				copied := OrderItem{ProductID: component.ProductID, ProductName: component.ProductName, Category: component.Category}
+				if component.Product.ID != 0 {
+					copied.snapshotProduct(component.Product)
+					copied.UnitPrice = 0
+				}
+				newComponents = append(newComponents, copied)
+			}
+			if item.IsCombo && item.Product.ID != 0 {
+				newItem.applyCombo(newComponents)
+			} else {
+				newItem.IsCombo = item.IsCombo
+			}
+			if err := tx.Create(&newItem).Error; err != nil {
+				return err
+			}
+			if err := createComponents(tx, newItem, newComponents); err != nil {
+				return err
+			}
+			if err := recordItemEvent(tx, EventItemAdded, newItem, 0, newItem.Quantity, newItem.AddedByID, ""); err != nil {
+				return err
+			}
 		}
-		db.Create(&newItem)
 
-		// Actualizar total
-		newOrder.Total += item.Product.Price * float64(item.Quantity)
+		return recalculateOrderTotals(tx, &newOrder)
+	})
+	if err != nil {
+		return orderErrorResponse(c, err)
 	}
-	db.Save(&newOrder)
 
 	// Si es una solicitud HTMX, enviar header de redirección para HTMX
 	if c.Get("HX-Request") == "true" {
@@ -697,15 +810,17 @@ func UpdateOrderNotes(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusBadRequest).SendString("ID inválido")
 	}
 
-	var order Order
-	result := db.First(&order, id)
-	if result.Error != nil {
-		return c.Status(fiber.StatusNotFound).SendString("Orden no encontrada")
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var order Order
+		if err := lockOrder(tx, uint(id), &order); err != nil {
+			return err
+		}
+		return tx.Model(&order).Update("notes", c.FormValue("notes")).Error
+	})
+	if err != nil {
+		return orderErrorResponse(c, err)
 	}
 
-	order.Notes = c.FormValue("notes")
-	db.Save(&order)
-
 	c.Set("HX-Trigger", `{"showToast": "Notas actualizadas"}`)
 	return c.SendString("Notas actualizadas")
 }
@@ -722,68 +837,35 @@ func ProcessOrder(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusNotFound).SendString("Orden no encontrada")
 	}
 
-	// Verificar que la orden esté en estado pendiente
-	if order.Status != "pending" {
-		return c.Status(fiber.StatusBadRequest).SendString("Solo órdenes pendientes pueden ser procesadas")
+	// Solo las órdenes pendientes se envían a cocina desde aquí
+	if order.Status != StatusPending {
+		return Error(c, "Solo órdenes pendientes pueden ser procesadas", fiber.StatusBadRequest)
 	}
 
-	// Obtener el timestamp actual
-	now := time.Now()
-
-	// Cambiar el estado a "in_progress"
-	order.Status = "in_progress"
-	order.UpdatedAt = now
-	if order.SentToKitchenAt == nil {
-		order.SentToKitchenAt = &now
+	if err := TransitionOrder(&order, StatusInProgress, currentUserID(c), ""); err != nil {
+		return orderErrorResponse(c, err)
 	}
-	db.Save(&order)
 
-	// Registrar tiempo de inicio para todos los items de la orden
+	// Comandas para cocina y barra con todos los productos de la orden; los combos van por componente
 	var items []OrderItem
-	db.Where("order_id = ?", id).Find(&items)
-
-	for _, item := range items {
-		if item.CookingStarted == nil {
-			item.CookingStarted = &now
-			db.Save(&item)
-		}
-	}
-
-	// --- Notificación WebSocket ---
-	wsBroadcast <- WSMessage{
-		Type:    "order_update",
-		Payload: order,
-	}
-	wsBroadcast <- WSMessage{
-		Type:    "kitchen_update",
-		Payload: order,
-	}
-	// -----------------------------
+	kitchenLines(db).Where("order_id = ?", order.ID).Order("id ASC").Find(&items)
+	printKitchenTickets(order, items, false)
 
 	c.Set("HX-Trigger", `{"showToast": "Orden enviada a cocina correctamente"}`)
 	c.Set("HX-Redirect", "/orders")
 	return c.SendString("Orden enviada a cocina")
 }
 
-// Cambia el estado de la orden a 'ready' si todos los ítems están listos
-func SetOrderReadyIfAllItemsReady(order *Order) bool {
-	var items []OrderItem
-	db.Where("order_id = ?", order.ID).Find(&items)
-	if len(items) == 0 {
-		return false
+// SetOrderReadyIfAllItemsReady pasa la orden a 'ready' dentro de tx si está en
+// preparación y todos sus ítems están listos. Devuelve true si cambió de estado.
+func SetOrderReadyIfAllItemsReady(tx *gorm.DB, order *Order, actorID *uint) (bool, error) {
+	if order.Status != StatusInProgress || !allItemsReady(tx, order.ID) {
+		return false, nil
 	}
-	for _, item := range items {
-		if !item.IsReady {
-			return false
-		}
-	}
-	if order.Status == "in_progress" {
-		order.Status = "ready"
-		order.CookingCompletedAt = ptrTime(time.Now())
-		db.Save(order)
-		return true
+	if err := applyTransition(tx, order, StatusReady, actorID, ""); err != nil {
+		return false, err
 	}
-	return false
+	return true, nil
 }
 
 // Endpoint para marcar una orden como 'ready' (listo para entregar)
@@ -796,15 +878,12 @@ func SetOrderReady(c *fiber.Ctx) error {
 	if result := db.First(&order, id); result.Error != nil {
 		return c.Status(fiber.StatusNotFound).SendString("Orden no encontrada")
 	}
-	if order.Status != "in_progress" {
-		return c.Status(fiber.StatusBadRequest).SendString("Solo órdenes en preparación pueden marcarse como listas")
-	}
-	if SetOrderReadyIfAllItemsReady(&order) {
-		wsBroadcast <- WSMessage{Type: "order_update", Payload: order}
-		c.Set("HX-Trigger", `{"showToast": "Orden lista para entregar"}`)
-		return c.SendString("Orden lista para entregar")
+	// La transición comprueba que todos los ítems estén listos con la orden bloqueada
+	if err := TransitionOrder(&order, StatusReady, currentUserID(c), ""); err != nil {
+		return orderErrorResponse(c, err)
 	}
-	return c.Status(fiber.StatusBadRequest).SendString("No todos los productos están listos")
+	c.Set("HX-Trigger", `{"showToast": "Orden lista para entregar"}`)
+	return c.SendString("Orden lista para entregar")
 }
 
 // Endpoint para marcar una orden como 'to_pay' (por cobrar)
@@ -817,13 +896,9 @@ func SetOrderToPay(c *fiber.Ctx) error {
 	if result := db.First(&order, id); result.Error != nil {
 		return c.Status(fiber.StatusNotFound).SendString("Orden no encontrada")
 	}
-	if order.Status != "ready" {
-		return c.Status(fiber.StatusBadRequest).SendString("Solo órdenes listas pueden pasar a por cobrar")
+	if err := TransitionOrder(&order, StatusToPay, currentUserID(c), ""); err != nil {
+		return orderErrorResponse(c, err)
 	}
-	order.Status = "to_pay"
-	order.DeliveredAt = ptrTime(time.Now())
-	db.Save(&order)
-	wsBroadcast <- WSMessage{Type: "order_update", Payload: order}
 	c.Set("HX-Trigger", `{"showToast": "Orden entregada, por cobrar"}`)
 	return c.SendString("Orden entregada, por cobrar")
 }
@@ -838,15 +913,10 @@ func SetOrderCompletedFromToPay(c *fiber.Ctx) error {
 	if result := db.First(&order, id); result.Error != nil {
 		return c.Status(fiber.StatusNotFound).SendString("Orden no encontrada")
 	}
-	if order.Status != "to_pay" {
-		return c.Status(fiber.StatusBadRequest).SendString("Solo órdenes por cobrar pueden ser completadas")
+	if err := TransitionOrder(&order, StatusCompleted, currentUserID(c), ""); err != nil {
+		return orderErrorResponse(c, err)
 	}
-	order.Status = "completed"
-	order.CompletedAt = ptrTime(time.Now())
-	db.Save(&order)
-	// Liberar la mesa
-	db.Model(&Table{}).Where("number = ?", order.TableNum).Updates(map[string]interface{}{"occupied": false, "order_id": nil})
-	wsBroadcast <- WSMessage{Type: "order_update", Payload: order}
+	autoPrintReceipt(order.ID)
 	c.Set("HX-Trigger", `{"showToast": "Orden pagada y cerrada"}`)
 	c.Set("HX-Redirect", "/orders")
 	return c.SendString("Orden pagada y cerrada")
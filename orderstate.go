@@ -0,0 +1,255 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Estados de una orden (ver la documentación de Order en models.go)
+const (
+	StatusPending    = "pending"
+	StatusInProgress = "in_progress"
+	StatusReady      = "ready"
+	StatusToPay      = "to_pay"
+	StatusCompleted  = "completed"
+	StatusCancelled  = "cancelled"
+)
+
+// orderTransitions define las transiciones de estado permitidas.
+// El flujo normal es pending -> in_progress -> ready -> to_pay -> completed.
+// Una orden lista o por cobrar vuelve a in_progress cuando se agregan productos
+// o la cocina desmarca un ítem; cualquier orden abierta puede cancelarse.
+var orderTransitions = map[string][]string{
+	StatusPending:    {StatusInProgress, StatusCancelled},
+	StatusInProgress: {StatusReady, StatusCancelled},
+	StatusReady:      {StatusToPay, StatusInProgress, StatusCancelled},
+	StatusToPay:      {StatusCompleted, StatusInProgress, StatusCancelled},
+}
+
+// activeOrderStatuses son los estados de una orden abierta
+var activeOrderStatuses = []string{StatusPending, StatusInProgress, StatusReady, StatusToPay}
+
+// TransitionError indica que la transición de estado solicitada no está permitida
+type TransitionError struct {
+	From string
+	To   string
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("No se puede pasar una orden de %s a %s", statusLabel(e.From), statusLabel(e.To))
+}
+
+// statusLabel devuelve la etiqueta en español de un estado de orden
+func statusLabel(status string) string {
+	switch status {
+	case StatusPending:
+		return "Pendiente"
+	case StatusInProgress:
+		return "En preparación"
+	case StatusReady:
+		return "Listo para entregar"
+	case StatusToPay:
+		return "Por cobrar"
+	case StatusCompleted:
+		return "Completada"
+	case StatusCancelled:
+		return "Cancelada"
+	}
+	return status
+}
+
+// canTransition indica si una orden puede pasar del estado from al estado to
+func canTransition(from, to string) bool {
+	for _, allowed := range orderTransitions[from] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+// isOrderEditable indica si se pueden agregar o modificar productos en la orden
+func isOrderEditable(status string) bool {
+	return status != StatusCompleted && status != StatusCancelled
+}
+
+// allItemsReady indica si la orden tiene ítems y cocina terminó todos; de los combos cuentan sus componentes
+func allItemsReady(tx *gorm.DB, orderID uint) bool {
+	var total, pending int64
+	kitchenLines(tx.Model(&OrderItem{})).Where("order_id = ?", orderID).Count(&total)
+	kitchenLines(tx.Model(&OrderItem{})).Where("order_id = ? AND is_ready = ?", orderID, false).Count(&pending)
+	return total > 0 && pending == 0
+}
+
+// applyTransition valida y aplica una transición dentro de la transacción tx:
+// actualiza el estado y sus marcas de tiempo, registra el evento y libera la mesa
+// si la orden se cerró. No notifica por WebSocket; ver TransitionOrder.
+func applyTransition(tx *gorm.DB, order *Order, to string, actorID *uint, reason string) error {
+	from := order.Status
+	if !canTransition(from, to) {
+		return &TransitionError{From: from, To: to}
+	}
+
+	// Una orden solo se cierra pagada por completo, y no se cancela con pagos sin reembolsar
+	if to == StatusCompleted || to == StatusCancelled {
+		paid, err := orderPaidAmount(tx, order.ID)
+		if err != nil {
+			return err
+		}
+		if to == StatusCompleted && paid < order.Total {
+			return fiber.NewError(fiber.StatusBadRequest, "La orden tiene un saldo pendiente de "+loadSettings(tx).FormatMoney(order.Total-paid))
+		}
+		if to == StatusCancelled && paid > 0 {
+			return fiber.NewError(fiber.StatusBadRequest, "La orden tiene pagos registrados: reembólsalos antes de cancelar")
+		}
+	}
+
+	// A cocina solo llegan órdenes con productos, y solo se da por lista cuando cocina terminó todos.
+	// Se revisa sobre tx para que lo vea con la orden ya bloqueada.
+	switch to {
+	case StatusInProgress:
+		var count int64
+		if err := kitchenLines(tx.Model(&OrderItem{})).Where("order_id = ?", order.ID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count == 0 {
+			return fiber.NewError(fiber.StatusBadRequest, "La orden no tiene productos para enviar a cocina")
+		}
+	case StatusReady:
+		if !allItemsReady(tx, order.ID) {
+			return fiber.NewError(fiber.StatusBadRequest, "No todos los productos están listos")
+		}
+	}
+
+	now := time.Now()
+	order.Status = to
+	order.UpdatedAt = now
+
+	switch to {
+	case StatusInProgress:
+		if order.SentToKitchenAt == nil {
+			order.SentToKitchenAt = &now
+			order.SentToKitchenByID = actorID
+		}
+		// La orden vuelve a cocina: ya no está terminada
+		order.CookingCompletedAt = nil
+		if err := tx.Model(&OrderItem{}).
+			Where("order_id = ? AND cooking_started IS NULL", order.ID).
+			Update("cooking_started", now).Error; err != nil {
+			return err
+		}
+	case StatusReady:
+		order.CookingCompletedAt = &now
+	case StatusToPay:
+		order.DeliveredAt = &now
+		order.DeliveredByID = actorID
+	case StatusCompleted:
+		order.CompletedAt = &now
+		order.CompletedByID = actorID
+	case StatusCancelled:
+		order.CancelledByID = actorID
+	}
+
+	if err := tx.Omit(clause.Associations).Save(order).Error; err != nil {
+		return err
+	}
+
+	if err := recordStatusChange(tx, order.ID, from, to, actorID, reason); err != nil {
+		return err
+	}
+
+	// Liberar la mesa cuando la orden se cierra
+	if to == StatusCompleted || to == StatusCancelled {
+		if err := tx.Model(&Table{}).Where("number = ? AND (order_id = ? OR order_id IS NULL)", order.TableNum, order.ID).Updates(map[string]interface{}{
+			"occupied": false,
+			"order_id": nil,
+		}).Error; err != nil {
+			return err
+		}
+		log.Printf("Mesa %d liberada", order.TableNum)
+	}
+
+	// Inventario: lo que llega a cocina descuenta sus ingredientes y una cancelación los devuelve
+	switch to {
+	case StatusInProgress:
+		if err := deductOrderStock(tx, order.ID, actorID); err != nil {
+			return err
+		}
+	case StatusCancelled:
+		if err := restoreOrderStock(tx, order.ID, actorID); err != nil {
+			return err
+		}
+	}
+
+	log.Printf("Orden #%d: %s -> %s", order.ID, from, to)
+	return nil
+}
+
+// lockOrder carga la orden dentro de tx con SELECT ... FOR UPDATE; la fila queda
+// bloqueada hasta que la transacción termine, serializando los cambios concurrentes
+func lockOrder(tx *gorm.DB, orderID uint, order *Order) error {
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(order, orderID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return fiber.NewError(fiber.StatusNotFound, "Orden no encontrada")
+		}
+		return err
+	}
+	return nil
+}
+
+// TransitionOrder aplica una transición en su propia transacción y notifica a los clientes WebSocket.
+// La orden se vuelve a leer bloqueada para validar la transición contra su estado actual.
+func TransitionOrder(order *Order, to string, actorID *uint, reason string) error {
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := lockOrder(tx, order.ID, order); err != nil {
+			return err
+		}
+		return applyTransition(tx, order, to, actorID, reason)
+	})
+	if err != nil {
+		return err
+	}
+
+	broadcastOrderUpdate(*order)
+	return nil
+}
+
+// broadcastOrderUpdate notifica un cambio de la orden a meseros y cocina
+func broadcastOrderUpdate(order Order) {
+	wsBroadcast <- WSMessage{
+		Type:    "order_update",
+		Payload: order,
+	}
+	wsBroadcast <- WSMessage{
+		Type:    "kitchen_update",
+		Payload: order,
+	}
+}
+
+// errOrderClosed rechaza cambios sobre una orden completada o cancelada
+func errOrderClosed(order Order) error {
+	return fiber.NewError(fiber.StatusBadRequest, "La orden #"+fmt.Sprint(order.ID)+" está "+strings.ToLower(statusLabel(order.Status))+" y no se puede modificar")
+}
+
+// orderErrorResponse responde de forma uniforme a un error al modificar una orden:
+// transiciones inválidas y errores de validación (fiber.Error) se informan al usuario,
+// el resto se registra en el log y se responde con un error genérico
+func orderErrorResponse(c *fiber.Ctx, err error) error {
+	var transitionErr *TransitionError
+	if errors.As(err, &transitionErr) {
+		return Error(c, transitionErr.Error(), fiber.StatusBadRequest)
+	}
+	var fiberErr *fiber.Error
+	if errors.As(err, &fiberErr) {
+		return Error(c, fiberErr.Message, fiberErr.Code)
+	}
+	log.Printf("Error al actualizar la orden: %v", err)
+	return Error(c, "Error al actualizar la orden", fiber.StatusInternalServerError)
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// lockTables bloquea en tx las mesas indicadas, siempre en orden de número para evitar
+// interbloqueos entre dos cambios de mesa cruzados
+func lockTables(tx *gorm.DB, numbers ...int) (map[int]*Table, error) {
+	var tables []Table
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("number IN ?", numbers).
+		Order("number").
+		Find(&tables).Error; err != nil {
+		return nil, err
+	}
+	byNumber := make(map[int]*Table, len(tables))
+	for i := range tables {
+		byNumber[tables[i].Number] = &tables[i]
+	}
+	return byNumber, nil
+}
+
+// TransferOrder mueve una orden abierta a otra mesa libre
+func TransferOrder(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).SendString("ID inválido")
+	}
+	tableNum, err := strconv.Atoi(c.FormValue("table_num"))
+	if err != nil {
+		return Error(c, "Número de mesa inválido", fiber.StatusBadRequest)
+	}
+
+	actorID := currentUserID(c)
+	var order Order
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := lockOrder(tx, uint(id), &order); err != nil {
+			return err
+		}
+		if !isOrderEditable(order.Status) {
+			return errOrderClosed(order)
+		}
+		fromTable := order.TableNum
+		if fromTable == tableNum {
+			return fiber.NewError(fiber.StatusBadRequest, "La orden ya está en esa mesa")
+		}
+
+		tables, err := lockTables(tx, fromTable, tableNum)
+		if err != nil {
+			return err
+		}
+		target := tables[tableNum]
+		if target == nil {
+			return fiber.NewError(fiber.StatusNotFound, "Mesa no encontrada")
+		}
+		if target.Occupied {
+			return fiber.NewError(fiber.StatusBadRequest, "La mesa "+strconv.Itoa(tableNum)+" está ocupada")
+		}
+
+		// Liberar la mesa de origen solo si estaba vinculada a esta orden
+		if source := tables[fromTable]; source != nil && (source.OrderID == nil || *source.OrderID == order.ID) {
+			source.Occupied = false
+			source.OrderID = nil
+			if err := tx.Save(source).Error; err != nil {
+				return err
+			}
+		}
+		target.Occupied = true
+		target.OrderID = &order.ID
+		if err := tx.Save(target).Error; err != nil {
+			return err
+		}
+
+		order.TableNum = tableNum
+		if err := tx.Model(&order).Update("table_num", tableNum).Error; err != nil {
+			return err
+		}
+		return recordTableTransfer(tx, order.ID, fromTable, tableNum, actorID)
+	})
+	if err != nil {
+		return orderErrorResponse(c, err)
+	}
+	broadcastOrderUpdate(order)
+
+	setTrigger(c, fiber.Map{"showToast": "Orden movida a la mesa " + strconv.Itoa(tableNum)})
+	c.Set("HX-Redirect", fmt.Sprintf("/order/%d", order.ID))
+	return c.SendString("Orden movida")
+}
+
+// MergeOrders une otra orden abierta (source_order_id) en esta orden. Los ítems se mueven
+// tal cual, conservando su avance en cocina; la orden absorbida se cancela y su mesa se libera.
+func MergeOrders(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).SendString("ID inválido")
+	}
+	sourceID, err := strconv.Atoi(c.FormValue("source_order_id"))
+	if err != nil {
+		return Error(c, "Orden a unir inválida", fiber.StatusBadRequest)
+	}
+	if sourceID == id {
+		return Error(c, "No se puede unir una orden consigo misma", fiber.StatusBadRequest)
+	}
+
+	actorID := currentUserID(c)
+	var target, source Order
+	var unsent []OrderItem // Lo que cocina todavía no recibió, para imprimirlo al unir
+	targetSent := false
+	err = db.Transaction(func(tx *gorm.DB) error {
+		// Bloquear ambas órdenes por ID ascendente para evitar interbloqueos
+		first, second := &target, &source
+		firstID, secondID := id, sourceID
+		if sourceID < id {
+			first, second = &source, &target
+			firstID, secondID = sourceID, id
+		}
+		if err := lockOrder(tx, uint(firstID), first); err != nil {
+			return err
+		}
+		if err := lockOrder(tx, uint(secondID), second); err != nil {
+			return err
+		}
+
+		for _, order := range []*Order{&target, &source} {
+			if !isOrderEditable(order.Status) {
+				return errOrderClosed(*order)
+			}
+			if err := loadOrderForBilling(tx, order); err != nil {
+				return err
+			}
+			if len(order.Payments) > 0 {
+				return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("La orden #%d tiene pagos registrados", order.ID))
+			}
+			if len(order.Checks) > 0 {
+				return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("La orden #%d tiene la cuenta dividida: une las cuentas primero", order.ID))
+			}
+		}
+
+		// Los ítems de una orden que no se había enviado a cocina no tienen comanda
+		targetSent = target.SentToKitchenAt != nil
+		for _, order := range []*Order{&target, &source} {
+			if order.SentToKitchenAt != nil {
+				continue
+			}
+			var items []OrderItem
+			if err := kitchenLines(tx).Where("order_id = ? AND is_ready = ?", order.ID, false).Order("id ASC").Find(&items).Error; err != nil {
+				return err
+			}
+			unsent = append(unsent, items...)
+		}
+
+		// Mover los ítems sin tocar IsReady, CookingStarted ni quién los preparó
+		if err := tx.Model(&OrderItem{}).Where("order_id = ?", source.ID).Update("order_id", target.ID).Error; err != nil {
+			return err
+		}
+
+		if source.Notes != "" {
+			target.Notes = strings.TrimSpace(target.Notes + "\n" + source.Notes)
+			if err := tx.Model(&target).Update("notes", target.Notes).Error; err != nil {
+				return err
+			}
+		}
+		target.Tip += source.Tip
+		if err := recalculateOrderTotals(tx, &target); err != nil {
+			return err
+		}
+		source.Tip = 0
+		if err := recalculateOrderTotals(tx, &source); err != nil {
+			return err
+		}
+
+		if err := recordOrderMerge(tx, target, source, actorID); err != nil {
+			return err
+		}
+		if err := applyTransition(tx, &source, StatusCancelled, actorID, fmt.Sprintf("Unida a la orden #%d", target.ID)); err != nil {
+			return err
+		}
+
+		// Lo que llegó sin terminar tiene que pasar por cocina, igual que al agregar productos
+		var pending int64
+		if err := tx.Model(&OrderItem{}).Where("order_id = ? AND is_ready = ?", target.ID, false).Count(&pending).Error; err != nil {
+			return err
+		}
+		if pending == 0 {
+			return nil
+		}
+		switch {
+		case target.Status == StatusReady || target.Status == StatusToPay,
+			target.Status == StatusPending && source.SentToKitchenAt != nil:
+			return applyTransition(tx, &target, StatusInProgress, actorID, "Orden unida con productos pendientes")
+		case target.Status == StatusInProgress:
+			if err := tx.Model(&OrderItem{}).
+				Where("order_id = ? AND cooking_started IS NULL", target.ID).
+				Update("cooking_started", time.Now()).Error; err != nil {
+				return err
+			}
+			return deductOrderStock(tx, target.ID, actorID)
+		}
+		return nil
+	})
+	if err != nil {
+		return orderErrorResponse(c, err)
+	}
+	broadcastOrderUpdate(source)
+	broadcastOrderUpdate(target)
+
+	// Si la orden unida quedó en cocina, se imprime lo que cocina no había recibido; si ya
+	// estaba enviada, como adición
+	if target.Status == StatusInProgress {
+		printKitchenTickets(target, unsent, targetSent)
+	}
+
+	setTrigger(c, fiber.Map{"showToast": fmt.Sprintf("Orden #%d unida a esta orden", source.ID)})
+	c.Set("HX-Redirect", fmt.Sprintf("/order/%d", target.ID))
+	return c.SendString("Órdenes unidas")
+}
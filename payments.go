@@ -0,0 +1,300 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// Medios de pago aceptados
+const (
+	PaymentCash  = "cash"
+	PaymentCard  = "card"
+	PaymentOther = "other"
+)
+
+// paymentMethodLabel devuelve el nombre en español de un medio de pago
+func paymentMethodLabel(method string) string {
+	switch method {
+	case PaymentCash:
+		return "Efectivo"
+	case PaymentCard:
+		return "Tarjeta"
+	case PaymentOther:
+		return "Otro"
+	}
+	return method
+}
+
+// isValidPaymentMethod verifica que el medio de pago sea uno de los aceptados
+func isValidPaymentMethod(method string) bool {
+	return method == PaymentCash || method == PaymentCard || method == PaymentOther
+}
+
+// orderPaidAmount devuelve lo pagado de una orden, descontando los reembolsos
+func orderPaidAmount(tx *gorm.DB, orderID uint) (Money, error) {
+	var paid Money
+	err := tx.Model(&Payment{}).
+		Where("order_id = ?", orderID).
+		Select("COALESCE(SUM(amount), 0)").
+		Scan(&paid).Error
+	return paid, err
+}
+
+// NetTotal es lo que la orden retiene una vez descontados los reembolsos hechos tras cerrarla
+func (o Order) NetTotal() Money {
+	return o.Total - o.Refunded
+}
+
+// parseOptionalMoney lee un importe de un campo de formulario; vacío equivale a no indicado
+func parseOptionalMoney(value string) (amount Money, ok bool, err error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false, nil
+	}
+	amount, err = ParseMoney(value)
+	if err == nil && amount <= 0 {
+		err = errors.New("el importe debe ser mayor que cero")
+	}
+	return amount, true, err
+}
+
+// AddOrderPayment registra un pago (total o parcial) de una orden por cobrar.
+// Sin monto se cobra el saldo pendiente; en efectivo se calcula el cambio a partir
+// del importe recibido. Cuando el saldo llega a cero la orden se completa.
+func AddOrderPayment(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).SendString("ID inválido")
+	}
+
+	method := c.FormValue("method")
+	if !isValidPaymentMethod(method) {
+		return Error(c, "Medio de pago inválido", fiber.StatusBadRequest)
+	}
+	amount, hasAmount, err := parseOptionalMoney(c.FormValue("amount"))
+	if err != nil {
+		return Error(c, "Monto inválido", fiber.StatusBadRequest)
+	}
+	tendered, hasTendered, err := parseOptionalMoney(c.FormValue("tendered"))
+	if err != nil {
+		return Error(c, "Efectivo recibido inválido", fiber.StatusBadRequest)
+	}
+
+	actorID := currentUserID(c)
+	var order Order
+	var payment Payment
+	completed := false
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := lockOrder(tx, uint(id), &order); err != nil {
+			return err
+		}
+		if order.Status != StatusToPay {
+			return fiber.NewError(fiber.StatusBadRequest, "Solo se cobran órdenes entregadas (por cobrar)")
+		}
+
+		if err := loadOrderForBilling(tx, &order); err != nil {
+			return err
+		}
+		var paid Money
+		for _, existing := range order.Payments {
+			paid += existing.Amount
+		}
+		balance := order.Total - paid
+		if balance <= 0 {
+			return fiber.NewError(fiber.StatusBadRequest, "La orden ya está pagada")
+		}
+
+		payment = Payment{
+			OrderID:     order.ID,
+			Method:      method,
+			Reference:   strings.TrimSpace(c.FormValue("reference")),
+			CreatedByID: actorID,
+		}
+
+		// En una orden dividida se cobra una cuenta a la vez, hasta su saldo
+		if checks := splitChecks(order); checks != nil {
+			checkID, _ := strconv.Atoi(c.FormValue("check_id"))
+			var check *CheckSummary
+			for i := range checks {
+				if checks[i].Check.ID == uint(checkID) {
+					check = &checks[i]
+				}
+			}
+			if check == nil {
+				return fiber.NewError(fiber.StatusBadRequest, "Selecciona la cuenta que se cobra")
+			}
+			if check.Balance <= 0 {
+				return fiber.NewError(fiber.StatusBadRequest, "La cuenta "+strconv.Itoa(check.Check.Number)+" ya está pagada")
+			}
+			payment.CheckID = &check.Check.ID
+			balance = check.Balance
+		}
+		payment.Amount = balance
+		if hasAmount {
+			payment.Amount = amount
+		}
+		if payment.Amount > balance {
+			return fiber.NewError(fiber.StatusBadRequest, "El monto excede el saldo pendiente de "+loadSettings(tx).FormatMoney(balance))
+		}
+
+		if method == PaymentCash {
+			payment.Tendered = payment.Amount
+			if hasTendered {
+				payment.Tendered = tendered
+			}
+			if payment.Tendered < payment.Amount {
+				return fiber.NewError(fiber.StatusBadRequest, "El efectivo recibido no cubre el monto de "+loadSettings(tx).FormatMoney(payment.Amount))
+			}
+			payment.Change = payment.Tendered - payment.Amount
+		}
+
+		if err := tx.Create(&payment).Error; err != nil {
+			return err
+		}
+
+		if paid+payment.Amount >= order.Total {
+			completed = true
+			return applyTransition(tx, &order, StatusCompleted, actorID, "")
+		}
+		return nil
+	})
+	if err != nil {
+		return orderErrorResponse(c, err)
+	}
+	broadcastOrderUpdate(order)
+
+	message := "Pago registrado"
+	if payment.Change > 0 {
+		message += ". Cambio: " + loadSettings(db).FormatMoney(payment.Change)
+	}
+	if completed {
+		autoPrintReceipt(order.ID)
+		// Recargar la orden para mostrarla cerrada con sus pagos
+		setTrigger(c, fiber.Map{"showToast": message + ". Orden pagada y cerrada"})
+		c.Set("HX-Redirect", "/order/"+strconv.Itoa(id))
+		return c.SendString("Orden pagada y cerrada")
+	}
+
+	setTrigger(c, fiber.Map{"showToast": message})
+	return renderOrderPayments(c, order.ID)
+}
+
+// RefundPayment registra el reembolso total o parcial de un pago como un pago negativo
+func RefundPayment(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).SendString("ID inválido")
+	}
+
+	amount, hasAmount, err := parseOptionalMoney(c.FormValue("amount"))
+	if err != nil {
+		return Error(c, "Monto inválido", fiber.StatusBadRequest)
+	}
+
+	var original Payment
+	if err := db.First(&original, id).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).SendString("Pago no encontrado")
+	}
+	if original.RefundOfID != nil || original.Amount <= 0 {
+		return Error(c, "Solo se pueden reembolsar pagos", fiber.StatusBadRequest)
+	}
+
+	var order Order
+	err = db.Transaction(func(tx *gorm.DB) error {
+		// El bloqueo de la orden serializa los reembolsos concurrentes del mismo pago
+		if err := lockOrder(tx, original.OrderID, &order); err != nil {
+			return err
+		}
+
+		var refunded Money
+		if err := tx.Model(&Payment{}).
+			Where("refund_of_id = ?", original.ID).
+			Select("COALESCE(SUM(amount), 0)").
+			Scan(&refunded).Error; err != nil {
+			return err
+		}
+		refundable := original.Amount + refunded // refunded es negativo
+		if refundable <= 0 {
+			return fiber.NewError(fiber.StatusBadRequest, "El pago ya fue reembolsado por completo")
+		}
+
+		refund := refundable
+		if hasAmount {
+			refund = amount
+		}
+		if refund > refundable {
+			return fiber.NewError(fiber.StatusBadRequest, "El reembolso excede lo reembolsable de "+loadSettings(tx).FormatMoney(refundable))
+		}
+
+		refundOfID := original.ID
+		if err := tx.Create(&Payment{
+			OrderID:     original.OrderID,
+			Method:      original.Method,
+			Amount:      -refund,
+			RefundOfID:  &refundOfID,
+			CheckID:     original.CheckID,
+			Reason:      actionReason(c),
+			CreatedByID: currentUserID(c),
+		}).Error; err != nil {
+			return err
+		}
+
+		// Una orden cerrada sigue cerrada: el reembolso se descuenta de lo vendido
+		// en lugar de dejarla con saldo pendiente
+		if order.Status == StatusCompleted {
+			order.Refunded += refund
+			return tx.Model(&order).UpdateColumn("refunded", order.Refunded).Error
+		}
+		return nil
+	})
+	if err != nil {
+		return orderErrorResponse(c, err)
+	}
+	broadcastOrderUpdate(order)
+
+	c.Set("HX-Trigger", `{"showToast": "Reembolso registrado"}`)
+	return renderOrderPayments(c, order.ID)
+}
+
+// orderPaymentsData arma los datos del partial de pagos a partir de una orden con Items, Payments y Checks cargados
+func orderPaymentsData(c *fiber.Ctx, order Order) fiber.Map {
+	var paid Money
+	for _, payment := range order.Payments {
+		paid += payment.Amount
+	}
+	user := currentUser(c)
+
+	return fiber.Map{
+		"Order":     order,
+		"OrderID":   order.ID,
+		"Payments":  order.Payments,
+		"Paid":      paid,
+		"Balance":   order.NetTotal() - paid,
+		"Refunded":  order.Refunded,
+		"Checks":    splitChecks(order),
+		"CanPay":    order.Status == StatusToPay,
+		"CanRefund": user != nil && user.Role == RoleAdmin,
+	}
+}
+
+// preloadPayments carga los pagos de la orden en orden cronológico y sus cuentas separadas
+func preloadPayments(tx *gorm.DB) *gorm.DB {
+	return tx.Preload("Payments", func(db *gorm.DB) *gorm.DB {
+		return db.Order("id ASC")
+	}).Preload("Payments.CreatedBy", unscopedUsers).
+		Preload("Payments.Check").
+		Preload("Checks", func(db *gorm.DB) *gorm.DB {
+			return db.Order("number ASC")
+		})
+}
+
+// renderOrderPayments devuelve el partial de pagos de la orden
+func renderOrderPayments(c *fiber.Ctx, orderID uint) error {
+	var order Order
+	preloadPayments(db).Preload("Items", orderLines).First(&order, orderID)
+	return c.Render("partials/order_payments", orderPaymentsData(c, order), "")
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image/png"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/go-pdf/fpdf"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// Ancho útil de una página A4 con márgenes de 15 mm
+const pdfContentWidth = 180.0
+
+// pdfReport arma los informes PDF del historial con la marca del restaurante en cada página
+type pdfReport struct {
+	pdf      *fpdf.Fpdf
+	tr       func(string) string // UTF-8 a la codificación de las fuentes estándar (cp1252)
+	settings Settings
+}
+
+// newPDFReport crea un informe A4 con el encabezado (logo, nombre y contacto) y el pie de página
+func newPDFReport(settings Settings) *pdfReport {
+	pdf := fpdf.New("P", "mm", "A4", "")
+	pdf.SetMargins(15, 15, 15)
+	pdf.SetAutoPageBreak(true, 18)
+	pdf.AliasNbPages("")
+	r := &pdfReport{pdf: pdf, tr: pdf.UnicodeTranslatorFromDescriptor(""), settings: settings}
+
+	logoWidth := 0.0
+	if settings.LogoPath != "" {
+		logoWidth = r.registerLogo(settings.LogoPath)
+	}
+	generatedAt := time.Now()
+
+	pdf.SetHeaderFunc(func() {
+		textX := 15.0
+		if logoWidth > 0 {
+			pdf.ImageOptions("logo", 15, 10, logoWidth, 14, false, fpdf.ImageOptions{ImageType: "PNG"}, 0, "")
+			textX += logoWidth + 4
+		}
+		pdf.SetXY(textX, 11)
+		pdf.SetFont("Helvetica", "B", 14)
+		pdf.SetTextColor(0, 0, 0)
+		pdf.CellFormat(0, 7, r.tr(settings.RestaurantName), "", 2, "L", false, 0, "")
+		pdf.SetFont("Helvetica", "", 9)
+		pdf.SetTextColor(100, 100, 100)
+		pdf.CellFormat(0, 5, r.tr(joinNonEmpty(" · ", settings.Address, settings.Phone, settings.Email)), "", 1, "L", false, 0, "")
+		pdf.SetDrawColor(180, 180, 180)
+		pdf.Line(15, 27, 195, 27)
+		pdf.SetTextColor(0, 0, 0)
+		pdf.SetY(32)
+	})
+	pdf.SetFooterFunc(func() {
+		pdf.SetY(-12)
+		pdf.SetFont("Helvetica", "", 8)
+		pdf.SetTextColor(120, 120, 120)
+		pdf.CellFormat(pdfContentWidth/2, 5, r.tr("Generado el "+generatedAt.Format("02/01/2006 15:04")), "", 0, "L", false, 0, "")
+		pdf.CellFormat(pdfContentWidth/2, 5, r.tr(fmt.Sprintf("Página %d de {nb}", pdf.PageNo())), "", 0, "R", false, 0, "")
+		pdf.SetTextColor(0, 0, 0)
+	})
+	return r
+}
+
+// registerLogo registra el logo como PNG y devuelve su ancho para 14 mm de alto; 0 si no se puede leer.
+// Se decodifica con Go y se vuelve a codificar para no dejar el PDF en error con formatos que fpdf no admite.
+func (r *pdfReport) registerLogo(publicPath string) float64 {
+	img, err := loadPrinterImage(publicPath)
+	if err != nil {
+		log.Printf("No se pudo cargar el logo para el informe: %v", err)
+		return 0
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return 0
+	}
+	r.pdf.RegisterImageOptionsReader("logo", fpdf.ImageOptions{ImageType: "PNG"}, &buf)
+	bounds := img.Bounds()
+	return 14 * float64(bounds.Dx()) / float64(bounds.Dy())
+}
+
+func (r *pdfReport) money(amount Money) string {
+	return r.settings.FormatMoney(amount)
+}
+
+// title escribe el título de una página
+func (r *pdfReport) title(text string) {
+	r.pdf.SetFont("Helvetica", "B", 16)
+	r.pdf.CellFormat(0, 9, r.tr(text), "", 1, "L", false, 0, "")
+}
+
+// section escribe el encabezado de una sección
+func (r *pdfReport) section(text string) {
+	r.pdf.Ln(4)
+	r.pdf.SetFont("Helvetica", "B", 11)
+	r.pdf.CellFormat(0, 7, r.tr(text), "B", 1, "L", false, 0, "")
+	r.pdf.Ln(1)
+	r.pdf.SetFont("Helvetica", "", 10)
+}
+
+// tableHeader escribe la fila de encabezado de una tabla; aligns usa "L", "C" o "R" por columna
+func (r *pdfReport) tableHeader(widths []float64, aligns []string, headers ...string) {
+	r.pdf.SetFont("Helvetica", "B", 9)
+	r.pdf.SetFillColor(235, 235, 235)
+	for i, header := range headers {
+		r.pdf.CellFormat(widths[i], 6, r.tr(header), "", 0, aligns[i], true, 0, "")
+	}
+	r.pdf.Ln(-1)
+	r.pdf.SetFont("Helvetica", "", 9)
+}
+
+// tableRow escribe una fila de una tabla
+func (r *pdfReport) tableRow(widths []float64, aligns []string, cells ...string) {
+	for i, cell := range cells {
+		r.pdf.CellFormat(widths[i], 6, r.tr(cell), "", 0, aligns[i], false, 0, "")
+	}
+	r.pdf.Ln(-1)
+}
+
+// total escribe una línea de importe alineada a la derecha
+func (r *pdfReport) total(label string, amount Money, bold bool) {
+	style := ""
+	if bold {
+		style = "B"
+	}
+	r.pdf.SetFont("Helvetica", style, 10)
+	r.pdf.CellFormat(pdfContentWidth-40, 6, r.tr(label), "", 0, "R", false, 0, "")
+	r.pdf.CellFormat(40, 6, r.tr(r.money(amount)), "", 1, "R", false, 0, "")
+}
+
+// Order agrega una página con el detalle de una orden: encabezado, seguimiento, ítems,
+// desglose de impuestos, pagos y eventos. La orden debe venir de preloadReportOrder.
+func (r *pdfReport) Order(order Order, events []OrderEvent) {
+	pdf := r.pdf
+	pdf.AddPage()
+	r.title(fmt.Sprintf("Orden #%d · Mesa %d", order.ID, order.TableNum))
+	pdf.SetFont("Helvetica", "", 10)
+	pdf.CellFormat(0, 6, r.tr("Estado: "+statusLabel(order.Status)), "", 1, "L", false, 0, "")
+	if order.Notes != "" {
+		pdf.MultiCell(0, 5, r.tr("Notas: "+order.Notes), "", "L", false)
+	}
+
+	r.section("Seguimiento")
+	widths, aligns := []float64{50, 50, 80}, []string{"L", "L", "L"}
+	r.tableHeader(widths, aligns, "Paso", "Fecha y hora", "Personal")
+	createdAt := order.CreatedAt
+	steps := []struct {
+		label string
+		at    *time.Time
+		by    *User
+	}{
+		{"Creada", &createdAt, order.CreatedBy},
+		{"Enviada a cocina", order.SentToKitchenAt, order.SentToKitchenBy},
+		{"Cocina terminada", order.CookingCompletedAt, nil},
+		{"Entregada", order.DeliveredAt, order.DeliveredBy},
+		{"Cobrada", order.CompletedAt, order.CompletedBy},
+	}
+	for _, step := range steps {
+		if step.at == nil {
+			continue
+		}
+		staff := ""
+		if step.by != nil {
+			staff = step.by.DisplayName()
+		}
+		r.tableRow(widths, aligns, step.label, step.at.Format("02/01/2006 15:04:05"), staff)
+	}
+	if order.CancelledBy != nil {
+		r.tableRow(widths, aligns, "Cancelada", "", order.CancelledBy.DisplayName())
+	}
+
+	r.section("Productos")
+	widths, aligns = []float64{95, 30, 20, 35}, []string{"L", "R", "R", "R"}
+	r.tableHeader(widths, aligns, "Producto", "Precio", "Cant.", "Importe")
+	for _, item := range order.Items {
+		name := item.ProductName
+		if item.Seat > 0 {
+			name += " (asiento " + strconv.Itoa(item.Seat) + ")"
+		}
+		r.tableRow(widths, aligns, name, r.money(item.UnitPrice), strconv.Itoa(item.Quantity), r.money(item.UnitPrice.Mul(item.Quantity)))
+		if item.ModifierNames != "" {
+			pdf.SetFont("Helvetica", "", 8)
+			pdf.MultiCell(widths[0], 4, r.tr("   "+item.ModifierNames), "", "L", false)
+			pdf.SetFont("Helvetica", "", 9)
+		}
+		if item.Notes != "" {
+			pdf.SetFont("Helvetica", "I", 8)
+			pdf.SetTextColor(100, 100, 100)
+			pdf.CellFormat(0, 4, r.tr("   "+item.Notes), "", 1, "L", false, 0, "")
+			pdf.SetFont("Helvetica", "", 9)
+			pdf.SetTextColor(0, 0, 0)
+		}
+	}
+
+	pdf.Ln(2)
+	if order.PricesIncludeTax {
+		r.total("Base imponible:", order.Subtotal, false)
+		r.total("Impuesto "+formatRate(order.TaxRate)+"% (incluido):", order.TaxAmount, false)
+	} else {
+		r.total("Subtotal:", order.Subtotal, false)
+		r.total("Impuesto "+formatRate(order.TaxRate)+"%:", order.TaxAmount, false)
+	}
+	if order.ServiceCharge > 0 {
+		r.total("Cargo por servicio:", order.ServiceCharge, false)
+	}
+	if order.Tip > 0 {
+		r.total("Propina:", order.Tip, false)
+	}
+	r.total("Total:", order.Total, true)
+	if order.Refunded > 0 {
+		r.total("Reembolsado tras el cierre:", -order.Refunded, false)
+	}
+
+	if len(order.Payments) > 0 {
+		r.section("Pagos")
+		widths, aligns = []float64{35, 45, 65, 35}, []string{"L", "L", "L", "R"}
+		r.tableHeader(widths, aligns, "Fecha", "Método", "Detalle", "Importe")
+		for _, payment := range order.Payments {
+			method := paymentMethodLabel(payment.Method)
+			if payment.RefundOfID != nil {
+				method = "Reembolso " + method
+			}
+			detail := payment.Reference
+			if payment.Tendered > 0 {
+				detail = joinNonEmpty(" · ", detail, "Recibido "+r.money(payment.Tendered)+", cambio "+r.money(payment.Change))
+			}
+			if payment.Check != nil {
+				detail = joinNonEmpty(" · ", "Cuenta "+strconv.Itoa(payment.Check.Number), detail)
+			}
+			r.tableRow(widths, aligns, payment.CreatedAt.Format("02/01/2006 15:04"), method, detail, r.money(payment.Amount))
+		}
+	}
+
+	if len(events) > 0 {
+		r.section("Historial")
+		for _, event := range events {
+			actor := "Sistema"
+			if event.Actor != nil {
+				actor = event.Actor.DisplayName()
+			}
+			line := event.CreatedAt.Format("02/01 15:04") + "  " + orderEventDescription(event) + " (" + actor + ")"
+			if event.Reason != "" {
+				line += ". Motivo: " + event.Reason
+			}
+			pdf.MultiCell(0, 5, r.tr(line), "", "L", false)
+		}
+	}
+}
+
+// Range agrega una página de resumen con los totales de las órdenes del período y su listado
+func (r *pdfReport) Range(orders []Order, from, to time.Time) {
+	pdf := r.pdf
+	pdf.AddPage()
+	r.title("Reporte de órdenes")
+	pdf.SetFont("Helvetica", "", 10)
+	pdf.CellFormat(0, 6, r.tr("Período: "+from.Format("02/01/2006")+" - "+to.Format("02/01/2006")), "", 1, "L", false, 0, "")
+	pdf.CellFormat(0, 6, r.tr(fmt.Sprintf("Órdenes completadas: %d", len(orders))), "", 1, "L", false, 0, "")
+
+	var subtotal, tax, service, tips, refunded, total Money
+	byMethod := map[string]Money{}
+	var methods []string
+	for _, order := range orders {
+		subtotal += order.Subtotal
+		tax += order.TaxAmount
+		service += order.ServiceCharge
+		tips += order.Tip
+		refunded += order.Refunded
+		total += order.NetTotal()
+		for _, payment := range order.Payments {
+			if _, ok := byMethod[payment.Method]; !ok {
+				methods = append(methods, payment.Method)
+			}
+			byMethod[payment.Method] += payment.Amount
+		}
+	}
+
+	r.section("Resumen")
+	r.total("Subtotal:", subtotal, false)
+	r.total("Impuestos:", tax, false)
+	r.total("Cargos por servicio:", service, false)
+	r.total("Propinas:", tips, false)
+	if refunded > 0 {
+		r.total("Reembolsos:", -refunded, false)
+	}
+	r.total("Total ventas:", total, true)
+
+	if len(methods) > 0 {
+		r.section("Cobros por método")
+		for _, method := range methods {
+			r.total(paymentMethodLabel(method)+":", byMethod[method], false)
+		}
+	}
+
+	r.section("Órdenes")
+	widths, aligns := []float64{20, 25, 45, 25, 30, 35}, []string{"L", "L", "L", "R", "R", "R"}
+	r.tableHeader(widths, aligns, "#", "Mesa", "Fecha", "Ítems", "Impuesto", "Total")
+	for _, order := range orders {
+		quantity := 0
+		for _, item := range order.Items {
+			quantity += item.Quantity
+		}
+		r.tableRow(widths, aligns, "#"+strconv.Itoa(int(order.ID)), strconv.Itoa(order.TableNum),
+			order.CreatedAt.Format("02/01/2006 15:04"), strconv.Itoa(quantity), r.money(order.TaxAmount), r.money(order.Total))
+	}
+}
+
+// preloadReportOrder precarga todo lo que muestra el informe de una orden
+func preloadReportOrder(tx *gorm.DB) *gorm.DB {
+	return preloadPayments(tx).
+		Preload("Items", func(db *gorm.DB) *gorm.DB {
+			return orderLines(db).Order("id ASC")
+		}).
+		Preload("CreatedBy", unscopedUsers).
+		Preload("SentToKitchenBy", unscopedUsers).
+		Preload("DeliveredBy", unscopedUsers).
+		Preload("CompletedBy", unscopedUsers).
+		Preload("CancelledBy", unscopedUsers)
+}
+
+// orderEventDescription describe un evento de la orden en texto plano (ver partials/order_timeline)
+func orderEventDescription(event OrderEvent) string {
+	switch event.Type {
+	case EventStatus:
+		if event.FromStatus == "" {
+			return "Orden creada (" + statusLabel(event.ToStatus) + ")"
+		}
+		return "Estado: " + statusLabel(event.FromStatus) + " -> " + statusLabel(event.ToStatus)
+	case EventItemAdded:
+		return fmt.Sprintf("Agregado: %d × %s", event.NewQuantity, event.ProductName)
+	case EventItemRemoved:
+		return fmt.Sprintf("Eliminado: %d × %s", event.OldQuantity, event.ProductName)
+	case EventItemQuantity:
+		return fmt.Sprintf("Cantidad de %s: %d -> %d", event.ProductName, event.OldQuantity, event.NewQuantity)
+	case EventTransfer:
+		return fmt.Sprintf("Cambio de mesa: %d -> %d", event.FromTable, event.ToTable)
+	case EventMerge:
+		description := fmt.Sprintf("Unión de órdenes: mesa %d -> mesa %d", event.FromTable, event.ToTable)
+		if event.RelatedOrderID != nil {
+			description += fmt.Sprintf(" (orden #%d)", *event.RelatedOrderID)
+		}
+		return description
+	}
+	return event.Type
+}
+
+// joinNonEmpty une con sep los textos que no están vacíos
+func joinNonEmpty(sep string, parts ...string) string {
+	result := ""
+	for _, part := range parts {
+		if part == "" {
+			continue
+		}
+		if result != "" {
+			result += sep
+		}
+		result += part
+	}
+	return result
+}
+
+// sendPDF responde con el informe como PDF para ver en el navegador
+func sendPDF(c *fiber.Ctx, report *pdfReport, filename string) error {
+	var buf bytes.Buffer
+	if err := report.pdf.Output(&buf); err != nil {
+		log.Printf("Error al generar el PDF %s: %v", filename, err)
+		return c.Status(fiber.StatusInternalServerError).SendString("Error al generar el PDF")
+	}
+	c.Set(fiber.HeaderContentType, "application/pdf")
+	c.Set(fiber.HeaderContentDisposition, `inline; filename="`+filename+`"`)
+	return c.Send(buf.Bytes())
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// OrderPricing es el desglose de importes de una orden
+type OrderPricing struct {
+	Subtotal      Money // Base sin impuesto
+	TaxAmount     Money
+	ServiceCharge Money
+	Tip           Money
+	Total         Money
+}
+
+// priceOrder calcula el desglose de una orden a partir de sus ítems, la propina y la configuración.
+// Es el único lugar donde se calculan impuestos y cargos: todo total debe pasar por aquí.
+//
+// Con precios sin impuesto (por defecto) el impuesto se suma a la suma de los ítems.
+// Con precios con impuesto incluido, el impuesto se extrae de esa suma y el subtotal es la base neta.
+// El cargo por servicio se calcula sobre el subtotal y la propina se suma al final, ambos sin impuesto.
+// Los importes son enteros en centavos; las tasas se redondean al centavo en cada paso.
+func priceOrder(items []OrderItem, tip Money, settings Settings) OrderPricing {
+	var gross Money
+	for _, item := range items {
+		gross += item.UnitPrice.Mul(item.Quantity)
+	}
+
+	var pricing OrderPricing
+	if settings.PricesIncludeTax {
+		pricing.Subtotal = Money(math.Round(float64(gross) / (1 + settings.TaxRate)))
+		pricing.TaxAmount = gross - pricing.Subtotal
+	} else {
+		pricing.Subtotal = gross
+		pricing.TaxAmount = gross.MulRate(settings.TaxRate)
+	}
+	pricing.ServiceCharge = pricing.Subtotal.MulRate(settings.ServiceChargeRate)
+	pricing.Tip = tip
+	pricing.Total = pricing.Subtotal + pricing.TaxAmount + pricing.ServiceCharge + pricing.Tip
+	return pricing
+}
+
+// applyPricing copia el desglose y las tasas usadas en la orden
+func (order *Order) applyPricing(pricing OrderPricing, settings Settings) {
+	order.Subtotal = pricing.Subtotal
+	order.TaxRate = settings.TaxRate
+	order.PricesIncludeTax = settings.PricesIncludeTax
+	order.TaxAmount = pricing.TaxAmount
+	order.ServiceCharge = pricing.ServiceCharge
+	order.Tip = pricing.Tip
+	order.Total = pricing.Total
+}
+
+// recalculateOrderTotals recalcula el desglose de la orden a partir de sus ítems y lo guarda en tx.
+// Falla si hay cuentas separadas con pagos: el importe de cada cuenta sale del total de la orden,
+// así que cambiar ítems o propina movería dinero entre las cuentas ya pagadas y las pendientes.
+func recalculateOrderTotals(tx *gorm.DB, order *Order) error {
+	paid, err := hasPaidChecks(tx, order.ID)
+	if err != nil {
+		return err
+	}
+	if paid {
+		return fiber.NewError(fiber.StatusBadRequest, "Hay cuentas separadas con pagos: no se pueden cambiar los productos ni la propina")
+	}
+
+	var items []OrderItem
+	if err := tx.Where("order_id = ?", order.ID).Find(&items).Error; err != nil {
+		return err
+	}
+
+	settings := loadSettings(tx)
+	order.applyPricing(priceOrder(items, order.Tip, settings), settings)
+	return tx.Model(order).Select("subtotal", "tax_rate", "prices_include_tax", "tax_amount", "service_charge", "tip", "total").Updates(order).Error
+}
+
+// UpdateOrderTip registra la propina de una orden abierta
+func UpdateOrderTip(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).SendString("ID inválido")
+	}
+
+	var tip Money
+	if value := strings.TrimSpace(c.FormValue("tip")); value != "" {
+		tip, err = ParseMoney(value)
+		if err != nil || tip < 0 {
+			return Error(c, "Propina inválida", fiber.StatusBadRequest)
+		}
+	}
+
+	var order Order
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := lockOrder(tx, uint(id), &order); err != nil {
+			return err
+		}
+		if !isOrderEditable(order.Status) {
+			return errOrderClosed(order)
+		}
+		order.Tip = tip
+		return recalculateOrderTotals(tx, &order)
+	})
+	if err != nil {
+		return orderErrorResponse(c, err)
+	}
+	broadcastOrderUpdate(order)
+
+	c.Set("HX-Trigger", `{"showToast": "Propina actualizada"}`)
+	return renderOrderItems(c, order.ID)
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif" // Formatos de logo admitidos
+	_ "image/jpeg"
+	_ "image/png"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Columnas de texto de una impresora térmica de 80 mm con la fuente por defecto
+const receiptColumns = 48
+
+// Ancho máximo en puntos de una imagen en papel de 80 mm
+const receiptImageWidth = 384
+
+// Puerto estándar de impresión directa (RAW / JetDirect)
+const defaultPrinterPort = "9100"
+
+// Comandos ESC/POS usados por los tickets
+var (
+	escInit        = []byte{0x1b, '@'}
+	escCodePage850 = []byte{0x1b, 't', 2}
+	escAlignLeft   = []byte{0x1b, 'a', 0}
+	escAlignCenter = []byte{0x1b, 'a', 1}
+	escBoldOn      = []byte{0x1b, 'E', 1}
+	escBoldOff     = []byte{0x1b, 'E', 0}
+	escDoubleOn    = []byte{0x1d, '!', 0x11}
+	escDoubleOff   = []byte{0x1d, '!', 0x00}
+	escFeedAndCut  = []byte{0x1d, 'V', 66, 3}
+)
+
+// escpos arma un flujo de bytes ESC/POS
+type escpos struct {
+	buf bytes.Buffer
+}
+
+// newESCPOS inicia un ticket con la impresora reiniciada y la página de códigos 850 (acentos y ñ)
+func newESCPOS() *escpos {
+	p := &escpos{}
+	p.buf.Write(escInit)
+	p.buf.Write(escCodePage850)
+	return p
+}
+
+func (p *escpos) raw(command []byte) *escpos {
+	p.buf.Write(command)
+	return p
+}
+
+// Center y Left cambian la alineación de las líneas siguientes
+func (p *escpos) Center() *escpos { return p.raw(escAlignCenter) }
+func (p *escpos) Left() *escpos   { return p.raw(escAlignLeft) }
+
+// Bold activa o desactiva la negrita
+func (p *escpos) Bold(on bool) *escpos {
+	if on {
+		return p.raw(escBoldOn)
+	}
+	return p.raw(escBoldOff)
+}
+
+// Double activa o desactiva el texto a doble alto y ancho
+func (p *escpos) Double(on bool) *escpos {
+	if on {
+		return p.raw(escDoubleOn)
+	}
+	return p.raw(escDoubleOff)
+}
+
+// Line escribe una línea de texto
+func (p *escpos) Line(text string) *escpos {
+	p.buf.Write(encodeCP850(text))
+	p.buf.WriteByte('\n')
+	return p
+}
+
+// Columns escribe un texto a la izquierda y otro alineado a la derecha en una misma línea;
+// si no caben, el de la izquierda se parte en varias líneas
+func (p *escpos) Columns(left, right string, width int) *escpos {
+	space := width - utf8.RuneCountInString(right) - 1
+	lines := wrapText(left, space)
+	for _, line := range lines[:len(lines)-1] {
+		p.Line(line)
+	}
+	last := lines[len(lines)-1]
+	padding := width - utf8.RuneCountInString(last) - utf8.RuneCountInString(right)
+	return p.Line(last + strings.Repeat(" ", padding) + right)
+}
+
+// Separator escribe una línea de guiones
+func (p *escpos) Separator(width int) *escpos {
+	return p.Line(strings.Repeat("-", width))
+}
+
+// Image imprime una imagen en blanco y negro con el comando de raster GS v 0
+func (p *escpos) Image(img image.Image) *escpos {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width == 0 || height == 0 {
+		return p
+	}
+	// Escalar (vecino más cercano) para que quepa en el papel
+	if width > receiptImageWidth {
+		height = height * receiptImageWidth / width
+		width = receiptImageWidth
+	}
+
+	bytesPerRow := (width + 7) / 8
+	p.buf.Write([]byte{0x1d, 'v', '0', 0, byte(bytesPerRow), byte(bytesPerRow >> 8), byte(height), byte(height >> 8)})
+	for y := 0; y < height; y++ {
+		row := make([]byte, bytesPerRow)
+		for x := 0; x < width; x++ {
+			srcX := bounds.Min.X + x*bounds.Dx()/width
+			srcY := bounds.Min.Y + y*bounds.Dy()/height
+			r, g, b, a := img.At(srcX, srcY).RGBA()
+			// Un punto negro si el píxel es opaco y oscuro
+			luminance := (299*r + 587*g + 114*b) / 1000
+			if a > 0x8000 && luminance < 0x8000 {
+				row[x/8] |= 0x80 >> uint(x%8)
+			}
+		}
+		p.buf.Write(row)
+	}
+	return p
+}
+
+// Cut avanza el papel y lo corta
+func (p *escpos) Cut() *escpos {
+	return p.raw(escFeedAndCut)
+}
+
+// Bytes devuelve el flujo ESC/POS armado
+func (p *escpos) Bytes() []byte {
+	return p.buf.Bytes()
+}
+
+// cp850 traduce los caracteres no ASCII habituales en español a la página de códigos 850
+var cp850 = map[rune]byte{
+	'á': 0xa0, 'é': 0x82, 'í': 0xa1, 'ó': 0xa2, 'ú': 0xa3, 'ñ': 0xa4, 'Ñ': 0xa5, 'ü': 0x81, 'Ü': 0x9a,
+	'Á': 0xb5, 'É': 0x90, 'Í': 0xd6, 'Ó': 0xe0, 'Ú': 0xe9, '¿': 0xa8, '¡': 0xad, '°': 0xf8, '×': 0x9e,
+}
+
+// encodeCP850 convierte texto UTF-8 a la página de códigos de la impresora; lo desconocido se imprime como '?'
+func encodeCP850(text string) []byte {
+	out := make([]byte, 0, len(text))
+	for _, r := range text {
+		switch {
+		case r < 0x80:
+			out = append(out, byte(r))
+		case cp850[r] != 0:
+			out = append(out, cp850[r])
+		default:
+			out = append(out, '?')
+		}
+	}
+	return out
+}
+
+// wrapText parte un texto en líneas de como máximo width caracteres, cortando por palabras
+func wrapText(text string, width int) []string {
+	if width < 1 {
+		width = 1
+	}
+	var lines []string
+	line := ""
+	for _, word := range strings.Fields(text) {
+		for utf8.RuneCountInString(word) > width {
+			runes := []rune(word)
+			if line != "" {
+				lines = append(lines, line)
+				line = ""
+			}
+			lines = append(lines, string(runes[:width]))
+			word = string(runes[width:])
+		}
+		switch {
+		case line == "":
+			line = word
+		case utf8.RuneCountInString(line)+1+utf8.RuneCountInString(word) <= width:
+			line += " " + word
+		default:
+			lines = append(lines, line)
+			line = word
+		}
+	}
+	return append(lines, line)
+}
+
+// loadPrinterImage lee una imagen subida (ruta pública /static/...) para imprimirla
+func loadPrinterImage(publicPath string) (image.Image, error) {
+	file, err := os.Open("." + publicPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	img, _, err := image.Decode(file)
+	return img, err
+}
+
+// Dispositivos locales a los que se puede imprimir: impresoras USB, paralelas y seriales.
+// Cualquier otra ruta se rechaza para que la configuración no permita escribir archivos del servidor.
+var printerDevicePrefixes = []string{"/dev/usb/lp", "/dev/lp", "/dev/ttyUSB", "/dev/ttyACM", "/dev/ttyS"}
+
+// parsePrinterTarget valida el destino de una impresora y devuelve la red ("tcp" o "device") y la dirección.
+// target puede ser "host" o "host:puerto" (puerto 9100 por defecto, admite el prefijo tcp://)
+// o un dispositivo como /dev/usb/lp0.
+func parsePrinterTarget(target string) (network, address string, err error) {
+	target = strings.TrimSpace(target)
+	if target == "" {
+		return "", "", errors.New("no hay impresora configurada")
+	}
+
+	if strings.HasPrefix(target, "/") {
+		for _, prefix := range printerDevicePrefixes {
+			if number, ok := strings.CutPrefix(target, prefix); ok && number != "" && isDigits(number) {
+				return "device", target, nil
+			}
+		}
+		return "", "", fmt.Errorf("dispositivo de impresora no admitido %q: usa /dev/usb/lpN, /dev/lpN o un puerto serie", target)
+	}
+
+	address = strings.TrimPrefix(target, "tcp://")
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		host, port = strings.Trim(address, "[]"), defaultPrinterPort
+	}
+	if host == "" || strings.ContainsAny(host, "/\\ ") {
+		return "", "", fmt.Errorf("dirección de impresora inválida %q: usa host o host:puerto", target)
+	}
+	if number, err := strconv.Atoi(port); err != nil || number < 1 || number > 65535 {
+		return "", "", fmt.Errorf("puerto de impresora inválido %q", port)
+	}
+	return "tcp", net.JoinHostPort(host, port), nil
+}
+
+// validatePrinterTarget comprueba una impresora ingresada en la configuración; vacío es válido (sin impresora)
+func validatePrinterTarget(target string) error {
+	if strings.TrimSpace(target) == "" {
+		return nil
+	}
+	_, _, err := parsePrinterTarget(target)
+	return err
+}
+
+// sendToPrinter envía un ticket a una impresora (ver parsePrinterTarget): por TCP en impresión RAW
+// o escribiendo en el dispositivo local
+func sendToPrinter(target string, data []byte) error {
+	network, address, err := parsePrinterTarget(target)
+	if err != nil {
+		return err
+	}
+
+	if network == "device" {
+		file, err := os.OpenFile(address, os.O_WRONLY, 0)
+		if err != nil {
+			return fmt.Errorf("abrir %s: %w", address, err)
+		}
+		defer file.Close()
+		_, err = file.Write(data)
+		return err
+	}
+
+	conn, err := net.DialTimeout("tcp", address, 5*time.Second)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err := conn.SetWriteDeadline(time.Now().Add(10 * time.Second)); err != nil {
+		return err
+	}
+	_, err = conn.Write(data)
+	return err
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// listenPrinter levanta una impresora falsa en un puerto TCP local y devuelve su dirección
+// y un canal con todo lo recibido en la primera conexión
+func listenPrinter(t *testing.T) (string, <-chan []byte) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("no se pudo abrir el listener: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	received := make(chan []byte, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			close(received)
+			return
+		}
+		defer conn.Close()
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		data, _ := io.ReadAll(conn)
+		received <- data
+	}()
+	return ln.Addr().String(), received
+}
+
+// waitPrinted espera lo que recibió la impresora falsa
+func waitPrinted(t *testing.T, received <-chan []byte) []byte {
+	t.Helper()
+	select {
+	case data := <-received:
+		return data
+	case <-time.After(5 * time.Second):
+		t.Fatal("la impresora no recibió datos")
+		return nil
+	}
+}
+
+// assertESCPOS comprueba el inicio, la página de códigos, el corte final y los textos codificados en CP850
+func assertESCPOS(t *testing.T, data []byte, texts ...string) {
+	t.Helper()
+	header := append(append([]byte{}, escInit...), escCodePage850...)
+	if !bytes.HasPrefix(data, header) {
+		t.Errorf("el ticket no empieza con ESC @ y ESC t 2: % x", data[:min(len(data), 8)])
+	}
+	if !bytes.HasSuffix(data, escFeedAndCut) {
+		t.Errorf("el ticket no termina con el corte de papel")
+	}
+	for _, text := range texts {
+		encoded := encodeCP850(text)
+		if !bytes.Contains(data, encoded) {
+			t.Errorf("el ticket no contiene %q codificado en CP850 (% x)", text, encoded)
+		}
+		if bytes.Contains(data, []byte(text)) && !bytes.Equal(encoded, []byte(text)) {
+			t.Errorf("el ticket contiene %q en UTF-8", text)
+		}
+	}
+}
+
+func TestSendReceiptToTCPPrinter(t *testing.T) {
+	address, received := listenPrinter(t)
+
+	order := Order{
+		ID:       42,
+		TableNum: 3,
+		Items: []OrderItem{
+			{ProductName: "Café con leche", Quantity: 2, UnitPrice: 350},
+			{ProductName: "Piña colada", Quantity: 1, UnitPrice: 900},
+		},
+		Subtotal:  1600,
+		TaxRate:   0.16,
+		TaxAmount: 256,
+		Total:     1856,
+		Payments:  []Payment{{Method: PaymentCash, Amount: 1856, Tendered: 2000, Change: 144}},
+		CreatedAt: time.Date(2024, 5, 10, 21, 30, 0, 0, time.Local),
+	}
+	settings := Settings{RestaurantName: "Cafetería Ñandú", CurrencySymbol: "$"}
+
+	if err := sendToPrinter(address, buildReceipt(order, settings)); err != nil {
+		t.Fatalf("sendToPrinter: %v", err)
+	}
+	data := waitPrinted(t, received)
+
+	assertESCPOS(t, data, "Cafetería Ñandú", "2 x Café con leche", "1 x Piña colada", "$18.56", "¡Gracias por su visita!")
+	if !bytes.Contains(data, []byte("Caf\x82 con leche")) {
+		t.Errorf("la é de Café no se codificó como 0x82")
+	}
+}
+
+func TestSendKitchenTicketToTCPPrinter(t *testing.T) {
+	address, received := listenPrinter(t)
+
+	order := Order{ID: 7, TableNum: 12}
+	items := []OrderItem{
+		{ProductName: "Jalapeños rellenos", Quantity: 1, Notes: "Sin crema, acompañar con limón"},
+		{ProductName: "Sopa azteca", Quantity: 3, Seat: 2},
+	}
+	ticket := buildKitchenTicket(order, "Cocina", items, true, time.Date(2024, 5, 10, 21, 30, 0, 0, time.Local))
+
+	if err := sendToPrinter("tcp://"+address, ticket); err != nil {
+		t.Fatalf("sendToPrinter: %v", err)
+	}
+	data := waitPrinted(t, received)
+
+	assertESCPOS(t, data, "*** ADICIÓN ***", "MESA 12", "1 x Jalapeños rellenos", "acompañar con limón", "Asiento 2")
+	if !bytes.Contains(data, []byte("Jalape\xa4os")) {
+		t.Errorf("la ñ no se codificó como 0xa4")
+	}
+}
+
+func TestSendToUnreachablePrinter(t *testing.T) {
+	// Un puerto recién liberado: nadie escucha en él
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("no se pudo abrir el listener: %v", err)
+	}
+	address := ln.Addr().String()
+	ln.Close()
+
+	if err := sendToPrinter(address, newESCPOS().Line("Prueba").Cut().Bytes()); err == nil {
+		t.Fatal("se esperaba un error al imprimir en una impresora inalcanzable")
+	}
+}
+
+func TestParsePrinterTarget(t *testing.T) {
+	tests := []struct {
+		target  string
+		network string
+		address string
+		ok      bool
+	}{
+		{"192.168.1.50", "tcp", "192.168.1.50:9100", true},
+		{"192.168.1.50:9101", "tcp", "192.168.1.50:9101", true},
+		{"tcp://printer.local:9100", "tcp", "printer.local:9100", true},
+		{" cocina.local ", "tcp", "cocina.local:9100", true},
+		{"[::1]:9100", "tcp", "[::1]:9100", true},
+		{"/dev/usb/lp0", "device", "/dev/usb/lp0", true},
+		{"/dev/ttyUSB1", "device", "/dev/ttyUSB1", true},
+		{"", "", "", false},
+		{"/etc/passwd", "", "", false},
+		{"/dev/usb/lp0/../../../etc/passwd", "", "", false},
+		{"/dev/usb/lp", "", "", false},
+		{"./tickets.bin", "", "", false},
+		{"file:///tmp/tickets.bin", "", "", false},
+		{"printer.local:abc", "", "", false},
+		{"printer.local:70000", "", "", false},
+	}
+	for _, tt := range tests {
+		network, address, err := parsePrinterTarget(tt.target)
+		if tt.ok != (err == nil) {
+			t.Errorf("parsePrinterTarget(%q): error %v, se esperaba ok=%v", tt.target, err, tt.ok)
+			continue
+		}
+		if network != tt.network || address != tt.address {
+			t.Errorf("parsePrinterTarget(%q) = %q, %q; se esperaba %q, %q", tt.target, network, address, tt.network, tt.address)
+		}
+	}
+}
@@ -0,0 +1,590 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Estados de una orden de compra
+const (
+	PurchaseDraft     = "draft"     // Se están armando las líneas
+	PurchaseOrdered   = "ordered"   // Enviada al proveedor; puede tener entregas parciales
+	PurchaseReceived  = "received"  // Recepción cerrada
+	PurchaseCancelled = "cancelled" // Cancelada sin recibir nada
+)
+
+// purchaseStatusLabels son los nombres de los estados para mostrar
+var purchaseStatusLabels = map[string]string{
+	PurchaseDraft:     "Borrador",
+	PurchaseOrdered:   "Enviada",
+	PurchaseReceived:  "Recibida",
+	PurchaseCancelled: "Cancelada",
+}
+
+// Cantidad de órdenes de compra que se muestran en la lista
+const purchaseOrdersLimit = 50
+
+// StatusLabel devuelve el nombre del estado de la orden de compra
+func (p PurchaseOrder) StatusLabel() string {
+	return purchaseStatusLabels[p.Status]
+}
+
+// IsOpen indica si a la orden de compra todavía le pueden llegar entregas
+func (p PurchaseOrder) IsOpen() bool {
+	return p.Status == PurchaseDraft || p.Status == PurchaseOrdered
+}
+
+// Total es el costo de lo pedido, al costo pedido
+func (p PurchaseOrder) Total() Money {
+	var total Money
+	for _, line := range p.Lines {
+		total += line.Total()
+	}
+	return total
+}
+
+// ReceivedTotal es el costo de lo recibido, al costo de cada recepción
+func (p PurchaseOrder) ReceivedTotal() Money {
+	var total Money
+	for _, line := range p.Lines {
+		total += line.ReceivedCost
+	}
+	return total
+}
+
+// Total es el costo de la cantidad pedida de la línea
+func (l PurchaseOrderLine) Total() Money {
+	return l.UnitCost.MulRate(l.Quantity)
+}
+
+// Pending devuelve lo que falta recibir de la línea
+func (l PurchaseOrderLine) Pending() float64 {
+	return max(0, l.Quantity-l.ReceivedQuantity)
+}
+
+// loadPurchaseOrder carga la orden de compra con su proveedor y sus líneas, y el costo de lo
+// recibido de cada línea a partir de los movimientos de inventario de sus recepciones
+func loadPurchaseOrder(tx *gorm.DB, id uint) (PurchaseOrder, error) {
+	var order PurchaseOrder
+	err := tx.Preload("Supplier").Preload("CreatedBy", unscopedUsers).
+		Preload("Lines", func(tx *gorm.DB) *gorm.DB { return tx.Order("id") }).
+		Preload("Lines.Ingredient").First(&order, id).Error
+	if err != nil {
+		return order, err
+	}
+
+	var movements []StockMovement
+	if err := tx.Where("purchase_order_id = ?", order.ID).Find(&movements).Error; err != nil {
+		return order, err
+	}
+	order.applyReceipts(movements)
+	return order, nil
+}
+
+// applyReceipts calcula el costo de lo recibido de cada línea con el costo de cada recepción.
+// Cada ingrediente aparece una sola vez en la orden (ver AddPurchaseOrderLine).
+func (p *PurchaseOrder) applyReceipts(movements []StockMovement) {
+	received := map[uint]Money{}
+	for _, movement := range movements {
+		received[movement.IngredientID] += movement.UnitCost.MulRate(movement.Quantity)
+	}
+	for i := range p.Lines {
+		p.Lines[i].ReceivedCost = received[p.Lines[i].IngredientID]
+	}
+}
+
+// loadSuppliers devuelve los proveedores ordenados por nombre
+func loadSuppliers() []Supplier {
+	var suppliers []Supplier
+	db.Order("name").Find(&suppliers)
+	return suppliers
+}
+
+// renderSupplierList devuelve la lista actualizada de proveedores
+func renderSupplierList(c *fiber.Ctx) error {
+	return c.Render("partials/supplier_list", fiber.Map{
+		"Suppliers": loadSuppliers(),
+	}, "")
+}
+
+// renderPurchaseOrder responde con el detalle de una orden de compra para editarla o recibirla
+func renderPurchaseOrder(c *fiber.Ctx, id uint) error {
+	order, err := loadPurchaseOrder(db, id)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).SendString("Orden de compra no encontrada")
+	}
+	return c.Render("partials/purchase_order", fiber.Map{
+		"Order":       order,
+		"Ingredients": loadIngredients(),
+	}, "")
+}
+
+// PurchasingHandler muestra la página de compras: proveedores y órdenes de compra
+func PurchasingHandler(c *fiber.Ctx) error {
+	var openCount int64
+	db.Model(&PurchaseOrder{}).Where("status IN ?", []string{PurchaseDraft, PurchaseOrdered}).Count(&openCount)
+
+	suppliers := loadSuppliers()
+	return c.Render("purchasing", fiber.Map{
+		"Title":      "Compras",
+		"ActivePage": "purchasing",
+		"Suppliers":  suppliers,
+		"OpenCount":  openCount,
+	})
+}
+
+// GetPurchaseOrders devuelve las últimas órdenes de compra, las abiertas primero
+func GetPurchaseOrders(c *fiber.Ctx) error {
+	var orders []PurchaseOrder
+	db.Preload("Supplier").Preload("Lines").
+		Order(clause.OrderBy{Expression: clause.Expr{
+			SQL:  "CASE WHEN status IN ? THEN 0 ELSE 1 END, created_at DESC",
+			Vars: []interface{}{[]string{PurchaseDraft, PurchaseOrdered}},
+		}}).
+		Limit(purchaseOrdersLimit).Find(&orders)
+
+	return c.Render("partials/purchase_order_list", fiber.Map{
+		"Orders": orders,
+	}, "")
+}
+
+// GetSupplierForm muestra el formulario para crear un proveedor
+func GetSupplierForm(c *fiber.Ctx) error {
+	return c.Render("partials/supplier_form", fiber.Map{
+		"IsNew": true,
+	}, "")
+}
+
+// GetSupplierEditForm muestra el formulario para editar un proveedor
+func GetSupplierEditForm(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).SendString("ID inválido")
+	}
+
+	var supplier Supplier
+	if result := db.First(&supplier, id); result.Error != nil {
+		return c.Status(fiber.StatusNotFound).SendString("Proveedor no encontrado")
+	}
+
+	return c.Render("partials/supplier_form", fiber.Map{
+		"Supplier": supplier,
+		"IsNew":    false,
+	}, "")
+}
+
+// supplierFromForm lee los datos del proveedor del formulario
+func supplierFromForm(c *fiber.Ctx, supplier *Supplier) error {
+	supplier.Name = strings.TrimSpace(c.FormValue("name"))
+	if supplier.Name == "" {
+		return errors.New("El nombre es obligatorio")
+	}
+	supplier.Contact = strings.TrimSpace(c.FormValue("contact"))
+	supplier.Phone = strings.TrimSpace(c.FormValue("phone"))
+	supplier.Email = strings.TrimSpace(c.FormValue("email"))
+	supplier.Notes = strings.TrimSpace(c.FormValue("notes"))
+
+	var count int64
+	db.Model(&Supplier{}).Where("LOWER(name) = LOWER(?) AND id <> ?", supplier.Name, supplier.ID).Count(&count)
+	if count > 0 {
+		return errors.New("Ya existe un proveedor con ese nombre")
+	}
+	return nil
+}
+
+// CreateSupplier registra un proveedor
+func CreateSupplier(c *fiber.Ctx) error {
+	var supplier Supplier
+	if err := supplierFromForm(c, &supplier); err != nil {
+		return Error(c, err.Error(), fiber.StatusBadRequest)
+	}
+
+	if err := db.Create(&supplier).Error; err != nil {
+		log.Printf("Error al crear proveedor: %v", err)
+		return Error(c, "Error al crear el proveedor", fiber.StatusInternalServerError)
+	}
+
+	setTrigger(c, fiber.Map{"showToast": "Proveedor '" + supplier.Name + "' creado correctamente", "closeModal": true})
+	return renderSupplierList(c)
+}
+
+// UpdateSupplier actualiza los datos de un proveedor
+func UpdateSupplier(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return Error(c, "ID inválido", fiber.StatusBadRequest)
+	}
+
+	var supplier Supplier
+	if result := db.First(&supplier, id); result.Error != nil {
+		return Error(c, "Proveedor no encontrado", fiber.StatusNotFound)
+	}
+	if err := supplierFromForm(c, &supplier); err != nil {
+		return Error(c, err.Error(), fiber.StatusBadRequest)
+	}
+
+	if err := db.Save(&supplier).Error; err != nil {
+		log.Printf("Error al actualizar proveedor: %v", err)
+		return Error(c, "Error al actualizar el proveedor", fiber.StatusInternalServerError)
+	}
+
+	setTrigger(c, fiber.Map{"showToast": "Proveedor '" + supplier.Name + "' actualizado correctamente", "closeModal": true, "purchaseOrdersChanged": true})
+	return renderSupplierList(c)
+}
+
+// DeleteSupplier elimina un proveedor sin órdenes de compra
+func DeleteSupplier(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return Error(c, "ID inválido", fiber.StatusBadRequest)
+	}
+
+	var supplier Supplier
+	if result := db.First(&supplier, id); result.Error != nil {
+		return Error(c, "Proveedor no encontrado", fiber.StatusNotFound)
+	}
+
+	var used int64
+	db.Model(&PurchaseOrder{}).Where("supplier_id = ?", supplier.ID).Count(&used)
+	if used > 0 {
+		return Error(c, "El proveedor tiene órdenes de compra; no se puede eliminar", fiber.StatusBadRequest)
+	}
+
+	if err := db.Delete(&supplier).Error; err != nil {
+		log.Printf("Error al eliminar proveedor: %v", err)
+		return Error(c, "Error al eliminar el proveedor", fiber.StatusInternalServerError)
+	}
+
+	setTrigger(c, fiber.Map{"showToast": "Proveedor '" + supplier.Name + "' eliminado"})
+	return renderSupplierList(c)
+}
+
+// GetPurchaseOrderForm muestra el formulario para empezar una orden de compra
+func GetPurchaseOrderForm(c *fiber.Ctx) error {
+	return c.Render("partials/purchase_order_form", fiber.Map{
+		"Suppliers": loadSuppliers(),
+	}, "")
+}
+
+// CreatePurchaseOrder crea una orden de compra en borrador y muestra su detalle para agregarle líneas
+func CreatePurchaseOrder(c *fiber.Ctx) error {
+	var supplier Supplier
+	if result := db.First(&supplier, c.FormValue("supplier_id")); result.Error != nil {
+		return Error(c, "Elige un proveedor", fiber.StatusBadRequest)
+	}
+
+	order := PurchaseOrder{
+		SupplierID:  supplier.ID,
+		Status:      PurchaseDraft,
+		Notes:       strings.TrimSpace(c.FormValue("notes")),
+		CreatedByID: currentUserID(c),
+	}
+	if err := db.Create(&order).Error; err != nil {
+		log.Printf("Error al crear orden de compra: %v", err)
+		return Error(c, "Error al crear la orden de compra", fiber.StatusInternalServerError)
+	}
+
+	c.Set("HX-Trigger", `{"showToast": "Orden de compra creada", "toastType": "success", "purchaseOrdersChanged": true}`)
+	return renderPurchaseOrder(c, order.ID)
+}
+
+// GetPurchaseOrder muestra el detalle de una orden de compra
+func GetPurchaseOrder(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).SendString("ID inválido")
+	}
+	return renderPurchaseOrder(c, uint(id))
+}
+
+// draftPurchaseOrder carga una orden de compra que todavía se puede editar
+func draftPurchaseOrder(id uint) (PurchaseOrder, error) {
+	var order PurchaseOrder
+	if result := db.First(&order, id); result.Error != nil {
+		return order, fiber.NewError(fiber.StatusNotFound, "Orden de compra no encontrada")
+	}
+	if order.Status != PurchaseDraft {
+		return order, fiber.NewError(fiber.StatusBadRequest, "Solo se pueden cambiar las órdenes de compra en borrador")
+	}
+	return order, nil
+}
+
+// purchaseErrorResponse responde con el mensaje de los errores de validación (fiber.Error)
+// o con un error genérico para los demás
+func purchaseErrorResponse(c *fiber.Ctx, err error) error {
+	var fiberErr *fiber.Error
+	if errors.As(err, &fiberErr) {
+		return Error(c, fiberErr.Message, fiberErr.Code)
+	}
+	log.Printf("Error al actualizar la orden de compra: %v", err)
+	return Error(c, "Error al actualizar la orden de compra", fiber.StatusInternalServerError)
+}
+
+// AddPurchaseOrderLine agrega un ingrediente a una orden en borrador; si ya estaba, reemplaza
+// su cantidad y su costo
+func AddPurchaseOrderLine(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return Error(c, "ID inválido", fiber.StatusBadRequest)
+	}
+	order, err := draftPurchaseOrder(uint(id))
+	if err != nil {
+		return purchaseErrorResponse(c, err)
+	}
+
+	var ingredient Ingredient
+	if result := db.First(&ingredient, c.FormValue("ingredient_id")); result.Error != nil {
+		return Error(c, "Elige un ingrediente", fiber.StatusBadRequest)
+	}
+	quantity, err := parseQuantity(c.FormValue("quantity"))
+	if err != nil || quantity <= 0 {
+		return Error(c, "La cantidad debe ser mayor que cero", fiber.StatusBadRequest)
+	}
+	// Sin costo se propone el de la última compra
+	unitCost := ingredient.LastCost
+	if value := strings.TrimSpace(c.FormValue("unit_cost")); value != "" {
+		unitCost, err = ParseMoney(value)
+		if err != nil || unitCost < 0 {
+			return Error(c, "Costo inválido", fiber.StatusBadRequest)
+		}
+	}
+
+	var line PurchaseOrderLine
+	db.Where("purchase_order_id = ? AND ingredient_id = ?", order.ID, ingredient.ID).First(&line)
+	line.PurchaseOrderID = order.ID
+	line.IngredientID = ingredient.ID
+	line.Quantity = quantity
+	line.UnitCost = unitCost
+	if err := db.Save(&line).Error; err != nil {
+		log.Printf("Error al agregar línea de compra: %v", err)
+		return Error(c, "Error al agregar el ingrediente", fiber.StatusInternalServerError)
+	}
+
+	c.Set("HX-Trigger", `{"showToast": "Ingrediente agregado", "toastType": "success", "purchaseOrdersChanged": true}`)
+	return renderPurchaseOrder(c, order.ID)
+}
+
+// DeletePurchaseOrderLine quita un ingrediente de una orden en borrador
+func DeletePurchaseOrderLine(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return Error(c, "ID inválido", fiber.StatusBadRequest)
+	}
+	var line PurchaseOrderLine
+	if result := db.First(&line, id); result.Error != nil {
+		return Error(c, "Línea no encontrada", fiber.StatusNotFound)
+	}
+	if _, err := draftPurchaseOrder(line.PurchaseOrderID); err != nil {
+		return purchaseErrorResponse(c, err)
+	}
+
+	if err := db.Delete(&line).Error; err != nil {
+		log.Printf("Error al quitar línea de compra: %v", err)
+		return Error(c, "Error al quitar el ingrediente", fiber.StatusInternalServerError)
+	}
+
+	c.Set("HX-Trigger", `{"showToast": "Ingrediente quitado", "toastType": "success", "purchaseOrdersChanged": true}`)
+	return renderPurchaseOrder(c, line.PurchaseOrderID)
+}
+
+// SendPurchaseOrder marca la orden en borrador como enviada al proveedor
+func SendPurchaseOrder(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return Error(c, "ID inválido", fiber.StatusBadRequest)
+	}
+	order, err := draftPurchaseOrder(uint(id))
+	if err != nil {
+		return purchaseErrorResponse(c, err)
+	}
+
+	var lines int64
+	db.Model(&PurchaseOrderLine{}).Where("purchase_order_id = ?", order.ID).Count(&lines)
+	if lines == 0 {
+		return Error(c, "Agrega al menos un ingrediente antes de enviarla", fiber.StatusBadRequest)
+	}
+
+	now := time.Now()
+	if err := db.Model(&order).Updates(PurchaseOrder{Status: PurchaseOrdered, OrderedAt: &now}).Error; err != nil {
+		log.Printf("Error al enviar orden de compra: %v", err)
+		return Error(c, "Error al enviar la orden de compra", fiber.StatusInternalServerError)
+	}
+
+	c.Set("HX-Trigger", `{"showToast": "Orden de compra enviada", "toastType": "success", "purchaseOrdersChanged": true}`)
+	return renderPurchaseOrder(c, order.ID)
+}
+
+// CancelPurchaseOrder cancela una orden abierta a la que no le llegó nada; si ya tuvo
+// entregas se cierra desde la recepción
+func CancelPurchaseOrder(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return Error(c, "ID inválido", fiber.StatusBadRequest)
+	}
+	var order PurchaseOrder
+	if result := db.First(&order, id); result.Error != nil {
+		return Error(c, "Orden de compra no encontrada", fiber.StatusNotFound)
+	}
+	if !order.IsOpen() {
+		return Error(c, "La orden de compra ya está cerrada", fiber.StatusBadRequest)
+	}
+
+	var received int64
+	db.Model(&PurchaseOrderLine{}).Where("purchase_order_id = ? AND received_quantity > 0", order.ID).Count(&received)
+	if received > 0 {
+		return Error(c, "La orden ya tuvo entregas; ciérrala desde la recepción", fiber.StatusBadRequest)
+	}
+
+	if err := db.Model(&order).Update("status", PurchaseCancelled).Error; err != nil {
+		log.Printf("Error al cancelar orden de compra: %v", err)
+		return Error(c, "Error al cancelar la orden de compra", fiber.StatusInternalServerError)
+	}
+
+	c.Set("HX-Trigger", `{"showToast": "Orden de compra cancelada", "toastType": "success", "purchaseOrdersChanged": true}`)
+	return renderPurchaseOrder(c, order.ID)
+}
+
+// DeletePurchaseOrder elimina una orden de compra en borrador
+func DeletePurchaseOrder(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return Error(c, "ID inválido", fiber.StatusBadRequest)
+	}
+	order, err := draftPurchaseOrder(uint(id))
+	if err != nil {
+		return purchaseErrorResponse(c, err)
+	}
+
+	if err := db.Select("Lines").Delete(&order).Error; err != nil {
+		log.Printf("Error al eliminar orden de compra: %v", err)
+		return Error(c, "Error al eliminar la orden de compra", fiber.StatusInternalServerError)
+	}
+
+	c.Set("HX-Trigger", `{"showToast": "Orden de compra eliminada", "closeModal": true, "purchaseOrdersChanged": true}`)
+	return c.SendString("")
+}
+
+// GetPurchaseOrderReceive muestra el formulario de recepción con lo pendiente de cada línea
+func GetPurchaseOrderReceive(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).SendString("ID inválido")
+	}
+	order, err := loadPurchaseOrder(db, uint(id))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).SendString("Orden de compra no encontrada")
+	}
+	if !order.IsOpen() || len(order.Lines) == 0 {
+		return renderPurchaseOrder(c, order.ID)
+	}
+	return c.Render("partials/purchase_order_receive", fiber.Map{
+		"Order": order,
+	}, "")
+}
+
+// ReceivePurchaseOrder registra una entrega: suma a las existencias lo recibido de cada línea
+// (received_<id>) con su costo por unidad (cost_<id>), que pasa a ser el último costo del
+// ingrediente. El costo queda en el movimiento de inventario y la línea conserva el costo
+// pedido. La orden se cierra cuando llega todo o si se marca close.
+func ReceivePurchaseOrder(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return Error(c, "ID inválido", fiber.StatusBadRequest)
+	}
+	closeOrder := c.FormValue("close") == "on"
+	actorID := currentUserID(c)
+
+	var order PurchaseOrder
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&order, id).Error; err != nil {
+			return fiber.NewError(fiber.StatusNotFound, "Orden de compra no encontrada")
+		}
+		if !order.IsOpen() {
+			return fiber.NewError(fiber.StatusBadRequest, "La orden de compra ya está cerrada")
+		}
+		order, err = loadPurchaseOrder(tx, order.ID)
+		if err != nil {
+			return err
+		}
+
+		notes := fmt.Sprintf("Orden de compra #%d, %s", order.ID, order.Supplier.Name)
+
+		received, complete := false, true
+		for _, line := range order.Lines {
+			key := strconv.FormatUint(uint64(line.ID), 10)
+			quantity := 0.0
+			if value := strings.TrimSpace(c.FormValue("received_" + key)); value != "" {
+				quantity, err = parseQuantity(value)
+				if err != nil || quantity < 0 {
+					return fiber.NewError(fiber.StatusBadRequest, "Cantidad inválida para "+line.Ingredient.Name)
+				}
+			}
+			unitCost := line.UnitCost
+			if value := strings.TrimSpace(c.FormValue("cost_" + key)); value != "" {
+				unitCost, err = ParseMoney(value)
+				if err != nil || unitCost < 0 {
+					return fiber.NewError(fiber.StatusBadRequest, "Costo inválido para "+line.Ingredient.Name)
+				}
+			}
+
+			if quantity > 0 {
+				received = true
+				err := changeStock(tx, StockMovement{
+					IngredientID:    line.IngredientID,
+					Quantity:        quantity,
+					Reason:          StockPurchase,
+					UserID:          actorID,
+					Notes:           notes,
+					PurchaseOrderID: &order.ID,
+					UnitCost:        unitCost,
+				})
+				if err != nil {
+					return err
+				}
+				if err := tx.Model(&Ingredient{}).Where("id = ?", line.IngredientID).Update("last_cost", unitCost).Error; err != nil {
+					return err
+				}
+				line.ReceivedQuantity += quantity
+				if err := tx.Model(&line).Update("received_quantity", line.ReceivedQuantity).Error; err != nil {
+					return err
+				}
+			}
+			if line.Pending() > 0 {
+				complete = false
+			}
+		}
+		if !received && !closeOrder {
+			return fiber.NewError(fiber.StatusBadRequest, "Indica alguna cantidad recibida")
+		}
+
+		now := time.Now()
+		updates := map[string]interface{}{}
+		if order.OrderedAt == nil {
+			updates["ordered_at"] = now
+		}
+		if complete || closeOrder {
+			updates["status"] = PurchaseReceived
+			updates["received_at"] = now
+		} else {
+			updates["status"] = PurchaseOrdered
+		}
+		if err := tx.Model(&order).Updates(updates).Error; err != nil {
+			return err
+		}
+		return refreshStockAvailability(tx)
+	})
+	if err != nil {
+		return purchaseErrorResponse(c, err)
+	}
+
+	c.Set("HX-Trigger", `{"showToast": "Recepción registrada", "toastType": "success", "purchaseOrdersChanged": true, "stockChanged": true}`)
+	return renderPurchaseOrder(c, order.ID)
+}
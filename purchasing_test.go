@@ -0,0 +1,29 @@
+package main
+
+import "testing"
+
+func TestPurchaseOrderReceiptsKeepOrderedCost(t *testing.T) {
+	order := PurchaseOrder{ID: 3, Lines: []PurchaseOrderLine{
+		{IngredientID: 1, Quantity: 10, UnitCost: 200, ReceivedQuantity: 10},
+		{IngredientID: 2, Quantity: 4, UnitCost: 1000, ReceivedQuantity: 1.5},
+		{IngredientID: 3, Quantity: 2, UnitCost: 500},
+	}}
+	// Dos recepciones parciales de la harina a costos distintos, y una del aceite
+	order.applyReceipts([]StockMovement{
+		{IngredientID: 1, Quantity: 6, UnitCost: 200},
+		{IngredientID: 1, Quantity: 4, UnitCost: 250},
+		{IngredientID: 2, Quantity: 1.5, UnitCost: 1100},
+	})
+
+	if got := order.Total(); got != 2000+4000+1000 {
+		t.Errorf("total pedido = %s, se esperaba 70.00 al costo pedido", got)
+	}
+	for i, want := range []Money{1200 + 1000, 1650, 0} {
+		if got := order.Lines[i].ReceivedCost; got != want {
+			t.Errorf("línea %d: costo recibido = %s, se esperaba %s", i, got, want)
+		}
+	}
+	if got := order.ReceivedTotal(); got != 3850 {
+		t.Errorf("total recibido = %s, se esperaba 38.50", got)
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/mail"
+	"strconv"
+	"text/template"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// buildReceipt arma el ticket de cobro ESC/POS de una orden con Items y Payments cargados
+func buildReceipt(order Order, settings Settings) []byte {
+	money := settings.FormatMoney
+
+	p := newESCPOS().Center()
+	if settings.LogoPath != "" {
+		if logo, err := loadPrinterImage(settings.LogoPath); err == nil {
+			p.Image(logo)
+		} else {
+			log.Printf("No se pudo cargar el logo para el ticket: %v", err)
+		}
+	}
+	p.Bold(true).Double(true).Line(settings.RestaurantName).Double(false).Bold(false)
+	if settings.Address != "" {
+		p.Line(settings.Address)
+	}
+	if settings.Phone != "" {
+		p.Line("Tel. " + settings.Phone)
+	}
+
+	p.Left().Separator(receiptColumns).
+		Columns(fmt.Sprintf("Orden #%d", order.ID), "Mesa "+strconv.Itoa(order.TableNum), receiptColumns)
+	closedAt := order.CreatedAt
+	if order.CompletedAt != nil {
+		closedAt = *order.CompletedAt
+	}
+	p.Line(closedAt.Format("02/01/2006 15:04")).Separator(receiptColumns)
+
+	for _, item := range order.Items {
+		p.Columns(fmt.Sprintf("%d x %s", item.Quantity, item.ProductName), money(item.UnitPrice.Mul(item.Quantity)), receiptColumns)
+		if item.ModifierNames != "" {
+			for _, line := range wrapText(item.ModifierNames, receiptColumns-2) {
+				p.Line("  " + line)
+			}
+		}
+	}
+	p.Separator(receiptColumns).Columns("Subtotal", money(order.Subtotal), receiptColumns)
+
+	taxLabel := "Impuesto " + formatRate(order.TaxRate) + "%"
+	if order.PricesIncludeTax {
+		taxLabel += " (incluido)"
+	}
+	p.Columns(taxLabel, money(order.TaxAmount), receiptColumns)
+	if order.ServiceCharge > 0 {
+		p.Columns("Cargo por servicio", money(order.ServiceCharge), receiptColumns)
+	}
+	if order.Tip > 0 {
+		p.Columns("Propina", money(order.Tip), receiptColumns)
+	}
+	p.Bold(true).Columns("TOTAL", money(order.Total), receiptColumns).Bold(false)
+
+	if len(order.Payments) > 0 {
+		p.Separator(receiptColumns)
+		for _, payment := range order.Payments {
+			label := paymentMethodLabel(payment.Method)
+			if payment.RefundOfID != nil {
+				label = "Reembolso " + label
+			}
+			p.Columns(label, money(payment.Amount), receiptColumns)
+			if payment.Tendered > 0 {
+				p.Columns("Recibido", money(payment.Tendered), receiptColumns).
+					Columns("Cambio", money(payment.Change), receiptColumns)
+			}
+		}
+	}
+
+	return p.Center().Line("").Line("¡Gracias por su visita!").Cut().Bytes()
+}
+
+// printReceipt imprime el ticket de cobro de la orden en la impresora predeterminada
+func printReceipt(orderID uint) error {
+	var order Order
+	if err := db.Preload("Items", func(tx *gorm.DB) *gorm.DB {
+		return orderLines(tx).Order("id ASC")
+	}).Preload("Payments", func(tx *gorm.DB) *gorm.DB {
+		return tx.Order("id ASC")
+	}).First(&order, orderID).Error; err != nil {
+		return err
+	}
+	settings := loadSettings(db)
+	return sendToPrinter(settings.DefaultPrinter, buildReceipt(order, settings))
+}
+
+// autoPrintReceipt imprime en segundo plano el ticket de una orden pagada si AutoPrint está activo
+func autoPrintReceipt(orderID uint) {
+	if !loadSettings(db).AutoPrint {
+		return
+	}
+	go func() {
+		if err := printReceipt(orderID); err != nil {
+			log.Printf("Error al imprimir el ticket de la orden #%d: %v", orderID, err)
+		}
+	}()
+}
+
+// buildReceiptEmail arma el recibo por correo de una orden (templates/emails/receipt.html y receipt.txt);
+// la orden debe venir de preloadReportOrder. El destinatario lo completa quien envía.
+func buildReceiptEmail(order Order, settings Settings) (EmailMessage, error) {
+	data := fiber.Map{
+		"Order":    order,
+		"Settings": settings,
+	}
+
+	var html bytes.Buffer
+	if views == nil {
+		return EmailMessage{}, fmt.Errorf("plantillas no cargadas")
+	}
+	if err := views.Render(&html, "emails/receipt", data); err != nil {
+		return EmailMessage{}, err
+	}
+
+	text, err := template.New("receipt.txt").Funcs(template.FuncMap{
+		"mul":                func(amount Money, quantity int) Money { return amount.Mul(quantity) },
+		"formatRate":         formatRate,
+		"paymentMethodLabel": paymentMethodLabel,
+	}).ParseFiles("./templates/emails/receipt.txt")
+	if err != nil {
+		return EmailMessage{}, err
+	}
+	var plain bytes.Buffer
+	if err := text.Execute(&plain, data); err != nil {
+		return EmailMessage{}, err
+	}
+
+	from := settings.SMTPFrom
+	if from == "" {
+		from = settings.Email
+	}
+	return EmailMessage{
+		From:    (&mail.Address{Name: settings.RestaurantName, Address: from}).String(),
+		Subject: fmt.Sprintf("Su recibo de %s - Orden #%d", settings.RestaurantName, order.ID),
+		Text:    plain.String(),
+		HTML:    html.String(),
+	}, nil
+}
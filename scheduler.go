@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronSchedule es un horario con el formato de cron de cinco campos:
+// minuto, hora, día del mes, mes y día de la semana (0 = domingo).
+// Cada campo admite "*", valores, rangos "a-b", listas "a,b" y pasos "*/n" o "a-b/n".
+type cronSchedule struct {
+	minutes, hours, days, months, weekdays map[int]bool
+	// Como en cron, si se restringen día del mes y día de la semana basta con que coincida uno
+	anyDay, anyWeekday bool
+}
+
+// parseCronSchedule interpreta un horario, por ejemplo "0 3 * * *" (todos los días a las 3:00)
+func parseCronSchedule(spec string) (cronSchedule, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return cronSchedule{}, fmt.Errorf("se esperaban 5 campos y hay %d", len(fields))
+	}
+	limits := [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+	names := [5]string{"minuto", "hora", "día", "mes", "día de la semana"}
+	var sets [5]map[int]bool
+	for i, field := range fields {
+		set, err := parseCronField(field, limits[i][0], limits[i][1])
+		if err != nil {
+			return cronSchedule{}, fmt.Errorf("%s: %w", names[i], err)
+		}
+		sets[i] = set
+	}
+	// 7 también es domingo
+	if sets[4][7] {
+		sets[4][0] = true
+	}
+	return cronSchedule{
+		minutes: sets[0], hours: sets[1], days: sets[2], months: sets[3], weekdays: sets[4],
+		anyDay: fields[2] == "*", anyWeekday: fields[4] == "*",
+	}, nil
+}
+
+// parseCronField devuelve los valores de un campo dentro de [low, high]
+func parseCronField(field string, low, high int) (map[int]bool, error) {
+	set := map[int]bool{}
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if rangePart, stepPart, ok := strings.Cut(part, "/"); ok {
+			n, err := strconv.Atoi(stepPart)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("paso inválido %q", stepPart)
+			}
+			part, step = rangePart, n
+		}
+
+		from, to := low, high
+		if part != "*" {
+			first, last, isRange := strings.Cut(part, "-")
+			var err error
+			if from, err = strconv.Atoi(first); err != nil {
+				return nil, fmt.Errorf("valor inválido %q", first)
+			}
+			to = from
+			if isRange {
+				if to, err = strconv.Atoi(last); err != nil {
+					return nil, fmt.Errorf("valor inválido %q", last)
+				}
+			} else if step > 1 {
+				to = high
+			}
+		}
+		if from < low || to > high || from > to {
+			return nil, fmt.Errorf("%q fuera de rango (%d-%d)", part, low, high)
+		}
+		for v := from; v <= to; v += step {
+			set[v] = true
+		}
+	}
+	return set, nil
+}
+
+// Matches indica si el horario se cumple en el minuto de t
+func (s cronSchedule) Matches(t time.Time) bool {
+	if !s.minutes[t.Minute()] || !s.hours[t.Hour()] || !s.months[int(t.Month())] {
+		return false
+	}
+	day, weekday := s.days[t.Day()], s.weekdays[int(t.Weekday())]
+	switch {
+	case s.anyDay && s.anyWeekday:
+		return true
+	case s.anyDay:
+		return weekday
+	case s.anyWeekday:
+		return day
+	}
+	return day || weekday
+}
+
+// Next devuelve el primer minuto posterior a after en que se cumple el horario (cero si no hay
+// ninguno en el próximo año, por ejemplo "0 0 31 2 *")
+func (s cronSchedule) Next(after time.Time) time.Time {
+	t := after.Truncate(time.Minute).Add(time.Minute)
+	for end := t.AddDate(1, 0, 0); t.Before(end); t = t.Add(time.Minute) {
+		if s.Matches(t) {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// backupScheduler crea los respaldos automáticos según Settings.BackupSchedule y aplica la
+// política de retención. Revisa el horario cada minuto, así los cambios de configuración
+// se aplican sin reiniciar; los horarios que caen con el servidor apagado se omiten.
+func backupScheduler() {
+	for {
+		now := time.Now()
+		time.Sleep(now.Truncate(time.Minute).Add(time.Minute).Sub(now))
+
+		settings := loadSettings(db)
+		if settings.BackupSchedule == "" {
+			continue
+		}
+		schedule, err := parseCronSchedule(settings.BackupSchedule)
+		if err != nil {
+			log.Printf("Horario de respaldos inválido %q: %v", settings.BackupSchedule, err)
+			continue
+		}
+		if schedule.Matches(time.Now()) {
+			runScheduledBackup(settings)
+		}
+	}
+}
+
+// runScheduledBackup crea un respaldo automático, poda los antiguos y guarda el resultado
+// en la configuración para mostrarlo en la página de respaldos
+func runScheduledBackup(settings Settings) {
+	ranAt := time.Now()
+	backup, err := createBackup(true)
+	if err == nil {
+		log.Printf("Respaldo automático creado: %s", backup.FileName)
+		err = pruneBackups(settings.BackupKeepDaily, settings.BackupKeepWeekly)
+	}
+
+	lastError := ""
+	if err != nil {
+		lastError = err.Error()
+		log.Printf("Error en el respaldo automático: %v", err)
+	}
+	db.Model(&Settings{}).Where("id = ?", settings.ID).Updates(map[string]interface{}{
+		"backup_last_run_at": ranAt,
+		"backup_last_error":  lastError,
+	})
+}
+
+// pruneBackups aplica la retención a los respaldos automáticos: conserva el más reciente de cada
+// uno de los últimos keepDaily días y de cada una de las últimas keepWeekly semanas, y elimina
+// el resto. Los respaldos manuales nunca se podan.
+func pruneBackups(keepDaily, keepWeekly int) error {
+	var backups []Backup
+	if err := db.Where("automatic = ?", true).Order("created_at DESC").Find(&backups).Error; err != nil {
+		return err
+	}
+
+	keep := map[uint]bool{}
+	days, weeks := map[string]bool{}, map[string]bool{}
+	for _, backup := range backups {
+		day := backup.CreatedAt.Format("2006-01-02")
+		if !days[day] && len(days) < keepDaily {
+			days[day] = true
+			keep[backup.ID] = true
+		}
+		year, week := backup.CreatedAt.ISOWeek()
+		weekKey := fmt.Sprintf("%d-%02d", year, week)
+		if !weeks[weekKey] && len(weeks) < keepWeekly {
+			weeks[weekKey] = true
+			keep[backup.ID] = true
+		}
+	}
+
+	var pruned []string
+	for _, backup := range backups {
+		if keep[backup.ID] {
+			continue
+		}
+		if err := deleteBackup(backup); err != nil {
+			return err
+		}
+		pruned = append(pruned, backup.FileName)
+	}
+	if len(pruned) > 0 {
+		sort.Strings(pruned)
+		log.Printf("Respaldos automáticos eliminados por retención: %s", strings.Join(pruned, ", "))
+	}
+	return nil
+}
@@ -1,16 +1,26 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"log"
+	"math"
 	"os"
-	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"gorm.io/gorm"
 )
 
+// loadSettings devuelve la configuración de la aplicación, o los valores por defecto si aún no existe
+func loadSettings(tx *gorm.DB) Settings {
+	settings := Settings{TaxRate: 0.16, CurrencySymbol: "$"}
+	tx.First(&settings)
+	return settings
+}
+
 func SettingsHandler(c *fiber.Ctx) error {
 	var settings Settings
 	result := db.First(&settings)
@@ -25,7 +35,7 @@ func SettingsHandler(c *fiber.Ctx) error {
 			Address:        "123 Calle Principal",
 			Phone:          "(555) 123-4567",
 			Email:          "contacto@resto.com",
-			DefaultPrinter: "thermal1",
+			DefaultPrinter: "",
 			AutoPrint:      true,
 			TableCount:     12,
 			DarkMode:       false,
@@ -58,11 +68,13 @@ func SettingsHandler(c *fiber.Ctx) error {
 	db.Order("created_at desc").Find(&backups)
 
 	return c.Render("settings", fiber.Map{
-		"Title":      "Configuración",
-		"ActivePage": "settings",
-		"Settings":   settings,
-		"Tables":     tables,
-		"Backups":    backups,
+		"Title":         "Configuración",
+		"ActivePage":    "settings",
+		"Settings":      settings,
+		"Tables":        tables,
+		"Backups":       backups,
+		"PrinterRoutes": printerRoutesData(),
+		"BackupData":    backupScheduleData(settings),
 	})
 }
 
@@ -81,28 +93,25 @@ func UpdateRestaurantSettings(c *fiber.Ctx) error {
 	settings.Phone = c.FormValue("phone")
 	settings.Email = c.FormValue("email")
 
+	// El logo se guarda en PNG para conservar la transparencia en tickets e informes
+	newLogo, oldLogo := "", settings.LogoPath
 	if file, err := c.FormFile("logo"); err == nil {
-		uploadDir := "./static/uploads"
-		os.MkdirAll(uploadDir, os.ModePerm)
-
-		filename := fmt.Sprintf("logo-%d%s", time.Now().Unix(), filepath.Ext(file.Filename))
-		filepath := filepath.Join(uploadDir, filename)
-
-		if err := c.SaveFile(file, filepath); err == nil {
-			if settings.LogoPath != "" && settings.LogoPath != "/static/uploads/"+filename {
-				oldPath := "." + settings.LogoPath
-				if _, err := os.Stat(oldPath); err == nil {
-					os.Remove(oldPath)
-				}
-			}
-			settings.LogoPath = "/static/uploads/" + filename
+		logoPath, err := saveUploadedImage(file, "logo", "png", logoImageVariants)
+		if err != nil {
+			setTrigger(c, fiber.Map{"showToast": "Logo no válido: " + err.Error(), "toastType": "error"})
+			return c.Status(fiber.StatusBadRequest).SendString("Logo no válido")
 		}
+		newLogo, settings.LogoPath = logoPath, logoPath
 	}
 
 	if result := db.Save(&settings); result.Error != nil {
+		removeUploadedImage(newLogo)
 		c.Set("HX-Trigger", `{"showToast": "Error al guardar la configuración", "toastType": "error"}`)
 		return c.Status(fiber.StatusInternalServerError).SendString("Error al guardar")
 	}
+	if newLogo != "" {
+		removeUploadedImage(oldLogo)
+	}
 
 	c.Set("HX-Trigger", `{"showToast": "Información del restaurante actualizada", "toastType": "success"}`)
 	return c.SendString("Configuración guardada")
@@ -112,8 +121,14 @@ func UpdatePrinterSettings(c *fiber.Ctx) error {
 	var settings Settings
 	db.First(&settings)
 
-	settings.DefaultPrinter = c.FormValue("default_printer")
+	settings.DefaultPrinter = strings.TrimSpace(c.FormValue("default_printer"))
 	settings.AutoPrint = c.FormValue("auto_print") == "on"
+	settings.KitchenPrinter = strings.TrimSpace(c.FormValue("kitchen_printer"))
+	for _, printer := range []string{settings.DefaultPrinter, settings.KitchenPrinter} {
+		if err := validatePrinterTarget(printer); err != nil {
+			return Error(c, err.Error(), fiber.StatusBadRequest)
+		}
+	}
 
 	if result := db.Save(&settings); result.Error != nil {
 		c.Set("HX-Trigger", `{"showToast": "Error al guardar la configuración", "toastType": "error"}`)
@@ -124,6 +139,58 @@ func UpdatePrinterSettings(c *fiber.Ctx) error {
 	return c.SendString("Configuración guardada")
 }
 
+// printerRoutesData arma los datos del partial de impresoras por categoría
+func printerRoutesData() fiber.Map {
+	var routes []CategoryPrinter
+	db.Joins("Category").Order("\"Category\".name").Find(&routes)
+
+	categories := loadCategories(db, false)
+
+	return fiber.Map{
+		"Routes":     routes,
+		"Categories": categories,
+	}
+}
+
+// SaveCategoryPrinter asigna (o reasigna) la impresora de comandas de una categoría
+func SaveCategoryPrinter(c *fiber.Ctx) error {
+	categoryID, _ := strconv.Atoi(c.FormValue("category_id"))
+	printer := strings.TrimSpace(c.FormValue("printer"))
+	if categoryID == 0 || printer == "" {
+		return Error(c, "Indica la categoría y la impresora", fiber.StatusBadRequest)
+	}
+	if err := validatePrinterTarget(printer); err != nil {
+		return Error(c, err.Error(), fiber.StatusBadRequest)
+	}
+	var category Category
+	if err := db.First(&category, categoryID).Error; err != nil {
+		return Error(c, "Categoría no encontrada", fiber.StatusNotFound)
+	}
+
+	route := CategoryPrinter{CategoryID: category.ID}
+	db.Where("category_id = ?", category.ID).First(&route)
+	route.Station = strings.TrimSpace(c.FormValue("station"))
+	route.Printer = printer
+	if err := db.Save(&route).Error; err != nil {
+		return Error(c, "Error al guardar la impresora", fiber.StatusInternalServerError)
+	}
+
+	setTrigger(c, fiber.Map{"showToast": "Impresora asignada a " + category.Name, "toastType": "success"})
+	return c.Render("partials/printer_routes", printerRoutesData(), "")
+}
+
+// DeleteCategoryPrinter quita la impresora propia de una categoría; vuelve a la de cocina general
+func DeleteCategoryPrinter(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).SendString("ID inválido")
+	}
+	db.Delete(&CategoryPrinter{}, id)
+
+	c.Set("HX-Trigger", `{"showToast": "Impresora de categoría eliminada", "toastType": "success"}`)
+	return c.Render("partials/printer_routes", printerRoutesData(), "")
+}
+
 func UpdateTableSettings(c *fiber.Ctx) error {
 	tableCount, err := strconv.Atoi(c.FormValue("tableCount"))
 	if err != nil || tableCount <= 0 {
@@ -177,16 +244,22 @@ func UpdateAppSettings(c *fiber.Ctx) error {
 	var settings Settings
 	db.First(&settings)
 
+	// Las tasas se validan antes de cambiar nada; vacías conservan el valor actual
+	taxRate, err := parseRate(c.FormValue("tax_rate"), settings.TaxRate)
+	if err != nil {
+		return Error(c, "Tasa de impuesto inválida: "+err.Error(), fiber.StatusBadRequest)
+	}
+	serviceChargeRate, err := parseRate(c.FormValue("service_charge_rate"), settings.ServiceChargeRate)
+	if err != nil {
+		return Error(c, "Cargo por servicio inválido: "+err.Error(), fiber.StatusBadRequest)
+	}
+
 	settings.DarkMode = c.FormValue("dark_mode") == "on"
 	settings.AutoRefresh = c.FormValue("auto_refresh") == "on"
 	settings.Language = c.FormValue("language")
-
-	taxRateStr := c.FormValue("tax_rate")
-	if taxRateStr != "" {
-		if taxRate, err := strconv.ParseFloat(taxRateStr, 64); err == nil {
-			settings.TaxRate = taxRate
-		}
-	}
+	settings.TaxRate = taxRate
+	settings.PricesIncludeTax = c.FormValue("prices_include_tax") == "on"
+	settings.ServiceChargeRate = serviceChargeRate
 
 	settings.CurrencySymbol = c.FormValue("currency_symbol")
 	if settings.CurrencySymbol == "" {
@@ -202,30 +275,134 @@ func UpdateAppSettings(c *fiber.Ctx) error {
 	return c.SendString("Configuración guardada")
 }
 
-func CreateBackup(c *fiber.Ctx) error {
-	backupDir := "./backups"
-	os.MkdirAll(backupDir, os.ModePerm)
+// parseRate lee una tasa expresada como fracción entre 0 y 1 (0.16 = 16%); acepta coma
+// decimal. Vacía devuelve current.
+func parseRate(value string, current float64) (float64, error) {
+	value = strings.ReplaceAll(strings.TrimSpace(value), ",", ".")
+	if value == "" {
+		return current, nil
+	}
+	rate, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(rate) {
+		return 0, fmt.Errorf("%q no es un número", value)
+	}
+	if rate < 0 || rate > 1 {
+		return 0, errors.New("debe ser una fracción entre 0 y 1, por ejemplo 0.16 para 16%")
+	}
+	return rate, nil
+}
 
-	timestamp := time.Now().Format("20060102-150405")
-	filename := "backup-" + timestamp + ".sql"
-	filePath := filepath.Join(backupDir, filename)
+// UpdateEmailSettings guarda el servidor SMTP usado para enviar recibos; la contraseña
+// solo cambia si se escribe una nueva
+func UpdateEmailSettings(c *fiber.Ctx) error {
+	var settings Settings
+	db.First(&settings)
 
-	dummyContent := fmt.Sprintf("-- PostgreSQL database dump\n-- Database: go_clean_menu\n-- Generated at: %s\n\n-- Tables: products, orders, order_items, tables, settings", time.Now().Format(time.RFC3339))
-	err := os.WriteFile(filePath, []byte(dummyContent), 0644)
-	if err != nil {
+	port, err := strconv.Atoi(c.FormValue("smtp_port"))
+	if err != nil || port <= 0 || port > 65535 {
+		c.Set("HX-Trigger", `{"showToast": "Puerto SMTP inválido", "toastType": "error"}`)
+		return c.Status(fiber.StatusBadRequest).SendString("Puerto inválido")
+	}
+
+	settings.SMTPHost = strings.TrimSpace(c.FormValue("smtp_host"))
+	settings.SMTPPort = port
+	settings.SMTPUsername = strings.TrimSpace(c.FormValue("smtp_username"))
+	if password := c.FormValue("smtp_password"); password != "" {
+		settings.SMTPPassword = password
+	}
+	settings.SMTPFrom = strings.TrimSpace(c.FormValue("smtp_from"))
+
+	if result := db.Save(&settings); result.Error != nil {
+		c.Set("HX-Trigger", `{"showToast": "Error al guardar la configuración", "toastType": "error"}`)
+		return c.Status(fiber.StatusInternalServerError).SendString("Error al guardar")
+	}
+
+	c.Set("HX-Trigger", `{"showToast": "Configuración de correo actualizada", "toastType": "success"}`)
+	return c.SendString("Configuración guardada")
+}
+
+// UpdateBackupSettings guarda el horario de los respaldos automáticos y su retención
+func UpdateBackupSettings(c *fiber.Ctx) error {
+	var settings Settings
+	db.First(&settings)
+
+	schedule := strings.Join(strings.Fields(c.FormValue("backup_schedule")), " ")
+	if schedule != "" {
+		if _, err := parseCronSchedule(schedule); err != nil {
+			return Error(c, "Horario inválido: "+err.Error(), fiber.StatusBadRequest)
+		}
+	}
+	keepDaily, err1 := strconv.Atoi(c.FormValue("backup_keep_daily"))
+	keepWeekly, err2 := strconv.Atoi(c.FormValue("backup_keep_weekly"))
+	if err1 != nil || err2 != nil || keepDaily < 0 || keepWeekly < 0 || keepDaily+keepWeekly == 0 {
+		return Error(c, "Indica cuántos respaldos conservar (al menos uno)", fiber.StatusBadRequest)
+	}
+
+	settings.BackupSchedule = schedule
+	settings.BackupKeepDaily = keepDaily
+	settings.BackupKeepWeekly = keepWeekly
+	if result := db.Save(&settings); result.Error != nil {
+		c.Set("HX-Trigger", `{"showToast": "Error al guardar la configuración", "toastType": "error"}`)
+		return c.Status(fiber.StatusInternalServerError).SendString("Error al guardar")
+	}
+
+	c.Set("HX-Trigger", `{"showToast": "Respaldos automáticos actualizados", "toastType": "success"}`)
+	return c.Render("partials/backup_schedule", backupScheduleData(settings), "")
+}
+
+// backupScheduleData arma los datos del partial de respaldos automáticos
+func backupScheduleData(settings Settings) fiber.Map {
+	data := fiber.Map{"Settings": settings}
+	if schedule, err := parseCronSchedule(settings.BackupSchedule); err == nil {
+		if next := schedule.Next(time.Now()); !next.IsZero() {
+			data["NextRun"] = next
+		}
+	}
+	return data
+}
+
+func CreateBackup(c *fiber.Ctx) error {
+	if _, err := createBackup(false); err != nil {
+		log.Printf("Error al crear el respaldo: %v", err)
 		c.Set("HX-Trigger", `{"showToast": "Error al crear el respaldo", "toastType": "error"}`)
 		return c.Status(fiber.StatusInternalServerError).SendString("Error al crear respaldo")
 	}
 
-	backup := Backup{
-		FileName: filename,
-		FilePath: filePath,
-		Size:     int64(len(dummyContent)),
+	c.Set("HX-Trigger", `{"showToast": "Respaldo creado correctamente", "toastType": "success"}`)
+	return GetBackupList(c)
+}
+
+// RestoreBackup reemplaza todos los datos por los de un respaldo. Antes guarda un respaldo
+// del estado actual para poder deshacer la restauración.
+func RestoreBackup(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).SendString("ID inválido")
+	}
+
+	var backup Backup
+	if result := db.First(&backup, id); result.Error != nil {
+		return c.Status(fiber.StatusNotFound).SendString("Respaldo no encontrado")
+	}
+	if err := verifyBackupChecksum(backup); err != nil {
+		return Error(c, "El archivo de respaldo no es válido: "+err.Error(), fiber.StatusUnprocessableEntity)
 	}
-	db.Create(&backup)
 
-	c.Set("HX-Trigger", `{"showToast": "Respaldo creado correctamente", "toastType": "success", "refreshBackups": true}`)
-	return c.SendString("Respaldo creado")
+	previous, err := createBackup(false)
+	if err != nil {
+		log.Printf("Error al respaldar antes de restaurar: %v", err)
+		return Error(c, "No se pudo respaldar el estado actual; no se restauró nada", fiber.StatusInternalServerError)
+	}
+	if err := restoreBackup(backup.FilePath); err != nil {
+		log.Printf("Error al restaurar el respaldo %s: %v", backup.FileName, err)
+		return Error(c, "No se pudo restaurar el respaldo: "+err.Error(), fiber.StatusUnprocessableEntity)
+	}
+	log.Printf("Respaldo %s restaurado (estado anterior en %s)", backup.FileName, previous.FileName)
+
+	// Las sesiones se cerraron al restaurar: se vuelve a la página de inicio de sesión
+	setTrigger(c, fiber.Map{"showToast": "Respaldo restaurado. El estado anterior quedó en " + previous.FileName, "toastType": "success"})
+	c.Set("HX-Redirect", "/login")
+	return c.SendString("Respaldo restaurado")
 }
 
 func GetBackupList(c *fiber.Ctx) error {
@@ -267,12 +444,11 @@ func DeleteBackup(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusNotFound).SendString("Respaldo no encontrado")
 	}
 
-	if _, err := os.Stat(backup.FilePath); err == nil {
-		os.Remove(backup.FilePath)
+	if err := deleteBackup(backup); err != nil {
+		log.Printf("Error al eliminar el respaldo %s: %v", backup.FileName, err)
+		return Error(c, "Error al eliminar el respaldo", fiber.StatusInternalServerError)
 	}
 
-	db.Delete(&backup)
-
 	c.Set("HX-Trigger", `{"showToast": "Respaldo eliminado", "toastType": "success"}`)
 	return GetBackupList(c)
 }
@@ -0,0 +1,31 @@
+package main
+
+import "testing"
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		value string
+		want  float64
+		ok    bool
+	}{
+		{"0.16", 0.16, true},
+		{"0,10", 0.10, true},
+		{" 1 ", 1, true},
+		{"0", 0, true},
+		{"", 0.08, true}, // Vacía conserva el valor actual
+		{"10", 0, false},
+		{"-0.05", 0, false},
+		{"abc", 0, false},
+		{"NaN", 0, false},
+	}
+	for _, tt := range tests {
+		got, err := parseRate(tt.value, 0.08)
+		if tt.ok != (err == nil) {
+			t.Errorf("parseRate(%q): error %v, se esperaba ok=%v", tt.value, err, tt.ok)
+			continue
+		}
+		if tt.ok && got != tt.want {
+			t.Errorf("parseRate(%q) = %v, se esperaba %v", tt.value, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// Motivos de los movimientos de existencias
+const (
+	StockSale       = "sale"       // Ítems enviados a cocina
+	StockCancel     = "cancel"     // Orden cancelada: vuelve lo que se había descontado
+	StockAdjustment = "adjustment" // Conteo o corrección manual
+	StockPurchase   = "purchase"   // Recepción de una orden de compra
+)
+
+// stockReasonLabels son los nombres de los motivos para mostrar
+var stockReasonLabels = map[string]string{
+	StockSale:       "Venta",
+	StockCancel:     "Cancelación",
+	StockAdjustment: "Ajuste",
+	StockPurchase:   "Compra",
+}
+
+// formatQuantity muestra una cantidad de inventario con hasta tres decimales, sin ceros de más
+func formatQuantity(quantity float64) string {
+	text := strconv.FormatFloat(quantity, 'f', 3, 64)
+	text = strings.TrimRight(strings.TrimRight(text, "0"), ".")
+	if text == "-0" {
+		return "0"
+	}
+	return text
+}
+
+// parseQuantity lee una cantidad de inventario; acepta coma o punto decimal
+func parseQuantity(s string) (float64, error) {
+	quantity, err := strconv.ParseFloat(strings.ReplaceAll(strings.TrimSpace(s), ",", "."), 64)
+	if err != nil || math.IsNaN(quantity) || math.IsInf(quantity, 0) {
+		return 0, fmt.Errorf("cantidad inválida %q", s)
+	}
+	return quantity, nil
+}
+
+// changeStock suma movement.Quantity (negativa para descontar) a las existencias del
+// ingrediente y registra el movimiento. La suma se hace en la base para no perder cambios
+// concurrentes.
+func changeStock(tx *gorm.DB, movement StockMovement) error {
+	if movement.Quantity == 0 {
+		return nil
+	}
+	if err := tx.Model(&Ingredient{}).Where("id = ?", movement.IngredientID).
+		Update("on_hand", gorm.Expr("on_hand + ?", movement.Quantity)).Error; err != nil {
+		return err
+	}
+	return tx.Create(&movement).Error
+}
+
+// applyRecipes mueve las existencias de las recetas de los ítems: units devuelve cuántas
+// unidades de cada ítem se descuentan (positivo) o se devuelven (negativo)
+func applyRecipes(tx *gorm.DB, orderID uint, items []OrderItem, units func(OrderItem) int, reason string, actorID *uint) error {
+	recipes := map[uint][]RecipeLine{}
+	for _, item := range items {
+		lines, ok := recipes[item.ProductID]
+		if !ok {
+			if err := tx.Where("product_id = ?", item.ProductID).Find(&lines).Error; err != nil {
+				return err
+			}
+			recipes[item.ProductID] = lines
+		}
+		n := units(item)
+		for _, line := range lines {
+			err := changeStock(tx, StockMovement{
+				IngredientID: line.IngredientID,
+				Quantity:     -line.Quantity * float64(n),
+				Reason:       reason,
+				OrderID:      &orderID,
+				UserID:       actorID,
+				Notes:        item.ProductName,
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// deductOrderStock descuenta del inventario los ingredientes de los ítems de la orden que
+// llegaron a cocina y aún no se descontaron, o cuya cantidad aumentó después. Es idempotente:
+// se llama al enviar la orden a cocina y al cambiar ítems de una orden ya enviada.
+//
+// Lo que se quita de un ítem ya enviado se da por preparado: sus ingredientes no vuelven al
+// inventario y stock_deducted baja a la nueva cantidad, así que si después aumenta se vuelve
+// a descontar y una cancelación solo devuelve lo que la orden tiene.
+func deductOrderStock(tx *gorm.DB, orderID uint, actorID *uint) error {
+	if err := tx.Model(&OrderItem{}).Where("order_id = ? AND stock_deducted > quantity", orderID).
+		Update("stock_deducted", gorm.Expr("quantity")).Error; err != nil {
+		return err
+	}
+
+	var items []OrderItem
+	if err := tx.Where("order_id = ? AND quantity > stock_deducted", orderID).Find(&items).Error; err != nil {
+		return err
+	}
+	if len(items) == 0 {
+		return nil
+	}
+	err := applyRecipes(tx, orderID, items, func(item OrderItem) int {
+		return item.Quantity - item.StockDeducted
+	}, StockSale, actorID)
+	if err != nil {
+		return err
+	}
+	for _, item := range items {
+		if err := tx.Model(&OrderItem{}).Where("id = ?", item.ID).Update("stock_deducted", item.Quantity).Error; err != nil {
+			return err
+		}
+	}
+	return refreshStockAvailability(tx)
+}
+
+// deductSentOrderStock descuenta los ingredientes de lo agregado o aumentado en una orden que
+// ya se envió a cocina, y ajusta lo reducido; en las demás se descuentan al enviarla (ver applyTransition)
+func deductSentOrderStock(tx *gorm.DB, order Order, actorID *uint) error {
+	if order.SentToKitchenAt == nil {
+		return nil
+	}
+	return deductOrderStock(tx, order.ID, actorID)
+}
+
+// restoreOrderStock devuelve al inventario todo lo que se descontó de una orden cancelada.
+// Lo quitado o reducido después de enviarlo a cocina no se devuelve: ya se preparó, así que
+// nunca se devuelven más unidades que la cantidad actual del ítem.
+func restoreOrderStock(tx *gorm.DB, orderID uint, actorID *uint) error {
+	var items []OrderItem
+	if err := tx.Where("order_id = ? AND stock_deducted > 0", orderID).Find(&items).Error; err != nil {
+		return err
+	}
+	if len(items) == 0 {
+		return nil
+	}
+	err := applyRecipes(tx, orderID, items, func(item OrderItem) int {
+		return -min(item.StockDeducted, item.Quantity)
+	}, StockCancel, actorID)
+	if err != nil {
+		return err
+	}
+	if err := tx.Model(&OrderItem{}).Where("order_id = ?", orderID).Update("stock_deducted", 0).Error; err != nil {
+		return err
+	}
+	return refreshStockAvailability(tx)
+}
+
+// refreshStockAvailability marca no disponibles los productos a los que les falta algún
+// ingrediente para una unidad, y vuelve a habilitar los que marcó así y ya se repusieron.
+// Los productos desactivados a mano no se tocan.
+func refreshStockAvailability(tx *gorm.DB) error {
+	missing := func() *gorm.DB {
+		return tx.Table("recipe_lines").
+			Select("recipe_lines.product_id").
+			Joins("JOIN ingredients ON ingredients.id = recipe_lines.ingredient_id").
+			Where("ingredients.on_hand < recipe_lines.quantity")
+	}
+
+	if err := tx.Model(&Product{}).Where("is_available = ? AND id IN (?)", true, missing()).
+		Updates(map[string]interface{}{"is_available": false, "out_of_stock": true}).Error; err != nil {
+		return err
+	}
+	return tx.Model(&Product{}).Where("out_of_stock = ? AND id NOT IN (?)", true, missing()).
+		Updates(map[string]interface{}{"is_available": true, "out_of_stock": false}).Error
+}
+
+// checkProductsAvailable devuelve un error 400 si alguno de los productos ya no está disponible,
+// desactivado a mano o agotado por el inventario
+func checkProductsAvailable(tx *gorm.DB, productIDs []uint) error {
+	var unavailable Product
+	result := tx.Where("id IN ? AND is_available = ?", productIDs, false).Limit(1).Find(&unavailable)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected > 0 {
+		return fiber.NewError(fiber.StatusBadRequest, unavailable.Name+" no está disponible")
+	}
+	return nil
+}
+
+// lowStockIngredients devuelve los ingredientes con alerta: en o bajo su umbral, o agotados
+func lowStockIngredients() []Ingredient {
+	var ingredients []Ingredient
+	db.Where("(low_stock > 0 AND on_hand <= low_stock) OR on_hand <= 0").Order("on_hand / NULLIF(low_stock, 0), name").Find(&ingredients)
+	return ingredients
+}
@@ -70,7 +70,7 @@ func CreateTable(c *fiber.Ctx) error {
 	var tables []Table
 	db.Order("number").Find(&tables)
 
-	c.Set("HX-Trigger", `{"showToast": "Mesa #`+strconv.Itoa(tableNum)+` creada correctamente"}`)
+	setTrigger(c, fiber.Map{"showToast": "Mesa #" + strconv.Itoa(tableNum) + " creada correctamente"})
 	return c.Render("partials/tables_grid", fiber.Map{
 		"Tables": tables,
 	}, "")
@@ -101,7 +101,7 @@ func DeleteTable(c *fiber.Ctx) error {
 	var tables []Table
 	db.Order("number").Find(&tables)
 
-	c.Set("HX-Trigger", `{"showToast": "Mesa #`+strconv.Itoa(table.Number)+` eliminada correctamente"}`)
+	setTrigger(c, fiber.Map{"showToast": "Mesa #" + strconv.Itoa(table.Number) + " eliminada correctamente"})
 	return c.Render("partials/tables_grid", fiber.Map{
 		"Tables": tables,
 	}, "")
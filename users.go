@@ -0,0 +1,339 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// Longitud mínima de las contraseñas de usuario
+const minPasswordLength = 6
+
+// validRole indica si el rol es uno de los soportados
+func validRole(role string) bool {
+	return role == RoleAdmin || role == RoleWaiter || role == RoleCook
+}
+
+// DisplayName devuelve el nombre completo del usuario o, si no tiene, su nombre de usuario
+func (u User) DisplayName() string {
+	if u.FullName != "" {
+		return u.FullName
+	}
+	return u.Username
+}
+
+// unscopedUsers permite precargar usuarios eliminados para mostrar quién realizó una acción
+func unscopedUsers(tx *gorm.DB) *gorm.DB {
+	return tx.Unscoped()
+}
+
+// isLastActiveAdmin indica si el usuario es el único administrador activo
+func isLastActiveAdmin(user User) bool {
+	if user.Role != RoleAdmin || !user.Active {
+		return false
+	}
+	var count int64
+	db.Model(&User{}).Where("role = ? AND active = ? AND id <> ?", RoleAdmin, true, user.ID).Count(&count)
+	return count == 0
+}
+
+// bootstrapAdmin crea una cuenta de administrador cuando no existe ningún usuario
+func bootstrapAdmin() {
+	username := os.Getenv("ADMIN_USERNAME")
+	if username == "" {
+		username = "admin"
+	}
+
+	password := os.Getenv("ADMIN_PASSWORD")
+	generated := password == ""
+	if generated {
+		buf := make([]byte, 8)
+		if _, err := rand.Read(buf); err != nil {
+			log.Fatalf("Error al generar la contraseña del administrador: %v", err)
+		}
+		password = hex.EncodeToString(buf)
+	}
+
+	hash, err := hashPassword(password)
+	if err != nil {
+		log.Fatalf("Error al generar el hash de la contraseña: %v", err)
+	}
+
+	// Si existe un usuario eliminado con el mismo nombre, restaurarlo en lugar de violar el índice único
+	var admin User
+	if db.Unscoped().Where("username = ?", username).First(&admin).Error == nil {
+		admin.DeletedAt = gorm.DeletedAt{}
+	} else {
+		admin = User{Username: username, FullName: "Administrador"}
+	}
+	admin.Password = hash
+	admin.Role = RoleAdmin
+	admin.Active = true
+
+	if err := db.Unscoped().Save(&admin).Error; err != nil {
+		log.Fatalf("Error al crear el usuario administrador: %v", err)
+	}
+
+	if generated {
+		log.Printf("Se creó el usuario administrador %q con la contraseña temporal: %s", username, password)
+		log.Println("Cambie esta contraseña desde Usuarios después de iniciar sesión")
+	} else {
+		log.Printf("Se creó el usuario administrador %q", username)
+	}
+}
+
+// renderUserList devuelve la lista actualizada de usuarios
+func renderUserList(c *fiber.Ctx) error {
+	var users []User
+	db.Order("username").Find(&users)
+
+	return c.Render("partials/user_list", fiber.Map{
+		"Users": users,
+	}, "")
+}
+
+// UsersHandler muestra la página de administración de usuarios
+func UsersHandler(c *fiber.Ctx) error {
+	var users []User
+	db.Order("username").Find(&users)
+
+	roleCounts := map[string]int{}
+	activeCount := 0
+	for _, u := range users {
+		roleCounts[u.Role]++
+		if u.Active {
+			activeCount++
+		}
+	}
+
+	return c.Render("users", fiber.Map{
+		"Title":       "Usuarios",
+		"ActivePage":  "users",
+		"Users":       users,
+		"ActiveCount": activeCount,
+		"RoleCounts":  roleCounts,
+	})
+}
+
+// GetUserForm muestra el formulario para crear un usuario
+func GetUserForm(c *fiber.Ctx) error {
+	return c.Render("partials/user_form", fiber.Map{
+		"IsNew": true,
+	}, "")
+}
+
+// GetUserEditForm muestra el formulario para editar un usuario
+func GetUserEditForm(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).SendString("ID inválido")
+	}
+
+	var user User
+	if result := db.First(&user, id); result.Error != nil {
+		return c.Status(fiber.StatusNotFound).SendString("Usuario no encontrado")
+	}
+
+	return c.Render("partials/user_form", fiber.Map{
+		"User":  user,
+		"IsNew": false,
+	}, "")
+}
+
+// GetUserPasswordForm muestra el formulario para restablecer la contraseña
+func GetUserPasswordForm(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).SendString("ID inválido")
+	}
+
+	var user User
+	if result := db.First(&user, id); result.Error != nil {
+		return c.Status(fiber.StatusNotFound).SendString("Usuario no encontrado")
+	}
+
+	return c.Render("partials/user_password_form", fiber.Map{
+		"User": user,
+	}, "")
+}
+
+// CreateUser crea una nueva cuenta de personal
+func CreateUser(c *fiber.Ctx) error {
+	username := strings.TrimSpace(c.FormValue("username"))
+	password := c.FormValue("password")
+	role := c.FormValue("role")
+
+	if username == "" || password == "" {
+		return Error(c, "Usuario y contraseña son obligatorios", fiber.StatusBadRequest)
+	}
+	if len(password) < minPasswordLength {
+		return Error(c, "La contraseña debe tener al menos "+strconv.Itoa(minPasswordLength)+" caracteres", fiber.StatusBadRequest)
+	}
+	if !validRole(role) {
+		return Error(c, "Rol inválido", fiber.StatusBadRequest)
+	}
+
+	// Incluir usuarios eliminados: el nombre de usuario sigue reservado por el índice único
+	var count int64
+	db.Unscoped().Model(&User{}).Where("username = ?", username).Count(&count)
+	if count > 0 {
+		return Error(c, "El nombre de usuario ya existe", fiber.StatusBadRequest)
+	}
+
+	hash, err := hashPassword(password)
+	if err != nil {
+		log.Printf("Error al generar hash de contraseña: %v", err)
+		return Error(c, "Error al crear el usuario", fiber.StatusInternalServerError)
+	}
+
+	user := User{
+		Username: username,
+		Password: hash,
+		FullName: strings.TrimSpace(c.FormValue("full_name")),
+		Email:    strings.TrimSpace(c.FormValue("email")),
+		Role:     role,
+		Active:   true,
+	}
+
+	if result := db.Create(&user); result.Error != nil {
+		log.Printf("Error al crear usuario: %v", result.Error)
+		return Error(c, "Error al crear el usuario", fiber.StatusInternalServerError)
+	}
+
+	setTrigger(c, fiber.Map{"showToast": "Usuario '" + username + "' creado correctamente", "closeModal": true})
+	return renderUserList(c)
+}
+
+// UpdateUser actualiza los datos y el rol de un usuario
+func UpdateUser(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return Error(c, "ID inválido", fiber.StatusBadRequest)
+	}
+
+	var user User
+	if result := db.First(&user, id); result.Error != nil {
+		return Error(c, "Usuario no encontrado", fiber.StatusNotFound)
+	}
+
+	role := c.FormValue("role")
+	if !validRole(role) {
+		return Error(c, "Rol inválido", fiber.StatusBadRequest)
+	}
+
+	// No permitir dejar el sistema sin administradores activos
+	if role != RoleAdmin && isLastActiveAdmin(user) {
+		return Error(c, "No se puede cambiar el rol del último administrador activo", fiber.StatusBadRequest)
+	}
+
+	user.FullName = strings.TrimSpace(c.FormValue("full_name"))
+	user.Email = strings.TrimSpace(c.FormValue("email"))
+	user.Role = role
+
+	if result := db.Save(&user); result.Error != nil {
+		log.Printf("Error al actualizar usuario: %v", result.Error)
+		return Error(c, "Error al actualizar el usuario", fiber.StatusInternalServerError)
+	}
+
+	setTrigger(c, fiber.Map{"showToast": "Usuario '" + user.Username + "' actualizado correctamente", "closeModal": true})
+	return renderUserList(c)
+}
+
+// ToggleUserActive activa o desactiva una cuenta de usuario
+func ToggleUserActive(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return Error(c, "ID inválido", fiber.StatusBadRequest)
+	}
+
+	var user User
+	if result := db.First(&user, id); result.Error != nil {
+		return Error(c, "Usuario no encontrado", fiber.StatusNotFound)
+	}
+
+	if user.Active && isLastActiveAdmin(user) {
+		return Error(c, "No se puede desactivar el último administrador activo", fiber.StatusBadRequest)
+	}
+
+	user.Active = !user.Active
+	if result := db.Model(&user).Update("active", user.Active); result.Error != nil {
+		log.Printf("Error al cambiar estado de usuario: %v", result.Error)
+		return Error(c, "Error al actualizar el usuario", fiber.StatusInternalServerError)
+	}
+
+	message := "Usuario '" + user.Username + "' activado"
+	if !user.Active {
+		message = "Usuario '" + user.Username + "' desactivado"
+	}
+	setTrigger(c, fiber.Map{"showToast": message})
+	return renderUserList(c)
+}
+
+// ResetUserPassword asigna una nueva contraseña a un usuario
+func ResetUserPassword(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return Error(c, "ID inválido", fiber.StatusBadRequest)
+	}
+
+	var user User
+	if result := db.First(&user, id); result.Error != nil {
+		return Error(c, "Usuario no encontrado", fiber.StatusNotFound)
+	}
+
+	password := c.FormValue("password")
+	if len(password) < minPasswordLength {
+		return Error(c, "La contraseña debe tener al menos "+strconv.Itoa(minPasswordLength)+" caracteres", fiber.StatusBadRequest)
+	}
+	if password != c.FormValue("password_confirm") {
+		return Error(c, "Las contraseñas no coinciden", fiber.StatusBadRequest)
+	}
+
+	hash, err := hashPassword(password)
+	if err != nil {
+		log.Printf("Error al generar hash de contraseña: %v", err)
+		return Error(c, "Error al restablecer la contraseña", fiber.StatusInternalServerError)
+	}
+
+	if result := db.Model(&user).Update("password", hash); result.Error != nil {
+		log.Printf("Error al restablecer contraseña: %v", result.Error)
+		return Error(c, "Error al restablecer la contraseña", fiber.StatusInternalServerError)
+	}
+
+	setTrigger(c, fiber.Map{"showToast": "Contraseña de '" + user.Username + "' restablecida", "closeModal": true})
+	return renderUserList(c)
+}
+
+// DeleteUser elimina (soft delete) una cuenta de usuario
+func DeleteUser(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return Error(c, "ID inválido", fiber.StatusBadRequest)
+	}
+
+	var user User
+	if result := db.First(&user, id); result.Error != nil {
+		return Error(c, "Usuario no encontrado", fiber.StatusNotFound)
+	}
+
+	if me := currentUser(c); me != nil && me.ID == user.ID {
+		return Error(c, "No puede eliminar su propia cuenta", fiber.StatusBadRequest)
+	}
+	if isLastActiveAdmin(user) {
+		return Error(c, "No se puede eliminar el último administrador activo", fiber.StatusBadRequest)
+	}
+
+	if result := db.Delete(&user); result.Error != nil {
+		log.Printf("Error al eliminar usuario: %v", result.Error)
+		return Error(c, "Error al eliminar el usuario", fiber.StatusInternalServerError)
+	}
+
+	setTrigger(c, fiber.Map{"showToast": "Usuario '" + user.Username + "' eliminado"})
+	return renderUserList(c)
+}